@@ -2,11 +2,14 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/validation"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v4"
@@ -25,34 +28,70 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 	// read a json payload
 	err := app.readJSON(w, r, &creds)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.problemJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	// don't even look at the password while the account or the client is locked out
+	ip := clientIP(r)
+	keys := loginKeys(creds.Username, ip)
+	if wait := app.loginWait(keys...); wait > 0 {
+		app.tooManyLogins(w, wait)
 		return
 	}
 
 	// look up the user by email address
 	user, err := app.DB.GetUserByEmail(creds.Username)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.loginFailed(ip, keys...)
+		app.problemJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
 		return
 	}
 
 	// check password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(creds.Password))
 	if err != nil {
-		app.errorJSON(w, errors.New("unahtorized"), http.StatusUnauthorized)
+		app.loginFailed(ip, keys...)
+		app.problemJSON(w, errors.New("unahtorized"), http.StatusUnauthorized)
 		return
 	}
 
-	// generate tokens
-	tokenPairs, err := app.generateTokenPairs(user)
+	// accounts signed up in the web app can't be used before their email address is confirmed
+	if !user.Verified() {
+		app.problemJSON(w, errors.New("the email address of the account is not confirmed"), http.StatusForbidden)
+		return
+	}
+
+	// with two-factor authentication, the password only earns a challenge; the failed logins of the
+	// account are forgiven once the second factor is in as well
+	mfa, err := app.requiresMFA(user.ID)
 	if err != nil {
-		app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		app.problemJSON(w, err, http.StatusInternalServerError)
 		return
 	}
+	if mfa {
+		mfaToken, err := app.generateMFAToken(user)
+		if err != nil {
+			app.problemJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		_ = app.writeJSON(w, http.StatusOK, MFAChallenge{MFARequired: true, MFAToken: mfaToken})
+		return
+	}
+	app.loginSucceeded(creds.Username)
+
+	// generate tokens, starting a new refresh token family for this login
+	tokenPairs, err := app.issueTokenPairs(user, r.UserAgent())
+	if err != nil {
+		app.problemJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{Actor: fmt.Sprint(user.ID), Action: "login", Target: fmt.Sprintf("user:%d", user.ID), Details: "password"})
 
 	// send token to user
 	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
-
 }
 
 func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
@@ -65,37 +104,67 @@ func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 	refreshToken := r.Form.Get("refresh_token")
 	claims := &Claims{}
 
-	_, err = jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(app.JWTSecret), nil
-	})
+	_, err = jwt.ParseWithClaims(refreshToken, claims, app.Keys.Keyfunc)
 
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.problemJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
-	if time.Unix(claims.ExpiresAt.Unix(), 0).Sub(time.Now()) > 30*time.Second {
-		app.errorJSON(w, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
+	// look up the token in the refresh token store; we only accept tokens we have recorded
+	storedToken, err := app.DB.GetRefreshToken(claims.ID)
+	if err != nil {
+		app.problemJSON(w, errors.New("unknown refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	if !storedToken.RevokedAt.IsZero() {
+		app.problemJSON(w, errors.New("refresh token revoked"), http.StatusUnauthorized)
+		return
+	}
+
+	// a token that was already exchanged is being replayed, so the family may be stolen; revoke all of it
+	if !storedToken.UsedAt.IsZero() {
+		app.revokeRefreshTokenFamily(storedToken)
+		app.problemJSON(w, errors.New("refresh token reuse detected"), http.StatusUnauthorized)
+		return
+	}
+
+	if !app.RefreshPolicy.Allows(storedToken.ExpiresAt, time.Now()) {
+		app.problemJSON(w, errors.New("refresh token does not need renewed yet"), http.StatusTooEarly)
 		return
 	}
 
 	// get the user id from the claims
 	userId, err := strconv.Atoi(claims.Subject)
-	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+	if err != nil || userId != storedToken.UserID {
+		app.problemJSON(w, errors.New("invalid refresh token subject"), http.StatusBadRequest)
 		return
 	}
 
 	user, err := app.DB.GetUser(userId)
 	if err != nil {
-		app.errorJSON(w, errors.New("unknown user"), http.StatusBadRequest)
+		app.problemJSON(w, errors.New("unknown user"), http.StatusBadRequest)
 		return
 	}
 
 	// generate new access token
 	tokenPairs, err := app.generateTokenPairs(user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// rotate the refresh token: the presented one is used up, the new one joins its family
+	err = app.DB.RotateRefreshToken(storedToken.ID, refreshTokenRecord(tokenPairs, user.ID, storedToken.FamilyID, r.UserAgent()))
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenUsed) {
+			// lost a race against another use of the same token
+			app.revokeRefreshTokenFamily(storedToken)
+			app.problemJSON(w, errors.New("refresh token reuse detected"), http.StatusUnauthorized)
+			return
+		}
+		app.problemJSON(w, err)
 		return
 	}
 
@@ -103,8 +172,8 @@ func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 		Name:     "__Host-refresh_token",
 		Path:     "/",
 		Value:    tokenPairs.RefreshToken,
-		Expires:  time.Now().Add(refreshTokenExpiry),
-		MaxAge:   int(refreshTokenExpiry.Seconds()),
+		Expires:  time.Now().Add(app.Lifetimes.Refresh),
+		MaxAge:   int(app.Lifetimes.Refresh.Seconds()),
 		SameSite: http.SameSiteStrictMode,
 		Domain:   "localhost",
 		HttpOnly: true,
@@ -115,81 +184,323 @@ func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// logout revokes the access token of the request and, if one is given, the refresh token family
+// of the session, so that neither can be used anymore.
+func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	claims := app.claimsFromContext(r.Context())
+
+	err := app.Denylist.DenyToken(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the refresh token comes either as a form value or as the cookie set by refresh
+	_ = r.ParseForm()
+	refreshToken := r.Form.Get("refresh_token")
+	if refreshToken == "" {
+		if cookie, err := r.Cookie("__Host-refresh_token"); err == nil {
+			refreshToken = cookie.Value
+		}
+	}
+
+	if refreshToken != "" {
+		refreshClaims := &Claims{}
+		_, err = jwt.ParseWithClaims(refreshToken, refreshClaims, app.Keys.Keyfunc)
+		if err == nil && refreshClaims.Subject == claims.Subject {
+			storedToken, err := app.DB.GetRefreshToken(refreshClaims.ID)
+			if err == nil {
+				_ = app.DB.RevokeRefreshTokenFamily(storedToken.FamilyID)
+			}
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "__Host-refresh_token",
+		Path:     "/",
+		Value:    "",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		SameSite: http.SameSiteStrictMode,
+		Domain:   "localhost",
+		HttpOnly: true,
+		Secure:   true,
+	})
+
+	app.audit(r, data.AuditEntry{Action: "logout", Target: "user:" + claims.Subject})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// revokeUserTokens ends every session of a user at once: all access tokens issued until now are
+// denied, and all refresh tokens are revoked.
+func (app *application) revokeUserTokens(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.Denylist.DenyUser(userId, time.Now())
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.RevokeUserRefreshTokens(userId)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{Action: "user.revoke_tokens", Target: fmt.Sprintf("user:%d", userId)})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) revokeRefreshTokenFamily(t *data.RefreshToken) {
+	log.Printf("refresh token %s of user %d was reused; revoking family %s", t.ID, t.UserID, t.FamilyID)
+	err := app.DB.RevokeRefreshTokenFamily(t.FamilyID)
+	if err != nil {
+		log.Printf("error revoking refresh token family %s: %v", t.FamilyID, err)
+	}
+}
+
+// jwks publishes the public keys of the key ring, so that other services can verify our tokens
+// without knowing any secret.
+func (app *application) jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_ = app.writeJSON(w, http.StatusOK, app.Keys.JWKS())
+}
+
+// allUsers lists users a page at a time; see parseUserQuery for the filters and paging parameters.
 func (app *application) allUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := app.DB.AllUsers()
+	q, err := parseUserQuery(r)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	page, err := app.DB.ListUsers(q)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.problemJSON(w, err)
 		return
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, users)
+	list := UserList{
+		Users: page.Users,
+		Page:  PageMeta{Total: page.Total, Limit: q.Limit, Offset: q.Offset},
+	}
+	if page.Next != nil {
+		list.Page.NextCursor = page.Next.Encode()
+	}
+
+	w.Header().Set("Link", app.pageLinks(r, q, page))
+	_ = app.writeJSON(w, http.StatusOK, list)
 }
 
 func (app *application) getUser(w http.ResponseWriter, r *http.Request) {
 	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.problemJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
 	user, err := app.DB.GetUser(userId)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.problemJSON(w, err)
+		return
+	}
+
+	app.writeUser(w, r, user)
+}
+
+// writeUser sends user along with its ETag, or answers 304 if the client has it already.
+func (app *application) writeUser(w http.ResponseWriter, r *http.Request, user *data.User) {
+	etag := userETag(user)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
 	_ = app.writeJSON(w, http.StatusOK, user)
 }
 
+// updateUser updates a user, if it is still the version named by the If-Match header.
 func (app *application) updateUser(w http.ResponseWriter, r *http.Request) {
-	var user data.User
-	err := app.readJSON(w, r, &user)
+	version, err := ifMatchVersion(r)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.preconditionFailed(w, err)
 		return
 	}
 
+	var payload updateUserRequest
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = payload.validate()
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+	user := payload.user()
+	user.Version = version
+
+	// non admins may only update their own record, and may not change their roles; leaving the
+	// roles out keeps them
+	claims := app.claimsFromContext(r.Context())
+	if !claims.HasRole(data.RoleAdmin) && fmt.Sprint(user.ID) != claims.Subject {
+		app.problemJSON(w, errors.New("forbidden"), http.StatusForbidden)
+		return
+	}
+
+	// only admins set the passwords of others; one's own needs the current one at /me/password,
+	// which API keys can't use either
+	if payload.Password != "" && (!claims.HasRole(data.RoleAdmin) || claims.APIKeyID != 0 || fmt.Sprint(user.ID) == claims.Subject) {
+		app.problemJSON(w, validation.Errors{"password": {"can only be set by an admin for another user; change your own with POST /me/password"}})
+		return
+	}
+
+	// the stored user is also what the audit log compares the update with
+	existing, err := app.DB.GetUser(user.ID)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+	if !claims.HasRole(data.RoleAdmin) || payload.Roles == nil {
+		user.Roles = existing.Roles
+	}
+
 	err = app.DB.UpdateUser(user)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.problemJSON(w, err)
 		return
 	}
 
+	if payload.Password != "" {
+		err = app.DB.ResetPassword(user.ID, payload.Password)
+		if err != nil {
+			app.problemJSON(w, err)
+			return
+		}
+
+		// as after a reset, whoever knew the old password must not stay logged in
+		err = app.Denylist.DenyUser(user.ID, time.Now())
+		if err != nil {
+			app.problemJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = app.DB.RevokeUserRefreshTokens(user.ID)
+		if err != nil {
+			app.problemJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	user.Password = payload.Password
+	app.audit(r, data.AuditEntry{Action: "user.update", Target: fmt.Sprintf("user:%d", user.ID), Changes: data.UserChanges(existing, &user)})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (app *application) insertUser(w http.ResponseWriter, r *http.Request) {
-	var user data.User
-	err := app.readJSON(w, r, &user)
+	var payload createUserRequest
+	err := app.readJSON(w, r, &payload)
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.problemJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
-	_, err = app.DB.InsertUser(user)
+	err = payload.validate()
 	if err != nil {
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.problemJSON(w, err)
 		return
 	}
 
+	user := payload.user()
+	id, err := app.DB.InsertUser(user)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{Action: "user.create", Target: fmt.Sprintf("user:%d", id), Changes: data.UserChanges(nil, &user)})
+
 	w.WriteHeader(http.StatusNoContent)
 
 }
 
+// deleteUser deletes a user, if it is still the version named by the If-Match header, and ends
+// every login of the user: its tokens, and its web sessions through the shared denylist.
 func (app *application) deleteUser(w http.ResponseWriter, r *http.Request) {
 	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
 	if err != nil {
-		log.Printf("error converting user id to int: %v", err)
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		app.preconditionFailed(w, err)
+		return
+	}
+
+	// the audit log keeps what was deleted
+	existing, err := app.DB.GetUser(userId)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+
+	if version == 0 {
+		err = app.DB.DeleteUser(userId)
+	} else {
+		err = app.DB.DeleteUserVersion(userId, version)
+	}
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+
+	err = app.Denylist.DenyUser(userId, time.Now())
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.RevokeUserRefreshTokens(userId)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// deleted users can be restored until they are purged
+	app.audit(r, data.AuditEntry{Action: "user.delete", Target: fmt.Sprintf("user:%d", userId), Changes: data.UserChanges(existing, nil)})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreUser undoes the deletion of a user which wasn't purged yet. It fails with a conflict if
+// the email address of the user was taken in the meantime.
+func (app *application) restoreUser(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
-	err = app.DB.DeleteUser(userId)
+	err = app.DB.RestoreUser(userId)
 	if err != nil {
-		log.Printf("error deleting user: %v", err)
-		app.errorJSON(w, err, http.StatusBadRequest)
+		app.problemJSON(w, err)
 		return
 	}
 
+	app.audit(r, data.AuditEntry{Action: "user.restore", Target: fmt.Sprintf("user:%d", userId)})
+
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -11,8 +13,12 @@ import (
 	"testing"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/keyring"
+	"webapp/pkg/lockout"
+	"webapp/pkg/repository/dbrepo"
 
-	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
 )
 
 func Test_app_authenticate(t *testing.T) {
@@ -45,6 +51,24 @@ func Test_app_authenticate(t *testing.T) {
 	}
 }
 
+func Test_app_authenticateUnverified(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	db := &dbrepo.TestDBRepo{}
+	admin, _ := db.GetUser(1)
+	db.AddUsers(data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: admin.Password})
+	app.DB = db
+
+	req, _ := http.NewRequest("POST", "/auth", strings.NewReader(`{"email":"jane@example.com","password":"secret"}`))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected code %v for an unconfirmed email address, but got %v", http.StatusForbidden, rr.Code)
+	}
+}
+
 func Test_app_refresh(t *testing.T) {
 	tests := []struct {
 		name               string
@@ -65,15 +89,15 @@ func Test_app_refresh(t *testing.T) {
 	}
 
 	// save the old refresh token expiry
-	oldRefreshTime := refreshTokenExpiry
+	oldRefreshTime := app.Lifetimes.Refresh
 
 	for _, e := range tests {
 		var tkn string
 		if e.token == "" {
 			if e.resetRefreshTime {
-				refreshTokenExpiry = time.Second * 1
+				app.Lifetimes.Refresh = time.Second * 1
 			}
-			tokens, _ := app.generateTokenPairs(&testUser)
+			tokens, _ := app.issueTokenPairs(&testUser, "test")
 			tkn = tokens.RefreshToken
 		} else {
 			tkn = e.token
@@ -95,7 +119,7 @@ func Test_app_refresh(t *testing.T) {
 		}
 
 		// recover the old refresh token expiry
-		refreshTokenExpiry = oldRefreshTime
+		app.Lifetimes.Refresh = oldRefreshTime
 
 	}
 }
@@ -111,6 +135,15 @@ func Test_app_UserHandlers(t *testing.T) {
 	}{
 		{"allUsers", "GET", "", "", app.allUsers, http.StatusOK},
 		{"deleteUser", "DELETE", "", "1", app.deleteUser, http.StatusNoContent},
+		{"deleteUser unknown", "DELETE", "", "9", app.deleteUser, http.StatusNotFound},
+		{"getUser", "GET", "", "1", app.getUser, http.StatusOK},
+		{"getUser unknown", "GET", "", "9", app.getUser, http.StatusNotFound},
+		{"insertUser", "PUT", `{"first_name":"Jane","last_name":"Doe","email":"jane@example.com","password":"correct horse 1"}`, "", app.insertUser, http.StatusNoContent},
+		{"insertUser duplicate email", "PUT", `{"first_name":"Jane","last_name":"Doe","email":"admin@example.com","password":"correct horse 1"}`, "", app.insertUser, http.StatusConflict},
+		{"insertUser without password", "PUT", `{"first_name":"Jane","last_name":"Doe","email":"jim@example.com"}`, "", app.insertUser, http.StatusUnprocessableEntity},
+		{"insertUser weak password", "PUT", `{"first_name":"Jane","last_name":"Doe","email":"jim@example.com","password":"password"}`, "", app.insertUser, http.StatusUnprocessableEntity},
+		{"insertUser invalid email", "PUT", `{"first_name":"Jane","last_name":"Doe","email":"jim","password":"correct horse 1"}`, "", app.insertUser, http.StatusUnprocessableEntity},
+		{"insertUser unknown role", "PUT", `{"first_name":"Jane","last_name":"Doe","email":"jim@example.com","password":"correct horse 1","roles":["root"]}`, "", app.insertUser, http.StatusUnprocessableEntity},
 		// {"allUsers", "GET", "", "", app.allUsers, http.StatusOK},
 		// {"allUsers", "GET", "", "", app.allUsers, http.StatusOK},
 
@@ -124,6 +157,9 @@ func Test_app_UserHandlers(t *testing.T) {
 			req, _ = http.NewRequest(e.method, "/users", strings.NewReader(e.json))
 		}
 
+		// deleting needs a version; see Test_app_userETags for the conditional requests
+		req.Header.Set("If-Match", "*")
+
 		if e.paramId != "" {
 			log.Printf("paramId: %s", e.paramId)
 			chiCtx := chi.NewRouteContext()
@@ -141,3 +177,423 @@ func Test_app_UserHandlers(t *testing.T) {
 
 	}
 }
+
+func Test_app_jwks(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(app.jwks)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %v, but got %v", http.StatusOK, rr.Code)
+	}
+
+	var set keyring.JWKS
+	err := json.NewDecoder(rr.Body).Decode(&set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(set.Keys) != 1 {
+		t.Fatalf("expected 1 key, but got %d", len(set.Keys))
+	}
+
+	if set.Keys[0].Kid != app.Keys.Current().ID {
+		t.Errorf("expected kid %s, but got %s", app.Keys.Current().ID, set.Keys[0].Kid)
+	}
+
+	if set.Keys[0].N == "" || set.Keys[0].E == "" {
+		t.Error("expected RSA modulus and exponent in jwk")
+	}
+}
+
+func Test_app_refreshRotation(t *testing.T) {
+	testUser := data.User{
+		ID:        1,
+		FirstName: "Admin",
+		LastName:  "User",
+		Email:     "admin@example.com",
+	}
+
+	// allow refreshing at any time
+	oldPolicy := app.RefreshPolicy
+	app.RefreshPolicy = RefreshPolicy{}
+	defer func() { app.RefreshPolicy = oldPolicy }()
+
+	tokens, _ := app.issueTokenPairs(&testUser, "test")
+
+	refresh := func(token string) (int, TokenPairs) {
+		postedData := url.Values{
+			"refresh_token": {token},
+		}
+		req, _ := http.NewRequest("POST", "/refresh-token", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.refresh)
+		handler.ServeHTTP(rr, req)
+
+		var pairs TokenPairs
+		_ = json.NewDecoder(rr.Body).Decode(&pairs)
+		return rr.Code, pairs
+	}
+
+	// first use rotates the token
+	code, rotated := refresh(tokens.RefreshToken)
+	if code != http.StatusOK {
+		t.Fatalf("first refresh: expected code %v, but got %v", http.StatusOK, code)
+	}
+	if rotated.RefreshToken == tokens.RefreshToken {
+		t.Error("first refresh: expected a new refresh token")
+	}
+
+	// replaying the used token is rejected
+	code, _ = refresh(tokens.RefreshToken)
+	if code != http.StatusUnauthorized {
+		t.Errorf("replayed refresh: expected code %v, but got %v", http.StatusUnauthorized, code)
+	}
+
+	// and revokes the whole family, including the rotated token
+	code, _ = refresh(rotated.RefreshToken)
+	if code != http.StatusUnauthorized {
+		t.Errorf("refresh after reuse: expected code %v, but got %v", http.StatusUnauthorized, code)
+	}
+
+	// a validly signed token we never recorded is rejected
+	unknown, _ := app.generateTokenPairs(&testUser)
+	code, _ = refresh(unknown.RefreshToken)
+	if code != http.StatusUnauthorized {
+		t.Errorf("unrecorded token: expected code %v, but got %v", http.StatusUnauthorized, code)
+	}
+}
+
+func Test_app_logout(t *testing.T) {
+	testUser := data.User{
+		ID:        1,
+		FirstName: "Admin",
+		LastName:  "User",
+		Email:     "admin@example.com",
+	}
+
+	tokens, _ := app.issueTokenPairs(&testUser, "test")
+
+	postedData := url.Values{
+		"refresh_token": {tokens.RefreshToken},
+	}
+	req, _ := http.NewRequest("POST", "/logout", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	rr := httptest.NewRecorder()
+
+	handler := app.authRequired(http.HandlerFunc(app.logout))
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected code %v, but got %v", http.StatusNoContent, rr.Code)
+	}
+
+	// the access token is no longer accepted
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	_, _, err := app.getTokenFromHeaderAndVerify(httptest.NewRecorder(), req)
+	if err == nil {
+		t.Error("expected access token to be revoked after logout")
+	}
+
+	// and neither is the refresh token
+	claims := &Claims{}
+	_, _ = jwt.ParseWithClaims(tokens.RefreshToken, claims, app.Keys.Keyfunc)
+	stored, _ := app.DB.GetRefreshToken(claims.ID)
+	if stored.RevokedAt.IsZero() {
+		t.Error("expected refresh token to be revoked after logout")
+	}
+}
+
+func Test_app_revokeUserTokens(t *testing.T) {
+	testUser := data.User{
+		ID:        1,
+		FirstName: "Admin",
+		LastName:  "User",
+		Email:     "admin@example.com",
+	}
+
+	// issued within the same second as the revocation, most likely
+	tokens, _ := app.issueTokenPairs(&testUser, "test")
+
+	req, _ := http.NewRequest("POST", "/users/1/revoke-tokens", nil)
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", "1")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(app.revokeUserTokens)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected code %v, but got %v", http.StatusNoContent, rr.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	_, _, err := app.getTokenFromHeaderAndVerify(httptest.NewRecorder(), req)
+	if err == nil {
+		t.Error("expected access token issued before the revocation to be rejected")
+	}
+
+	// logging in again works at once
+	tokens, _ = app.issueTokenPairs(&testUser, "test")
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	_, _, err = app.getTokenFromHeaderAndVerify(httptest.NewRecorder(), req)
+	if err != nil {
+		t.Errorf("expected access token issued after the revocation to be accepted, but got %v", err)
+	}
+
+	// reset the denylist for the other tests
+	app.Denylist = &dbrepo.MemoryDenylist{}
+}
+
+func Test_app_updateUser(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	db := &dbrepo.TestDBRepo{}
+	db.AddUsers(data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Roles: []string{data.RoleUser}})
+	app.DB = db
+
+	tests := []struct {
+		name               string
+		json               string
+		roles              []string
+		apiKey             bool
+		ifMatch            string
+		expectedStatusCode int
+	}{
+		{"admin updates other user", `{"id":2,"first_name":"Jane","last_name":"Doe","email":"jane@example.com"}`, []string{data.RoleAdmin}, false, "*", http.StatusNoContent},
+		{"user updates self", `{"id":1,"first_name":"Admin","last_name":"User","email":"admin@example.com"}`, []string{data.RoleUser}, false, "*", http.StatusNoContent},
+		{"user updates other user", `{"id":2,"first_name":"Jane","last_name":"Doe","email":"jane@example.com"}`, []string{data.RoleUser}, false, "*", http.StatusForbidden},
+		{"unknown user", `{"id":3,"first_name":"Jim","last_name":"Doe","email":"jim@example.com"}`, []string{data.RoleAdmin}, false, "*", http.StatusNotFound},
+		{"duplicate email", `{"id":2,"first_name":"Jane","last_name":"Doe","email":"admin@example.com"}`, []string{data.RoleAdmin}, false, "*", http.StatusConflict},
+		{"new password", `{"id":2,"first_name":"Jane","last_name":"Doe","email":"jane@example.com","password":"correct horse 1"}`, []string{data.RoleAdmin}, false, "*", http.StatusNoContent},
+		{"admin sets own password", `{"id":1,"first_name":"Admin","last_name":"User","email":"admin@example.com","password":"correct horse 1"}`, []string{data.RoleAdmin}, false, "*", http.StatusUnprocessableEntity},
+		{"user sets own password", `{"id":1,"first_name":"Admin","last_name":"User","email":"admin@example.com","password":"correct horse 1"}`, []string{data.RoleUser}, false, "*", http.StatusUnprocessableEntity},
+		{"api key sets password", `{"id":2,"first_name":"Jane","last_name":"Doe","email":"jane@example.com","password":"correct horse 1"}`, []string{data.RoleAdmin}, true, "*", http.StatusUnprocessableEntity},
+		{"weak password", `{"id":2,"first_name":"Jane","last_name":"Doe","email":"jane@example.com","password":"jane1234"}`, []string{data.RoleAdmin}, false, "*", http.StatusUnprocessableEntity},
+		{"blank name", `{"id":2,"first_name":" ","last_name":"Doe","email":"jane@example.com"}`, []string{data.RoleAdmin}, false, "*", http.StatusUnprocessableEntity},
+		{"missing id", `{"first_name":"Jane","last_name":"Doe","email":"jane@example.com"}`, []string{data.RoleAdmin}, false, "*", http.StatusUnprocessableEntity},
+		// the two updates and the new password above made it version 4
+		{"current version", `{"id":2,"first_name":"Janet","last_name":"Doe","email":"jane@example.com"}`, []string{data.RoleAdmin}, false, `"4"`, http.StatusNoContent},
+		{"stale version", `{"id":2,"first_name":"Jane","last_name":"Doe","email":"jane@example.com"}`, []string{data.RoleAdmin}, false, `"4"`, http.StatusPreconditionFailed},
+		{"weak etag", `{"id":2,"first_name":"Jane","last_name":"Doe","email":"jane@example.com"}`, []string{data.RoleAdmin}, false, `W/"5"`, http.StatusPreconditionFailed},
+		{"without If-Match", `{"id":2,"first_name":"Jane","last_name":"Doe","email":"jane@example.com"}`, []string{data.RoleAdmin}, false, "", http.StatusPreconditionRequired},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PATCH", "/users/", strings.NewReader(e.json))
+		if e.ifMatch != "" {
+			req.Header.Set("If-Match", e.ifMatch)
+		}
+		claims := &Claims{Roles: e.roles}
+		claims.Subject = "1"
+		if e.apiKey {
+			claims.APIKeyID = 1
+			claims.Scopes = []string{data.ScopeUsersWrite}
+		}
+		req = req.WithContext(context.WithValue(req.Context(), contextClaimsKey, claims))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.updateUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	// none of the updates had roles, which keeps them
+	jane, _ := db.GetUser(2)
+	if !jane.HasRole(data.RoleUser) {
+		t.Errorf("expected the user to keep the user role, but got %v", jane.Roles)
+	}
+
+	// setting the password logged the user out everywhere
+	denied, err := app.Denylist.IsDenied("jti", 2, time.Now().Add(-time.Minute))
+	if err != nil || !denied {
+		t.Errorf("expected tokens issued before the new password to be denied, but got %v, %v", denied, err)
+	}
+	denied, _ = app.Denylist.IsDenied("jti", 1, time.Now().Add(-time.Minute))
+	if denied {
+		t.Error("expected the tokens of users whose password was refused to stay valid")
+	}
+
+	// reset the denylist for the other tests
+	app.Denylist = &dbrepo.MemoryDenylist{}
+}
+
+func Test_app_deleteUserEndsLogins(t *testing.T) {
+	oldDB, oldDenylist := app.DB, app.Denylist
+	defer func() { app.DB, app.Denylist = oldDB, oldDenylist }()
+
+	db := &dbrepo.TestDBRepo{}
+	jane := data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Roles: []string{data.RoleAdmin}}
+	db.AddUsers(jane)
+	app.DB = db
+	app.Denylist = &dbrepo.MemoryDenylist{}
+
+	tokens, _ := app.issueTokenPairs(&jane, "test")
+
+	req, _ := http.NewRequest("DELETE", "/users/2", nil)
+	req.Header.Set("If-Match", "*")
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", "2")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.deleteUser).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected code %v, but got %v", http.StatusNoContent, rr.Code)
+	}
+
+	// the token issued before the delete carries the admin role, but is refused
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	_, _, err := app.getTokenFromHeaderAndVerify(httptest.NewRecorder(), req)
+	if err == nil {
+		t.Error("expected the access token of the deleted user to be rejected")
+	}
+
+	stored, _ := db.GetRefreshToken(tokens.RefreshTokenID)
+	if stored.RevokedAt.IsZero() {
+		t.Error("expected the refresh token of the deleted user to be revoked")
+	}
+}
+
+func Test_app_restoreUser(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	deletedAt := time.Now().Add(-time.Hour)
+	db := &dbrepo.TestDBRepo{}
+	db.AddUsers(
+		data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", DeletedAt: deletedAt},
+		data.User{ID: 3, FirstName: "Jim", LastName: "Doe", Email: "jim@example.com", DeletedAt: deletedAt},
+		data.User{ID: 4, FirstName: "Jim", LastName: "Beam", Email: "jim@example.com"},
+	)
+	app.DB = db
+
+	tests := []struct {
+		name               string
+		paramId            string
+		expectedStatusCode int
+	}{
+		{"deleted user", "2", http.StatusNoContent},
+		{"restored already", "2", http.StatusNotFound},
+		{"email taken", "3", http.StatusConflict},
+		{"not deleted", "1", http.StatusNotFound},
+		{"unknown user", "9", http.StatusNotFound},
+		{"invalid id", "x", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/users/"+e.paramId+"/restore", nil)
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.paramId)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.restoreUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	_, err := db.GetUser(2)
+	if err != nil {
+		t.Errorf("expected the restored user to be found, but got %v", err)
+	}
+	_, err = db.GetUser(3)
+	if err == nil {
+		t.Error("expected the user with the taken email address to stay deleted")
+	}
+}
+
+func Test_app_authenticateLockout(t *testing.T) {
+	oldLockout := app.Lockout
+	defer func() { app.Lockout = oldLockout }()
+
+	app.Lockout = &lockout.Guard{
+		Repo: app.DB,
+		Policy: lockout.Policy{
+			FreeAttempts:    0,
+			BaseDelay:       time.Minute,
+			MaxDelay:        time.Minute,
+			LockoutAfter:    2,
+			LockoutDuration: time.Hour,
+			ResetAfter:      time.Hour,
+		},
+	}
+
+	var tests = []struct {
+		name               string
+		requestBody        string
+		expectedStatusCode int
+	}{
+		{"wrong password", `{"email":"admin@example.com","password":"wrong"}`, http.StatusUnauthorized},
+		{"backoff", `{"email":"admin@example.com","password":"secret"}`, http.StatusTooManyRequests},
+		{"other client", `{"email":"admin@example.com","password":"secret"}`, http.StatusTooManyRequests},
+	}
+
+	for i, e := range tests {
+		req, _ := http.NewRequest("POST", "/auth", strings.NewReader(e.requestBody))
+		req.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.authenticate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected a Retry-After header", e.name)
+		}
+	}
+
+	// an admin lifts the lock early
+	req, _ := http.NewRequest("POST", "/users/1/unlock", nil)
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", "1")
+	claims := &Claims{Roles: []string{data.RoleAdmin}}
+	claims.Subject = "1"
+	ctx := context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx)
+	req = req.WithContext(context.WithValue(ctx, contextClaimsKey, claims))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(app.unlockUser)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("unlock: expected code %v, but got %v", http.StatusNoContent, rr.Code)
+	}
+
+	req, _ = http.NewRequest("POST", "/auth", strings.NewReader(`{"email":"admin@example.com","password":"secret"}`))
+	req.RemoteAddr = "192.0.2.100:1234"
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("after unlock: expected code %v, but got %v", http.StatusOK, rr.Code)
+	}
+
+	// the unlock is followed by the successful login
+	entries := app.DB.(*dbrepo.TestDBRepo).AuditLog()
+	if len(entries) < 2 || entries[len(entries)-2].Action != lockout.ActionUnlock {
+		t.Fatalf("expected the unlock to be audited, got %v", entries)
+	}
+	if last := entries[len(entries)-1]; last.Action != "login" || last.Actor != "1" || last.IP != "192.0.2.100" {
+		t.Errorf("expected the login to be audited, got %v", last)
+	}
+}
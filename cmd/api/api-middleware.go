@@ -1,29 +1,171 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+	"strings"
+	"webapp/pkg/data"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type contextKey string
+
+const contextClaimsKey contextKey = "claims"
 
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:8090")
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); app.originAllowed(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
 		if r.Method == "OPTIONS" {
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 			w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, X-CSRF-Token, Authorization, If-Match, If-None-Match")
 			return
 		} else {
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
 			next.ServeHTTP(w, r)
 		}
 	})
 }
 
+// originAllowed reports whether origin is one of the configured CORS origins.
+func (app *application) originAllowed(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, allowed := range app.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// authRequired lets requests through which carry a valid access token (Authorization: Bearer ...)
+// or API key (Authorization: ApiKey ...). Routes behind it must limit API keys with RequireScope.
 func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, _, err := app.getTokenFromHeaderAndVerify(w, r)
+		var claims *Claims
+		var err error
+
+		if strings.HasPrefix(r.Header.Get("Authorization"), "ApiKey ") {
+			claims, err = app.getAPIKeyFromHeaderAndVerify(w, r)
+		} else {
+			_, claims, err = app.getTokenFromHeaderAndVerify(w, r)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// keep the verified claims for the middleware and handlers down the chain
+		ctx := context.WithValue(r.Context(), contextClaimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// bearerRequired is authRequired for routes which belong to a user's session, like logout or
+// managing API keys; API keys are not accepted there.
+func (app *application) bearerRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, claims, err := app.getTokenFromHeaderAndVerify(w, r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// keep the verified claims for the middleware and handlers down the chain
+		ctx := context.WithValue(r.Context(), contextClaimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// oauthRequired lets requests through which carry a valid access token of an OAuth client. Routes
+// behind it must check the scopes granted to the client with RequireScope.
+func (app *application) oauthRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, claims, err := app.verifyTokenFromHeader(w, r, oauthAudience)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
-		next.ServeHTTP(w, r)
-		return
+
+		// keep the verified claims for the middleware and handlers down the chain
+		ctx := context.WithValue(r.Context(), contextClaimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// claimsFromContext returns the claims verified by authRequired, or nil if there are none
+func (app *application) claimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(contextClaimsKey).(*Claims)
+	return claims
+}
+
+// RequireRole only lets requests through whose claims carry at least one of the given roles.
+// It must be used after authRequired.
+func (app *application) RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := app.claimsFromContext(r.Context())
+			if claims == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			for _, role := range roles {
+				if claims.HasRole(role) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+}
+
+// RequireScope only lets API keys and OAuth clients through which were granted scope; the tokens
+// of the api always pass. It must be used after authRequired or oauthRequired.
+func (app *application) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := app.claimsFromContext(r.Context())
+			if claims == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if !claims.HasScope(scope) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSelfOrAdmin only lets requests through if the user id in the URL parameter param is the
+// subject of the claims, or if the claims carry the admin role. It must be used after authRequired.
+func (app *application) RequireSelfOrAdmin(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := app.claimsFromContext(r.Context())
+			if claims == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			if claims.HasRole(data.RoleAdmin) || chi.URLParam(r, param) == claims.Subject {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,6 +11,8 @@ import (
 	"testing"
 	"time"
 	"webapp/pkg/data"
+
+	"github.com/go-chi/chi/v5"
 )
 
 func TestApp_enableCORS(t *testing.T) {
@@ -18,20 +21,31 @@ func TestApp_enableCORS(t *testing.T) {
 	tests := []struct {
 		name           string
 		method         string
+		origin         string
 		expectedHeader bool
+		expectedOrigin string
 	}{
-		{"preflight", "OPTIONS", true},
-		{"get", "GET", false},
+		{"preflight", "OPTIONS", "", true, ""},
+		{"get", "GET", "", false, ""},
+		{"allowed origin", "OPTIONS", "http://localhost:8090", true, "http://localhost:8090"},
+		{"unknown origin", "GET", "http://evil.example.com", false, ""},
 	}
 
 	for _, e := range tests {
 		handlerToTest := app.enableCORS(nextHandler)
 
 		req := httptest.NewRequest(e.method, "http://test.com", nil)
+		if e.origin != "" {
+			req.Header.Set("Origin", e.origin)
+		}
 		rr := httptest.NewRecorder()
 
 		handlerToTest.ServeHTTP(rr, req)
 
+		if rr.Header().Get("Access-Control-Allow-Origin") != e.expectedOrigin {
+			t.Errorf("%s: expected allowed origin %q, but got %q", e.name, e.expectedOrigin, rr.Header().Get("Access-Control-Allow-Origin"))
+		}
+
 		if e.expectedHeader && rr.Header().Get("Access-Control-Allow-Credentials") == "" {
 			t.Errorf("%s: expected header, but could not find it", e.name)
 		}
@@ -104,16 +118,16 @@ func TestApp_refresh(t *testing.T) {
 		Email:     "admin@example.com",
 	}
 
-	oldRefreshTokenExpiry := refreshTokenExpiry
+	oldRefreshTokenExpiry := app.Lifetimes.Refresh
 
 	for _, e := range tests {
 		var tkn string
 		if e.token == "" {
 			log.Println("here")
 			if e.resetRefreshTokenTime {
-				refreshTokenExpiry = time.Second * 1
+				app.Lifetimes.Refresh = time.Second * 1
 			}
-			tokens, _ := app.generateTokenPairs(&testUser)
+			tokens, _ := app.issueTokenPairs(&testUser, "test")
 			tkn = tokens.RefreshToken
 		} else {
 			tkn = e.token
@@ -134,8 +148,76 @@ func TestApp_refresh(t *testing.T) {
 			t.Errorf("%s: expected status code of %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
 		}
 
-		refreshTokenExpiry = oldRefreshTokenExpiry
+		app.Lifetimes.Refresh = oldRefreshTokenExpiry
+
+	}
+
+}
+
+func TestApp_RequireRole(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name               string
+		claims             *Claims
+		expectedStatusCode int
+	}{
+		{"admin", &Claims{Roles: []string{data.RoleAdmin}}, http.StatusOK},
+		{"user", &Claims{Roles: []string{data.RoleUser}}, http.StatusForbidden},
+		{"no roles", &Claims{}, http.StatusForbidden},
+		{"no claims", nil, http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users", nil)
+		if e.claims != nil {
+			req = req.WithContext(context.WithValue(req.Context(), contextClaimsKey, e.claims))
+		}
+		rr := httptest.NewRecorder()
+
+		handlerToTest := app.RequireRole(data.RoleAdmin)(nextHandler)
+		handlerToTest.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestApp_RequireSelfOrAdmin(t *testing.T) {
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
 
+	tests := []struct {
+		name               string
+		subject            string
+		roles              []string
+		paramID            string
+		expectedStatusCode int
+	}{
+		{"self", "1", []string{data.RoleUser}, "1", http.StatusOK},
+		{"other user", "1", []string{data.RoleUser}, "2", http.StatusForbidden},
+		{"admin", "1", []string{data.RoleAdmin}, "2", http.StatusOK},
 	}
 
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/"+e.paramID, nil)
+
+		claims := &Claims{Roles: e.roles}
+		claims.Subject = e.subject
+
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", e.paramID)
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx)
+		ctx = context.WithValue(ctx, contextClaimsKey, claims)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handlerToTest := app.RequireSelfOrAdmin("userID")(nextHandler)
+		handlerToTest.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
 }
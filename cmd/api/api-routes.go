@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"webapp/pkg/data"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -16,7 +17,25 @@ func (app *application) routes() http.Handler {
 
 	// authentication routes - auth handler, refresh handler
 	mux.Post("/auth", app.authenticate)
+	mux.Post("/auth/mfa", app.authenticateMFA)
 	mux.Post("/refresh-token", app.refresh)
+	mux.Post("/password/forgot", app.forgotPassword)
+	mux.Post("/password/reset", app.resetPassword)
+
+	// public keys for verifying our tokens
+	mux.Get("/.well-known/jwks.json", app.jwks)
+
+	// OpenID Connect provider
+	mux.Get("/.well-known/openid-configuration", app.openIDConfiguration)
+	mux.Get("/authorize", app.authorize)
+	mux.Post("/authorize", app.authorizeLogin)
+	mux.Post("/token", app.token)
+	mux.With(app.oauthRequired, app.RequireScope("openid")).Get("/userinfo", app.userInfo)
+	mux.With(app.bearerRequired, app.RequireRole(data.RoleAdmin)).Post("/oauth/clients", app.registerOAuthClient)
+
+	// API documentation; keep docs/openapi.yaml in line with the routes
+	mux.Get("/docs", app.docs)
+	mux.Get("/docs/openapi.yaml", app.openAPISpec)
 
 	// test handler
 	mux.Get("/test", func(w http.ResponseWriter, r *http.Request) {
@@ -30,14 +49,45 @@ func (app *application) routes() http.Handler {
 	})
 
 	// protected routes
+	mux.With(app.bearerRequired).Post("/logout", app.logout)
+
+	// the caller's own user; API keys need the users scopes, and can't change the password
+	mux.With(app.authRequired, app.RequireScope(data.ScopeUsersRead)).Get("/me", app.getMe)
+	mux.With(app.authRequired, app.RequireScope(data.ScopeUsersWrite)).Patch("/me", app.updateMe)
+	mux.With(app.bearerRequired).Post("/me/password", app.changePassword)
+
+	mux.Route("/api-keys", func(mux chi.Router) {
+		mux.Use(app.bearerRequired)
+
+		mux.Get("/", app.listAPIKeys)
+		mux.Post("/", app.createAPIKey)
+		mux.Delete("/{keyID}", app.revokeAPIKey)
+	})
+
+	mux.With(app.authRequired, app.RequireScope(data.ScopeAuditRead), app.RequireRole(data.RoleAdmin)).Get("/audit-log", app.auditLog)
+
 	mux.Route("/users", func(mux chi.Router) {
 		mux.Use(app.authRequired)
 
-		mux.Get("/", app.allUsers)
-		mux.Get("/{userID}", app.getUser)
-		mux.Delete("/{userID}", app.deleteUser)
-		mux.Put("/", app.insertUser)
-		mux.Patch("/", app.updateUser)
+		// API keys need users:read to read, and users:write for everything else
+		read := app.RequireScope(data.ScopeUsersRead)
+		write := app.RequireScope(data.ScopeUsersWrite)
+
+		mux.With(read, app.RequireRole(data.RoleAdmin)).Get("/", app.allUsers)
+		mux.With(read, app.RequireSelfOrAdmin("userID")).Get("/{userID}", app.getUser)
+		mux.With(write, app.RequireRole(data.RoleAdmin)).Delete("/{userID}", app.deleteUser)
+		mux.With(write, app.RequireRole(data.RoleAdmin)).Put("/", app.insertUser)
+		mux.With(write).Patch("/", app.updateUser) // the user id is in the body, so updateUser checks self or admin itself
+
+		mux.With(write, app.RequireRole(data.RoleAdmin)).Post("/import", app.importUsers)
+		mux.With(read, app.RequireRole(data.RoleAdmin)).Get("/export", app.exportUsers)
+
+		mux.With(write, app.RequireRole(data.RoleAdmin)).Post("/{userID}/revoke-tokens", app.revokeUserTokens)
+		mux.With(write, app.RequireRole(data.RoleAdmin)).Post("/{userID}/unlock", app.unlockUser)
+		mux.With(write, app.RequireRole(data.RoleAdmin)).Post("/{userID}/restore", app.restoreUser)
+
+		mux.With(read, app.RequireSelfOrAdmin("userID")).Get("/{userID}/image", app.getUserImage)
+		mux.With(write, app.RequireSelfOrAdmin("userID")).Post("/{userID}/image", app.uploadUserImage)
 	})
 
 	return mux
@@ -2,10 +2,13 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"sort"
 	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
+	"gopkg.in/yaml.v2"
 )
 
 func TestApp_routes(t *testing.T) {
@@ -15,12 +18,39 @@ func TestApp_routes(t *testing.T) {
 	}{
 		{"/test", "GET"},
 		{"/auth", "POST"},
+		{"/auth/mfa", "POST"},
+		{"/password/forgot", "POST"},
+		{"/password/reset", "POST"},
 		{"/refresh-token", "POST"},
+		{"/.well-known/jwks.json", "GET"},
 		{"/users/", "GET"},
 		{"/users/", "PUT"},
 		{"/users/{userID}", "GET"},
 		{"/users/{userID}", "DELETE"},
 		{"/users/", "PATCH"},
+		{"/logout", "POST"},
+		{"/api-keys/", "GET"},
+		{"/api-keys/", "POST"},
+		{"/api-keys/{keyID}", "DELETE"},
+		{"/users/{userID}/revoke-tokens", "POST"},
+		{"/users/{userID}/unlock", "POST"},
+		{"/users/{userID}/restore", "POST"},
+		{"/users/{userID}/image", "GET"},
+		{"/users/{userID}/image", "POST"},
+		{"/audit-log", "GET"},
+		{"/me", "GET"},
+		{"/me", "PATCH"},
+		{"/me/password", "POST"},
+		{"/users/import", "POST"},
+		{"/users/export", "GET"},
+		{"/.well-known/openid-configuration", "GET"},
+		{"/authorize", "GET"},
+		{"/authorize", "POST"},
+		{"/token", "POST"},
+		{"/userinfo", "GET"},
+		{"/oauth/clients", "POST"},
+		{"/docs", "GET"},
+		{"/docs/openapi.yaml", "GET"},
 	}
 
 	mux := app.routes()
@@ -46,3 +76,95 @@ func routeExists(testRoute, testMethod string, chiRoutes chi.Routes) bool {
 
 	return found
 }
+
+// undocumentedRoutes serve the documentation itself, so they are not part of it.
+var undocumentedRoutes = map[string]bool{
+	"GET /docs":              true,
+	"GET /docs/openapi.yaml": true,
+}
+
+func TestApp_routesMatchSpec(t *testing.T) {
+	raw, err := docsFS.ReadFile("docs/openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spec struct {
+		OpenAPI string                            `yaml:"openapi"`
+		Paths   map[string]map[string]interface{} `yaml:"paths"`
+	}
+	err = yaml.Unmarshal(raw, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("expected an OpenAPI 3 document, but got version %q", spec.OpenAPI)
+	}
+
+	documented := map[string]bool{}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			switch method {
+			case "get", "put", "post", "delete", "options", "head", "patch", "trace":
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	routed := map[string]bool{}
+	_ = chi.Walk(app.routes().(chi.Routes), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if key := method + " " + route; !undocumentedRoutes[key] {
+			routed[key] = true
+		}
+		return nil
+	})
+
+	for _, route := range sortedKeys(routed) {
+		if !documented[route] {
+			t.Errorf("route %s is missing from docs/openapi.yaml", route)
+		}
+	}
+	for _, route := range sortedKeys(documented) {
+		if !routed[route] {
+			t.Errorf("docs/openapi.yaml lists %s, but there is no such route", route)
+		}
+	}
+}
+
+func TestApp_docs(t *testing.T) {
+	var tests = []struct {
+		path        string
+		contentType string
+		contains    string
+	}{
+		{"/docs", "text/html; charset=utf-8", "/docs/openapi.yaml"},
+		{"/docs/openapi.yaml", "application/yaml", "openapi: 3."},
+	}
+
+	mux := app.routes()
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.path, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, but got %d", e.path, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != e.contentType {
+			t.Errorf("%s: expected content type %s, but got %s", e.path, e.contentType, ct)
+		}
+		if !strings.Contains(rr.Body.String(), e.contains) {
+			t.Errorf("%s: expected %q in the body", e.path, e.contains)
+		}
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
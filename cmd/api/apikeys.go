@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to recognize
+const apiKeyPrefix = "wak_"
+
+// apiKeyTouchInterval limits how often the last use of a key is written to the database
+var apiKeyTouchInterval = time.Minute
+
+type createAPIKeyRequest struct {
+	UserID    int       `json:"user_id"` // admins may create keys for other users, e.g. service accounts
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewAPIKey is the answer to creating a key; this is the only time the key itself is shown.
+type NewAPIKey struct {
+	APIKey *data.APIKey `json:"api_key"`
+	Key    string       `json:"key"`
+}
+
+// getAPIKeyFromHeaderAndVerify checks the key of an `Authorization: ApiKey <key>` header, and
+// returns claims for the owner of the key, limited to its scopes.
+func (app *application) getAPIKeyFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (*Claims, error) {
+	w.Header().Add("Vary", "Authorization")
+
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != "ApiKey" {
+		return nil, errors.New("invalid auth header")
+	}
+
+	key, err := app.DB.GetAPIKeyByHash(hashCode(headerParts[1]))
+	if err != nil {
+		return nil, errors.New("unknown api key")
+	}
+
+	now := time.Now()
+	if !key.IsActive(now) {
+		return nil, errors.New("api key revoked or expired")
+	}
+
+	user, err := app.DB.GetUser(key.UserID)
+	if err != nil {
+		return nil, errors.New("unknown user")
+	}
+
+	if now.Sub(key.LastUsedAt) > apiKeyTouchInterval {
+		err = app.DB.TouchAPIKey(key.ID, now)
+		if err != nil {
+			log.Printf("error recording use of api key %d: %v", key.ID, err)
+		}
+	}
+
+	claims := &Claims{
+		UserName: fmt.Sprintf("%s %s", user.FirstName, user.LastName),
+		Roles:    user.Roles,
+		APIKeyID: key.ID,
+		Scopes:   key.Scopes,
+	}
+	claims.Subject = fmt.Sprint(user.ID)
+
+	return claims, nil
+}
+
+// listAPIKeys lists the API keys of the caller; admins may list those of another user with ?user_id=.
+func (app *application) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	claims := app.claimsFromContext(r.Context())
+
+	userID, err := claims.UserID()
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if param := r.URL.Query().Get("user_id"); param != "" {
+		if !claims.HasRole(data.RoleAdmin) {
+			app.problemJSON(w, errors.New("forbidden"), http.StatusForbidden)
+			return
+		}
+
+		userID, err = strconv.Atoi(param)
+		if err != nil {
+			app.problemJSON(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	keys, err := app.DB.ListAPIKeys(userID)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if keys == nil {
+		keys = []*data.APIKey{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, keys)
+}
+
+// createAPIKey creates a new API key, and returns it once; only its hash is kept.
+func (app *application) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var payload createAPIKeyRequest
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	claims := app.claimsFromContext(r.Context())
+
+	ownerID, err := claims.UserID()
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if payload.UserID != 0 && payload.UserID != ownerID {
+		if !claims.HasRole(data.RoleAdmin) {
+			app.problemJSON(w, errors.New("forbidden"), http.StatusForbidden)
+			return
+		}
+
+		_, err = app.DB.GetUser(payload.UserID)
+		if err != nil {
+			app.problemJSON(w, errors.New("unknown user"), http.StatusBadRequest)
+			return
+		}
+		ownerID = payload.UserID
+	}
+
+	if strings.TrimSpace(payload.Name) == "" {
+		app.problemJSON(w, errors.New("name is required"), http.StatusBadRequest)
+		return
+	}
+
+	if len(payload.Scopes) == 0 {
+		app.problemJSON(w, errors.New("at least one scope is required"), http.StatusBadRequest)
+		return
+	}
+	for _, scope := range payload.Scopes {
+		if !containsString(data.Scopes, scope) {
+			app.problemJSON(w, fmt.Errorf("unknown scope %q", scope), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !payload.ExpiresAt.IsZero() && !payload.ExpiresAt.After(time.Now()) {
+		app.problemJSON(w, errors.New("expires_at must be in the future"), http.StatusBadRequest)
+		return
+	}
+
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	apiKey := data.APIKey{
+		UserID:    ownerID,
+		Name:      payload.Name,
+		Prefix:    prefix,
+		KeyHash:   hashCode(key),
+		Scopes:    payload.Scopes,
+		ExpiresAt: payload.ExpiresAt,
+	}
+
+	apiKey.ID, err = app.DB.InsertAPIKey(apiKey)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{
+		Action:  "api_key.create",
+		Target:  fmt.Sprintf("api_key:%d", apiKey.ID),
+		Details: fmt.Sprintf("user %d, scopes %s", ownerID, strings.Join(apiKey.Scopes, ",")),
+	})
+
+	stored, err := app.DB.GetAPIKey(apiKey.ID)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, NewAPIKey{APIKey: stored, Key: key})
+}
+
+// revokeAPIKey revokes one of the caller's API keys; admins may revoke anyone's.
+func (app *application) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.Atoi(chi.URLParam(r, "keyID"))
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	key, err := app.DB.GetAPIKey(keyID)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+
+	claims := app.claimsFromContext(r.Context())
+	if fmt.Sprint(key.UserID) != claims.Subject && !claims.HasRole(data.RoleAdmin) {
+		// don't tell others which keys exist
+		app.problemJSON(w, errors.New("api key not found"), http.StatusNotFound)
+		return
+	}
+
+	err = app.DB.RevokeAPIKey(key.ID)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{Action: "api_key.revoke", Target: fmt.Sprintf("api_key:%d", key.ID)})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newAPIKey returns a random API key, and the part of it which is shown to tell keys apart.
+func newAPIKey() (string, string, error) {
+	id := make([]byte, 4)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix := apiKeyPrefix + hex.EncodeToString(id)
+
+	return prefix + "_" + base64.RawURLEncoding.EncodeToString(secret), prefix, nil
+}
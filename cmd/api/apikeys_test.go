@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func Test_app_apiKeys(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+	app.DB = &dbrepo.TestDBRepo{}
+
+	claims := &Claims{Roles: []string{data.RoleUser}}
+	claims.Subject = "1"
+
+	withClaims := func(req *http.Request) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), contextClaimsKey, claims))
+	}
+
+	var createTests = []struct {
+		name               string
+		json               string
+		expectedStatusCode int
+	}{
+		{"no name", `{"scopes":["users:read"]}`, http.StatusBadRequest},
+		{"no scopes", `{"name":"ci"}`, http.StatusBadRequest},
+		{"unknown scope", `{"name":"ci","scopes":["users:admin"]}`, http.StatusBadRequest},
+		{"expired", `{"name":"ci","scopes":["users:read"],"expires_at":"2000-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"for another user", `{"name":"ci","scopes":["users:read"],"user_id":2}`, http.StatusForbidden},
+		{"valid", `{"name":"ci","scopes":["users:read"]}`, http.StatusCreated},
+	}
+
+	var created NewAPIKey
+	for _, e := range createTests {
+		req, _ := http.NewRequest("POST", "/api-keys/", strings.NewReader(e.json))
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.createAPIKey).ServeHTTP(rr, withClaims(req))
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusCreated {
+			_ = json.NewDecoder(rr.Body).Decode(&created)
+		}
+	}
+
+	if !strings.HasPrefix(created.Key, created.APIKey.Prefix+"_") {
+		t.Fatalf("expected the key to start with its prefix, got %+v", created)
+	}
+
+	// the key only shows in the list by its prefix
+	req, _ := http.NewRequest("GET", "/api-keys/", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.listAPIKeys).ServeHTTP(rr, withClaims(req))
+
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), created.Key) || !strings.Contains(rr.Body.String(), created.APIKey.Prefix) {
+		t.Errorf("unexpected list of api keys: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// the key works within its scopes only
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	var useTests = []struct {
+		name               string
+		header             string
+		scope              string
+		expectedStatusCode int
+	}{
+		{"read", "ApiKey " + created.Key, data.ScopeUsersRead, http.StatusOK},
+		{"write", "ApiKey " + created.Key, data.ScopeUsersWrite, http.StatusForbidden},
+		{"unknown key", "ApiKey wak_00000000_nonsense", data.ScopeUsersRead, http.StatusUnauthorized},
+	}
+
+	for _, e := range useTests {
+		req, _ := http.NewRequest("GET", "/users/1", nil)
+		req.Header.Set("Authorization", e.header)
+		rr := httptest.NewRecorder()
+
+		app.authRequired(app.RequireScope(e.scope)(nextHandler)).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	stored, _ := app.DB.GetAPIKey(created.APIKey.ID)
+	if stored.LastUsedAt.IsZero() {
+		t.Error("expected the last use of the key to be recorded")
+	}
+
+	// keys are not accepted where a session is needed
+	req, _ = http.NewRequest("POST", "/logout", nil)
+	req.Header.Set("Authorization", "ApiKey "+created.Key)
+	rr = httptest.NewRecorder()
+	app.bearerRequired(nextHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected api key to be rejected by bearerRequired, got %v", rr.Code)
+	}
+
+	// revoked keys stop working
+	req, _ = http.NewRequest("DELETE", "/api-keys/1", nil)
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("keyID", "1")
+	req = withClaims(req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx)))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.revokeAPIKey).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("revoke: expected code %v, but got %v", http.StatusNoContent, rr.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("Authorization", "ApiKey "+created.Key)
+	rr = httptest.NewRecorder()
+	app.authRequired(nextHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected revoked key to be rejected, got %v", rr.Code)
+	}
+}
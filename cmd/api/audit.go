@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// AuditList is one page of GET /audit-log.
+type AuditList struct {
+	Entries []data.AuditEntry `json:"entries"`
+	Page    AuditPageMeta     `json:"page"`
+}
+
+// AuditPageMeta describes the page of audit entries returned, and how to get the next one.
+type AuditPageMeta struct {
+	Limit  int `json:"limit"`
+	Before int `json:"next_before,omitempty"` // pass as before to get the next page
+}
+
+// audit writes e to the audit log, as done by the caller from the IP address of the request,
+// unless e names another actor. Failures are logged only, so that they don't fail the action.
+func (app *application) audit(r *http.Request, e data.AuditEntry) {
+	if e.Actor == "" {
+		if claims := app.claimsFromContext(r.Context()); claims != nil {
+			e.Actor = claims.Subject
+		}
+	}
+	e.IP = clientIP(r)
+
+	err := app.DB.InsertAuditEntry(e)
+	if err != nil {
+		log.Println("writing audit log:", err)
+	}
+}
+
+// auditLog lists the audit log, newest first. It can be filtered by actor, action (or a group of
+// actions, like user.), target and time, and is paged with the next_before of the previous page.
+func (app *application) auditLog(w http.ResponseWriter, r *http.Request) {
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	page, err := app.DB.ListAuditEntries(q)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, AuditList{
+		Entries: page.Entries,
+		Page:    AuditPageMeta{Limit: q.Limit, Before: page.Next},
+	})
+}
+
+// parseAuditQuery reads the filters and page of GET /audit-log from the query string.
+func parseAuditQuery(r *http.Request) (repository.AuditQuery, error) {
+	params := r.URL.Query()
+	q := repository.AuditQuery{
+		Actor:  params.Get("actor"),
+		Action: params.Get("action"),
+		Target: params.Get("target"),
+		Limit:  repository.DefaultAuditLimit,
+	}
+
+	var err error
+
+	if v := params.Get("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil {
+			return q, errors.New("limit must be a number")
+		}
+	}
+
+	if v := params.Get("before"); v != "" {
+		q.BeforeID, err = strconv.Atoi(v)
+		if err != nil {
+			return q, errors.New("before must be a number")
+		}
+	}
+
+	q.CreatedAfter, err = parseDate(params.Get("created_after"))
+	if err != nil {
+		return q, fmt.Errorf("created_after: %w", err)
+	}
+
+	q.CreatedBefore, err = parseDate(params.Get("created_before"))
+	if err != nil {
+		return q, fmt.Errorf("created_before: %w", err)
+	}
+
+	return q, q.Validate()
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_app_auditUserChanges(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	db := &dbrepo.TestDBRepo{}
+	db.AddUsers(data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Roles: []string{data.RoleUser}})
+	app.DB = db
+
+	req, _ := http.NewRequest("PATCH", "/users/", strings.NewReader(`{"id":2,"first_name":"Janet","last_name":"Doe","email":"jane@example.com","roles":["user"],"password":"correct horse 1"}`))
+	req.Header.Set("If-Match", "*")
+	req.RemoteAddr = "192.0.2.1:1234"
+	claims := &Claims{Roles: []string{data.RoleAdmin}}
+	claims.Subject = "1"
+	req = req.WithContext(context.WithValue(req.Context(), contextClaimsKey, claims))
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.updateUser).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected code %v, but got %v", http.StatusNoContent, rr.Code)
+	}
+
+	entries := db.AuditLog()
+	if len(entries) != 1 {
+		t.Fatalf("expected one audit entry, but got %v", entries)
+	}
+
+	e := entries[0]
+	if e.Actor != "1" || e.Action != "user.update" || e.Target != "user:2" || e.IP != "192.0.2.1" {
+		t.Errorf("unexpected audit entry %+v", e)
+	}
+
+	expected := data.Changes{
+		"first_name": {Before: "Jane", After: "Janet"},
+		"password":   {After: data.Redacted},
+	}
+	if fmt.Sprint(e.Changes) != fmt.Sprint(expected) {
+		t.Errorf("expected changes %v, but got %v", expected, e.Changes)
+	}
+}
+
+func Test_app_auditLog(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	db := &dbrepo.TestDBRepo{}
+	app.DB = db
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, e := range []data.AuditEntry{
+		{Actor: "1", Action: "login", Target: "user:1", CreatedAt: day},
+		{Actor: "1", Action: "user.create", Target: "user:2", CreatedAt: day.Add(time.Hour)},
+		{Actor: "1", Action: "user.update", Target: "user:2", CreatedAt: day.Add(2 * time.Hour)},
+		{Actor: "2", Action: "login", Target: "user:2", CreatedAt: day.Add(24 * time.Hour)},
+		{Actor: "1", Action: "user.delete", Target: "user:2", CreatedAt: day.Add(48 * time.Hour)},
+	} {
+		_ = db.InsertAuditEntry(e)
+	}
+
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedIDs        string
+		expectedBefore     int
+	}{
+		{"newest first", "", http.StatusOK, "5 4 3 2 1", 0},
+		{"first page", "limit=2", http.StatusOK, "5 4", 4},
+		{"next page", "limit=2&before=4", http.StatusOK, "3 2", 2},
+		{"actor", "actor=2", http.StatusOK, "4", 0},
+		{"action", "action=login", http.StatusOK, "4 1", 0},
+		{"action group", "action=user.", http.StatusOK, "5 3 2", 0},
+		{"target", "target=user:2&action=user.", http.StatusOK, "5 3 2", 0},
+		{"created range", "created_after=2024-03-01T01:00:00Z&created_before=2024-03-03", http.StatusOK, "4 3 2", 0},
+		{"limit too large", "limit=1000", http.StatusBadRequest, "", 0},
+		{"bad cursor", "before=latest", http.StatusBadRequest, "", 0},
+		{"bad date", "created_after=yesterday", http.StatusBadRequest, "", 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/audit-log?"+e.query, nil)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.auditLog).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var list AuditList
+		err := json.NewDecoder(rr.Body).Decode(&list)
+		if err != nil {
+			t.Errorf("%s: decoding response: %s", e.name, err)
+			continue
+		}
+
+		var ids []string
+		for _, entry := range list.Entries {
+			ids = append(ids, fmt.Sprint(entry.ID))
+		}
+		if strings.Join(ids, " ") != e.expectedIDs {
+			t.Errorf("%s: expected entries %s, but got %s", e.name, e.expectedIDs, strings.Join(ids, " "))
+		}
+		if list.Page.Before != e.expectedBefore {
+			t.Errorf("%s: expected next_before %d, but got %d", e.name, e.expectedBefore, list.Page.Before)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 	"webapp/pkg/data"
@@ -11,20 +14,102 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var jwtTokenExpiry = time.Minute * 15
-var refreshTokenExpiry = time.Hour * 24
+// Access tokens carry their issue time to the microsecond, rather than the second: revoking the
+// tokens of a user must tell those issued right before apart from those issued right after. The
+// library reads the times as floats, so they are rounded to the microsecond again by IssueTime.
+func init() {
+	jwt.TimePrecision = time.Nanosecond
+}
+
+// newIssueTime returns the issue time of a token issued now
+func newIssueTime() *jwt.NumericDate {
+	return jwt.NewNumericDate(time.Now().Truncate(time.Microsecond))
+}
 
 type TokenPairs struct {
 	Token        string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+
+	// refresh token details, to be recorded in the refresh token store
+	RefreshTokenID        string    `json:"-"`
+	RefreshTokenExpiresAt time.Time `json:"-"`
+}
+
+// RefreshPolicy decides when a refresh token may be exchanged for a new token pair.
+type RefreshPolicy struct {
+	// RenewWithin only allows a refresh once the refresh token expires within this duration.
+	// Zero allows a refresh at any time.
+	RenewWithin time.Duration
+}
+
+// Allows reports whether a refresh token expiring at expiresAt may be used at now.
+func (p RefreshPolicy) Allows(expiresAt, now time.Time) bool {
+	if p.RenewWithin <= 0 {
+		return true
+	}
+	return expiresAt.Sub(now) <= p.RenewWithin
 }
 
 type Claims struct {
-	UserName string `json:"name"`
+	UserName string   `json:"name"`
+	Roles    []string `json:"roles"`
 	jwt.RegisteredClaims
+
+	// set for access tokens issued to OAuth clients, with the scopes granted to the client
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+
+	// set when the request was authenticated with an API key rather than a token, and for tokens
+	// of OAuth clients
+	APIKeyID int      `json:"-"`
+	Scopes   []string `json:"-"`
+}
+
+// HasRole reports whether the claims carry the given role.
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the claims allow an action of the given scope. Tokens allow everything
+// their user may do; API keys and the tokens of OAuth clients are limited to the scopes they were
+// granted.
+func (c *Claims) HasScope(scope string) bool {
+	if c.APIKeyID == 0 && c.ClientID == "" {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IssueTime returns when the token was issued, or the zero time if it doesn't say.
+func (c *Claims) IssueTime() time.Time {
+	if c.IssuedAt == nil {
+		return time.Time{}
+	}
+	return c.IssuedAt.Time.Round(time.Microsecond)
+}
+
+// UserID returns the id of the user the claims were issued to.
+func (c *Claims) UserID() (int, error) {
+	return strconv.Atoi(c.Subject)
 }
 
 func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Request) (string, *Claims, error) {
+	return app.verifyTokenFromHeader(w, r, app.Domain)
+}
+
+// verifyTokenFromHeader checks the bearer token of the request, which must be meant for audience:
+// app.Domain for access tokens of the api itself, oauthAudience for those of OAuth clients.
+func (app *application) verifyTokenFromHeader(w http.ResponseWriter, r *http.Request, audience string) (string, *Claims, error) {
 
 	/*
 		we expect our authorization header to look like this:
@@ -59,14 +144,9 @@ func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *ht
 	// declare an empty Claims variable
 	claims := &Claims{}
 
-	// parse the token with our claims (we read into claims), using our secret (from the receiver)
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		// validate the signing algorithm
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(app.JWTSecret), nil
-	})
+	// parse the token with our claims (we read into claims), picking the verification key by its kid;
+	// the key ring also makes sure that the signing algorithm matches the key
+	_, err := jwt.ParseWithClaims(token, claims, app.Keys.Keyfunc)
 
 	// TODO: ok to use strings?
 	// check for an error; note that this catches expired token as well
@@ -82,53 +162,127 @@ func (app *application) getTokenFromHeaderAndVerify(w http.ResponseWriter, r *ht
 		return "", nil, errors.New("incorrect issuer")
 	}
 
+	// make sure that it is the right kind of access token, and not e.g. an mfa challenge
+	if !claims.VerifyAudience(audience, true) {
+		return "", nil, errors.New("incorrect audience")
+	}
+	// only the tokens of OAuth clients name a client, whose scopes limit them
+	if (claims.ClientID != "") != (audience == oauthAudience) {
+		return "", nil, errors.New("incorrect audience")
+	}
+	claims.Scopes = strings.Fields(claims.Scope)
+
+	// make sure that the token was not revoked in the meantime
+	userID, err := claims.UserID()
+	if err != nil {
+		return "", nil, errors.New("invalid subject")
+	}
+
+	denied, err := app.Denylist.IsDenied(claims.ID, userID, claims.IssueTime())
+	if err != nil {
+		return "", nil, err
+	}
+	if denied {
+		return "", nil, errors.New("revoked token")
+	}
+
 	// valid token
 	return token, claims, nil
 }
 
 func (app *application) generateTokenPairs(user *data.User) (TokenPairs, error) {
-	// create the token
-	token := jwt.New(jwt.SigningMethodHS256)
-
 	// set claims
-	claims := token.Claims.(jwt.MapClaims)
+	claims := jwt.MapClaims{}
 	claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
 	claims["sub"] = fmt.Sprint(user.ID)
 	claims["aud"] = app.Domain
 	claims["iss"] = app.Domain
-	if user.IsAdmin == 1 {
-		claims["admin"] = true
-	} else {
-		claims["admin"] = false
+	claims["roles"] = user.Roles
+
+	// the jti lets us revoke this very token before it expires
+	accessTokenID, err := newTokenID()
+	if err != nil {
+		return TokenPairs{}, err
 	}
+	claims["jti"] = accessTokenID
 
-	// set expiry
-	claims["exp"] = time.Now().Add(jwtTokenExpiry).Unix()
+	// set issue time and expiry
+	claims["iat"] = newIssueTime()
+	claims["exp"] = time.Now().Add(app.Lifetimes.Access).Unix()
 
-	// create signed token
-	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
+	// create signed token with the current key of the key ring
+	signedAccessToken, err := app.Keys.Sign(claims)
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
-	// create the refresh token
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
+	// set refresh token claims; the jti identifies the token in the refresh token store
+	refreshTokenID, err := newTokenID()
+	if err != nil {
+		return TokenPairs{}, err
+	}
+	refreshTokenExpiresAt := time.Now().Add(app.Lifetimes.Refresh) // must be longer than jwt expiry
 
-	// set refresh token claims
-	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
+	refreshTokenClaims := jwt.MapClaims{}
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
-	refreshTokenClaims["exp"] = time.Now().Add(refreshTokenExpiry).Unix() // must be longer than jwt expiry
+	refreshTokenClaims["jti"] = refreshTokenID
+	refreshTokenClaims["exp"] = refreshTokenExpiresAt.Unix()
 
-	signedRefreshToken, err := refreshToken.SignedString([]byte(app.JWTSecret))
+	// create signed refresh token
+	signedRefreshToken, err := app.Keys.Sign(refreshTokenClaims)
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
 	var tokenPairs = TokenPairs{
-		Token:        signedAccessToken,
-		RefreshToken: signedRefreshToken,
+		Token:                 signedAccessToken,
+		RefreshToken:          signedRefreshToken,
+		RefreshTokenID:        refreshTokenID,
+		RefreshTokenExpiresAt: refreshTokenExpiresAt,
 	}
 
 	return tokenPairs, nil
+}
+
+// issueTokenPairs generates a token pair for a new login of the user, and records the refresh token
+// as the first member of a new token family.
+func (app *application) issueTokenPairs(user *data.User, device string) (TokenPairs, error) {
+	tokenPairs, err := app.generateTokenPairs(user)
+	if err != nil {
+		return TokenPairs{}, err
+	}
 
+	familyID, err := newTokenID()
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	err = app.DB.InsertRefreshToken(refreshTokenRecord(tokenPairs, user.ID, familyID, device))
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	return tokenPairs, nil
+}
+
+// refreshTokenRecord describes the refresh token of tokenPairs for the refresh token store.
+func refreshTokenRecord(tokenPairs TokenPairs, userID int, familyID, device string) data.RefreshToken {
+	return data.RefreshToken{
+		ID:        tokenPairs.RefreshTokenID,
+		UserID:    userID,
+		FamilyID:  familyID,
+		Device:    device,
+		IssuedAt:  time.Now(),
+		ExpiresAt: tokenPairs.RefreshTokenExpiresAt,
+	}
+}
+
+// newTokenID returns a random identifier for tokens and token families.
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 	"webapp/pkg/data"
 )
 
@@ -62,3 +63,25 @@ func TestApp_getTokenFromHeaderAndVerify(t *testing.T) {
 
 	}
 }
+
+func TestRefreshPolicy_Allows(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		renewWithin time.Duration
+		expiresAt   time.Time
+		allowed     bool
+	}{
+		{"no restriction", 0, now.Add(time.Hour), true},
+		{"within window", 30 * time.Second, now.Add(10 * time.Second), true},
+		{"outside window", 30 * time.Second, now.Add(time.Hour), false},
+	}
+
+	for _, e := range tests {
+		policy := RefreshPolicy{RenewWithin: e.renewWithin}
+		if policy.Allows(e.expiresAt, now) != e.allowed {
+			t.Errorf("%s: expected allowed to be %v", e.name, e.allowed)
+		}
+	}
+}
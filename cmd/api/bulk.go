@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"webapp/pkg/bulk"
+	"webapp/pkg/data"
+)
+
+// maxImportBytes limits the size of the files importUsers accepts
+const maxImportBytes = 10 << 20
+
+// importUsers creates the users of a CSV or NDJSON body, as told by its Content-Type. Every row is
+// checked first: if one is invalid, no user is created and the report lists the problems of each
+// row. With dry_run=true the rows are only checked.
+func (app *application) importUsers(w http.ResponseWriter, r *http.Request) {
+	format, err := bulk.ParseFormat(r.Header.Get("Content-Type"))
+	if err != nil {
+		app.problemJSON(w, err, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var dryRun bool
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		dryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			app.problemJSON(w, errors.New("dry_run must be true or false"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	rows, err := bulk.Read(http.MaxBytesReader(w, r.Body, maxImportBytes), format)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	report, err := bulk.Import(app.DB, rows, dryRun)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+
+	status := http.StatusOK
+	switch {
+	case report.Invalid > 0:
+		status = http.StatusUnprocessableEntity
+	case report.Created > 0:
+		status = http.StatusCreated
+		app.audit(r, data.AuditEntry{Action: "user.import", Target: "users", Details: fmt.Sprintf("%d users", report.Created)})
+	}
+
+	_ = app.writeJSON(w, status, report)
+}
+
+// exportUsers sends every user as CSV or NDJSON, as chosen by the format parameter; CSV by default.
+func (app *application) exportUsers(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = string(bulk.CSV)
+	}
+
+	format, err := bulk.ParseFormat(name)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, format))
+
+	// the status is sent with the first users, so errors after that can only be logged
+	err = bulk.Export(w, format, app.DB)
+	if err != nil {
+		log.Printf("error exporting users: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/bulk"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_app_importUsers(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+	app.DB = &dbrepo.TestDBRepo{}
+
+	csv := "first_name,last_name,email\nJane,Doe,jane@example.com\n"
+
+	var tests = []struct {
+		name               string
+		query              string
+		contentType        string
+		body               string
+		expectedStatusCode int
+		expectedCreated    int
+	}{
+		{"unsupported content type", "", "application/json", `{}`, http.StatusUnsupportedMediaType, 0},
+		{"malformed file", "", "text/csv", "first_name\nJane\n", http.StatusBadRequest, 0},
+		{"bad dry_run", "?dry_run=maybe", "text/csv", csv, http.StatusBadRequest, 0},
+		{"invalid row", "", "text/csv", csv + "Admin,Again,admin@example.com\n", http.StatusUnprocessableEntity, 0},
+		{"dry run", "?dry_run=true", "text/csv", csv, http.StatusOK, 0},
+		{"import", "", "text/csv; charset=utf-8", csv, http.StatusCreated, 1},
+		{"imported before", "", "text/csv", csv, http.StatusUnprocessableEntity, 0},
+		{"ndjson", "", "application/x-ndjson", `{"first_name":"Jim","last_name":"Doe","email":"jim@example.com","roles":["admin"]}`, http.StatusCreated, 1},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/users/import"+e.query, strings.NewReader(e.body))
+		req.Header.Set("Content-Type", e.contentType)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.importUsers)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body.String())
+			continue
+		}
+
+		if rr.Header().Get("Content-Type") != "application/json" {
+			continue
+		}
+
+		var report bulk.Report
+		err := json.NewDecoder(rr.Body).Decode(&report)
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		if report.Created != e.expectedCreated {
+			t.Errorf("%s: expected %d users created, but got %d", e.name, e.expectedCreated, report.Created)
+		}
+	}
+}
+
+func Test_app_exportUsers(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedType       string
+		expectedBody       string
+	}{
+		{"csv by default", "", http.StatusOK, "text/csv; charset=utf-8", "first_name,last_name,email,roles\nAdmin,User,admin@example.com,admin\n"},
+		{"ndjson", "?format=ndjson", http.StatusOK, "application/x-ndjson", `{"first_name":"Admin","last_name":"User","email":"admin@example.com","roles":["admin"]}` + "\n"},
+		{"unknown format", "?format=xml", http.StatusBadRequest, "application/problem+json", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/export"+e.query, nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.exportUsers)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != e.expectedType {
+			t.Errorf("%s: expected content type %s, but got %s", e.name, e.expectedType, ct)
+		}
+		if e.expectedBody != "" && rr.Body.String() != e.expectedBody {
+			t.Errorf("%s: unexpected body %q", e.name, rr.Body.String())
+		}
+	}
+}
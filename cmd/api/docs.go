@@ -0,0 +1,36 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+// docsFS holds the OpenAPI document of the routes, and the page showing it.
+//
+//go:embed docs
+var docsFS embed.FS
+
+// docs shows the API documentation, rendered from the OpenAPI document by Swagger UI.
+func (app *application) docs(w http.ResponseWriter, r *http.Request) {
+	page, err := docsFS.ReadFile("docs/index.html")
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(page)
+}
+
+// openAPISpec serves the OpenAPI 3 document describing every route.
+func (app *application) openAPISpec(w http.ResponseWriter, r *http.Request) {
+	spec, err := docsFS.ReadFile("docs/openapi.yaml")
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	_, _ = w.Write(spec)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"webapp/pkg/data"
+)
+
+var (
+	errIfMatchRequired = errors.New("If-Match header is required; send the ETag of the user")
+	errIfMatchInvalid  = errors.New("If-Match must be a single ETag of the user, or *")
+)
+
+// userETag returns the entity tag of a user. It is the version of the user, which every update
+// increments.
+func userETag(u *data.User) string {
+	return `"` + strconv.Itoa(u.Version) + `"`
+}
+
+// etagMatches reports whether the If-None-Match list header contains etag, or is *. Tags are
+// compared weakly, as RFC 9110 asks for If-None-Match.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion returns the user version the If-Match header of r asks for, or 0 for *, which
+// matches any version. It fails with errIfMatchRequired without the header, so that clients
+// can't overwrite changes they haven't seen by leaving it out.
+func ifMatchVersion(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return 0, errIfMatchRequired
+	}
+	if header == "*" {
+		return 0, nil
+	}
+
+	// If-Match compares strongly, so weak tags never match
+	if !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) || len(header) < 2 {
+		return 0, errIfMatchInvalid
+	}
+
+	version, err := strconv.Atoi(header[1 : len(header)-1])
+	if err != nil || version < 1 {
+		return 0, errIfMatchInvalid
+	}
+
+	return version, nil
+}
+
+// preconditionFailed answers a missing If-Match header with 428, and an unusable one with 412.
+func (app *application) preconditionFailed(w http.ResponseWriter, err error) {
+	if err == errIfMatchRequired {
+		app.problemJSON(w, err, http.StatusPreconditionRequired)
+		return
+	}
+	app.problemJSON(w, err, http.StatusPreconditionFailed)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func Test_app_userETags(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	db := &dbrepo.TestDBRepo{}
+	db.AddUsers(data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Version: 5})
+	app.DB = db
+
+	var tests = []struct {
+		name               string
+		method             string
+		handler            http.HandlerFunc
+		header             string
+		value              string
+		expectedStatusCode int
+	}{
+		{"get", "GET", app.getUser, "", "", http.StatusOK},
+		{"get unchanged", "GET", app.getUser, "If-None-Match", `"5"`, http.StatusNotModified},
+		{"get unchanged weak", "GET", app.getUser, "If-None-Match", `W/"5"`, http.StatusNotModified},
+		{"get unchanged in list", "GET", app.getUser, "If-None-Match", `"4", "5"`, http.StatusNotModified},
+		{"get changed", "GET", app.getUser, "If-None-Match", `"4"`, http.StatusOK},
+		{"delete without If-Match", "DELETE", app.deleteUser, "", "", http.StatusPreconditionRequired},
+		{"delete stale", "DELETE", app.deleteUser, "If-Match", `"4"`, http.StatusPreconditionFailed},
+		{"delete malformed", "DELETE", app.deleteUser, "If-Match", `5`, http.StatusPreconditionFailed},
+		{"delete current", "DELETE", app.deleteUser, "If-Match", `"5"`, http.StatusNoContent},
+		{"delete deleted", "DELETE", app.deleteUser, "If-Match", "*", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, "/users/2", nil)
+		if e.header != "" {
+			req.Header.Set(e.header, e.value)
+		}
+		chiCtx := chi.NewRouteContext()
+		chiCtx.URLParams.Add("userID", "2")
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx))
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.method == "GET" && rr.Header().Get("ETag") != `"5"` {
+			t.Errorf("%s: expected ETag \"5\", but got %q", e.name, rr.Header().Get("ETag"))
+		}
+		if rr.Code == http.StatusNotModified && rr.Body.Len() > 0 {
+			t.Errorf("%s: expected no body with 304", e.name)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"webapp/pkg/data"
+	"webapp/pkg/upload"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// uploadStatus returns the status code of the errors of upload.Uploader.
+func uploadStatus(err error) int {
+	switch {
+	case errors.Is(err, upload.ErrTooBig):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, upload.ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, upload.ErrNoFile), errors.Is(err, upload.ErrTooManyFiles):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// uploadUserImage stores the image of a multipart upload, which must hold just the one file, as
+// the profile picture of a user, replacing the previous one.
+func (app *application) uploadUserImage(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+
+	u := &upload.Uploader{Dir: app.UploadDir, MaxBytes: app.MaxUploadBytes, Types: upload.ImageTypes}
+	file, err := u.SaveOne(r)
+	if err != nil {
+		app.problemJSON(w, err, uploadStatus(err))
+		return
+	}
+
+	image := data.UserImage{UserID: user.ID, FileName: file.FileName}
+	image.ID, err = app.DB.InsertUserImage(image)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+	app.removeUnusedImage(u, user.ProfilePic.FileName)
+
+	app.audit(r, data.AuditEntry{
+		Action:  "user.update",
+		Target:  fmt.Sprintf("user:%d", user.ID),
+		Changes: data.Changes{"profile_pic": {Before: user.ProfilePic.FileName, After: image.FileName}},
+	})
+
+	w.Header().Set("Location", fmt.Sprintf("/users/%d/image", user.ID))
+	_ = app.writeJSON(w, http.StatusCreated, image)
+}
+
+// removeUnusedImage removes the image file name, which was replaced, unless some user still has it
+// as profile picture. The replacement went through either way, so failures are only logged.
+func (app *application) removeUnusedImage(u *upload.Uploader, name string) {
+	if name == "" {
+		return
+	}
+
+	inUse, err := app.DB.UserImageInUse(name)
+	if err == nil && !inUse {
+		err = u.Remove(name)
+	}
+	if err != nil {
+		log.Printf("error removing replaced image %s: %v", name, err)
+	}
+}
+
+// getUserImage sends the profile picture of a user. Clients have to revalidate their copy, as a
+// new upload replaces the image under the same URL; unchanged images are answered with 304.
+func (app *application) getUserImage(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+	if user.ProfilePic.FileName == "" {
+		app.problemJSON(w, errors.New("the user has no profile picture"), http.StatusNotFound)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(app.UploadDir, filepath.Base(user.ProfilePic.FileName)))
+	if os.IsNotExist(err) {
+		app.problemJSON(w, errors.New("the profile picture is gone"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the type comes from the content, which was checked on upload, never from the file name
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+
+	// ServeContent answers conditional and range requests
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// withUserParam adds the userID URL parameter and the claims of user 2 to req
+func withUserParam(req *http.Request, userID string) *http.Request {
+	chiCtx := chi.NewRouteContext()
+	chiCtx.URLParams.Add("userID", userID)
+	claims := &Claims{Roles: []string{data.RoleUser}}
+	claims.Subject = "2"
+	ctx := context.WithValue(req.Context(), chi.RouteCtxKey, chiCtx)
+	return req.WithContext(context.WithValue(ctx, contextClaimsKey, claims))
+}
+
+func Test_app_userImage(t *testing.T) {
+	oldDB, oldDir := app.DB, app.UploadDir
+	defer func() { app.DB, app.UploadDir = oldDB, oldDir }()
+
+	db := &dbrepo.TestDBRepo{}
+	db.AddUsers(data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"})
+	app.DB = db
+	app.UploadDir = t.TempDir()
+
+	img := new(bytes.Buffer)
+	_ = png.Encode(img, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+
+	tests := []struct {
+		name               string
+		paramId            string
+		fileName           string
+		content            []byte
+		secondFile         bool
+		expectedStatusCode int
+	}{
+		{"png", "2", "jane.png", img.Bytes(), false, http.StatusCreated},
+		{"not an image", "2", "jane.html", []byte("<html><script>alert(1)</script></html>"), false, http.StatusUnsupportedMediaType},
+		{"no file", "2", "", nil, false, http.StatusBadRequest},
+		{"two files", "2", "jane2.png", img.Bytes(), true, http.StatusBadRequest},
+		{"unknown user", "9", "jim.png", img.Bytes(), false, http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		if e.fileName != "" {
+			part, _ := mw.CreateFormFile("file", e.fileName)
+			_, _ = part.Write(e.content)
+		}
+		if e.secondFile {
+			part, _ := mw.CreateFormFile("other", e.fileName)
+			_, _ = part.Write(e.content)
+		}
+		_ = mw.Close()
+
+		req, _ := http.NewRequest("POST", "/users/"+e.paramId+"/image", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req = withUserParam(req, e.paramId)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.uploadUserImage)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body)
+		}
+	}
+
+	// only the picture of the first upload was stored, under a name of its own
+	entries := db.AuditLog()
+	if len(entries) == 0 {
+		t.Fatal("expected the upload to be audited")
+	}
+	stored, _ := entries[len(entries)-1].Changes["profile_pic"].After.(string)
+	if files, _ := os.ReadDir(app.UploadDir); len(files) != 1 || files[0].Name() != stored || stored == "jane.png" {
+		t.Errorf("expected just the image to be stored under a new name, but got %v, and audited %q", files, stored)
+	}
+
+	// the image comes back with its type, and can be revalidated
+	req, _ := http.NewRequest("GET", "/users/2/image", nil)
+	req = withUserParam(req, "2")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.getUserImage).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("get: expected code %v, but got %v", http.StatusOK, rr.Code)
+	}
+	if !bytes.Equal(rr.Body.Bytes(), img.Bytes()) {
+		t.Error("get: expected the uploaded image")
+	}
+	for header, expected := range map[string]string{"Content-Type": "image/png", "Cache-Control": "private, no-cache", "X-Content-Type-Options": "nosniff"} {
+		if got := rr.Header().Get(header); got != expected {
+			t.Errorf("get: expected %s %q, but got %q", header, expected, got)
+		}
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" || rr.Header().Get("Last-Modified") == "" {
+		t.Errorf("get: expected ETag and Last-Modified headers, but got %v", rr.Header())
+	}
+
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.getUserImage).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("get with If-None-Match: expected code %v, but got %v", http.StatusNotModified, rr.Code)
+	}
+
+	// users without a picture
+	req, _ = http.NewRequest("GET", "/users/1/image", nil)
+	req = withUserParam(req, "1")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.getUserImage).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("no picture: expected code %v, but got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func Test_app_userImageReplace(t *testing.T) {
+	oldDB, oldDir := app.DB, app.UploadDir
+	defer func() { app.DB, app.UploadDir = oldDB, oldDir }()
+
+	db := &dbrepo.TestDBRepo{}
+	db.AddUsers(
+		data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"},
+		data.User{ID: 3, FirstName: "Jim", LastName: "Doe", Email: "jim@example.com", DeletedAt: time.Now(), ProfilePic: data.UserImage{FileName: "shared.png"}},
+	)
+	app.DB = db
+	app.UploadDir = t.TempDir()
+
+	img := new(bytes.Buffer)
+	_ = png.Encode(img, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+
+	upload := func() string {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		part, _ := mw.CreateFormFile("file", "jane.png")
+		_, _ = part.Write(img.Bytes())
+		_ = mw.Close()
+
+		req, _ := http.NewRequest("POST", "/users/2/image", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req = withUserParam(req, "2")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.uploadUserImage).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected code %v, but got %v", http.StatusCreated, rr.Code)
+		}
+		var image data.UserImage
+		_ = json.NewDecoder(rr.Body).Decode(&image)
+		return image.FileName
+	}
+
+	// replacing a picture removes its file
+	first := upload()
+	second := upload()
+	if _, err := os.Stat(filepath.Join(app.UploadDir, first)); !os.IsNotExist(err) {
+		t.Errorf("expected the replaced file %s to be removed, but got %v", first, err)
+	}
+	if _, err := os.Stat(filepath.Join(app.UploadDir, second)); err != nil {
+		t.Errorf("expected the new file %s to be kept, but got %v", second, err)
+	}
+
+	// unless another user, even a deleted one, has the same file
+	_ = os.WriteFile(filepath.Join(app.UploadDir, "shared.png"), img.Bytes(), 0644)
+	_, _ = db.InsertUserImage(data.UserImage{UserID: 2, FileName: "shared.png"})
+	upload()
+	if _, err := os.Stat(filepath.Join(app.UploadDir, "shared.png")); err != nil {
+		t.Errorf("expected the file of another user to be kept, but got %v", err)
+	}
+}
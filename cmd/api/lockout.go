@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"webapp/pkg/lockout"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// loginKeys returns the throttle keys of a login attempt: the account it is for, and the client it comes from.
+func loginKeys(email, ip string) []string {
+	return []string{lockout.AccountKey(email), lockout.IPKey(ip)}
+}
+
+// loginWait returns how long the client has to wait before it may try to log in again. Errors of the
+// lockout store are logged, and do not block logins.
+func (app *application) loginWait(keys ...string) time.Duration {
+	wait, err := app.Lockout.Check(keys...)
+	if err != nil {
+		log.Println("error checking login throttle:", err)
+		return 0
+	}
+	return wait
+}
+
+// loginFailed records a failed login for keys
+func (app *application) loginFailed(ip string, keys ...string) {
+	err := app.Lockout.Fail(ip, keys...)
+	if err != nil {
+		log.Println("error recording failed login:", err)
+	}
+}
+
+// loginSucceeded forgets the failed logins of the account. The client keeps its count, so that
+// logging into one's own account now and then does not reset an attack on others.
+func (app *application) loginSucceeded(email string) {
+	err := app.Lockout.Succeed(lockout.AccountKey(email))
+	if err != nil {
+		log.Println("error resetting login throttle:", err)
+	}
+}
+
+// tooManyLogins answers a login attempt that came too early with 429 Too Many Requests.
+func (app *application) tooManyLogins(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	app.problemJSON(w, fmt.Errorf("too many failed logins, try again in %s", wait.Round(time.Second)), http.StatusTooManyRequests)
+}
+
+// unlockUser lifts the lockout of a user's account before it runs out.
+func (app *application) unlockUser(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUser(userId)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+
+	claims := app.claimsFromContext(r.Context())
+
+	err = app.Lockout.Unlock(lockout.AccountKey(user.Email), claims.Subject, clientIP(r))
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientIP returns the IP address the request came from
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -2,40 +2,122 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"webapp/pkg/config"
+	"webapp/pkg/keyring"
+	"webapp/pkg/lockout"
+	"webapp/pkg/mailer"
 	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/signedtoken"
 )
 
-const port = 8080
-
 type application struct {
-	DSN       string
-	DB        repository.DatabaseRepo
-	Domain    string
-	JWTSecret string
+	DSN      string
+	DB       repository.DatabaseRepo
+	Domain   string
+	BaseURL  string
+	Keys     *keyring.KeyRing
+	Denylist repository.TokenDenylist
+	Lockout  *lockout.Guard
+	Mailer   mailer.Mailer
+	Tokens   *signedtoken.Signer
+
+	// PasswordResetURL is the page password reset links point to; the token is added as query parameter
+	PasswordResetURL string
+
+	RefreshPolicy RefreshPolicy
+	Lifetimes     config.Lifetimes
+
+	// AllowedOrigins may call the api from a browser; "*" allows any origin
+	AllowedOrigins []string
+	MaxJSONBytes   int64
+
+	// UploadDir holds the profile pictures, shared with the web app
+	UploadDir      string
+	MaxUploadBytes int64
 }
 
 func main() {
 	var app application
 
-	flag.StringVar(&app.Domain, "domain", "example.com", "Domain for application, e.g. company.com")
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres Connection")
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "eraser-secret", "signing secret")
-	flag.Parse()
+	cfg, err := config.Load(os.Args[0], os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app.DSN = cfg.DSN
+	app.Domain = cfg.Domain
+	app.BaseURL = cfg.API.BaseURL
+	app.PasswordResetURL = cfg.PasswordResetURL
+	app.Lifetimes = cfg.Lifetimes
+	app.RefreshPolicy.RenewWithin = cfg.JWT.RefreshRenewal
+	app.AllowedOrigins = cfg.CORS.AllowedOrigins
+	app.MaxJSONBytes = cfg.Uploads.MaxJSONBytes
+	app.UploadDir = cfg.Uploads.Dir
+	app.MaxUploadBytes = cfg.Uploads.MaxBytes
+
+	// set up the signing keys; retired keys must verify tokens for as long as the longest lived token
+	if cfg.JWT.KeyDir != "" {
+		app.Keys, err = keyring.Load(cfg.JWT.KeyDir, cfg.JWT.Algorithm, cfg.Lifetimes.Refresh)
+	} else {
+		app.Keys, err = keyring.New(cfg.JWT.Algorithm, cfg.Lifetimes.Refresh)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.JWT.KeyRotation > 0 {
+		stop := app.Keys.StartRotation(cfg.JWT.KeyRotation, func(err error) {
+			log.Printf("error rotating signing key: %v", err)
+		})
+		defer stop()
+	}
+
+	// connect to DB; refresh tokens are kept in the database
+	conn, err := app.connectToDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	app.Lockout = &lockout.Guard{Repo: app.DB, Policy: lockout.DefaultPolicy()}
+
+	// set up the denylist of revoked access tokens; only postgres is shared between api instances
+	switch cfg.TokenDenylist {
+	case "postgres":
+		app.Denylist = &dbrepo.PostgresDenylist{DB: conn}
+	case "memory":
+		app.Denylist = &dbrepo.MemoryDenylist{}
+	default:
+		log.Fatalf("unknown token denylist %q", cfg.TokenDenylist)
+	}
 
-	// connect to DB
-	// conn, err := app.connectToDB()
-	// if err != nil {
-	// 	log.Fatal(err)
-	// }
-	// defer conn.Close()
-	// app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	app.Mailer, err = mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// without a shared secret, reset links only work with this very process
+	if cfg.TokenSecret == "" {
+		log.Println("no token secret configured, using a random one")
+		key, err := signedtoken.NewKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.Tokens = &signedtoken.Signer{Key: key}
+	} else {
+		app.Tokens = &signedtoken.Signer{Key: []byte(cfg.TokenSecret)}
+	}
 
-	log.Printf("Starting api on port %d\n", port)
+	log.Printf("Starting api on port %d (%s profile)\n", cfg.API.Port, cfg.Profile)
 
-	err := http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
+	err = http.ListenAndServe(cfg.API.Addr(), app.routes())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/validation"
+)
+
+// updateMeRequest is the payload of PATCH /me. Fields which are left out keep their value; roles
+// can't be changed, and the password only with POST /me/password.
+type updateMeRequest struct {
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Email     *string `json:"email"`
+}
+
+// profile is the part of a user which users may change themselves.
+type profile struct {
+	FirstName string `json:"first_name" validate:"required,max=255"`
+	LastName  string `json:"last_name" validate:"required,max=255"`
+	Email     string `json:"email" validate:"required,email,max=255"`
+}
+
+// apply sets the fields of the payload on u, and checks the result.
+func (p *updateMeRequest) apply(u *data.User) error {
+	if p.FirstName != nil {
+		u.FirstName = *p.FirstName
+	}
+	if p.LastName != nil {
+		u.LastName = *p.LastName
+	}
+	if p.Email != nil {
+		u.Email = *p.Email
+	}
+
+	if errs := validation.Struct(&profile{FirstName: u.FirstName, LastName: u.LastName, Email: u.Email}); errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// changePasswordRequest is the payload of POST /me/password.
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+// me returns the user the claims of the request were issued to. It sends the error response, and
+// returns nil, if the user can't be found.
+func (app *application) me(w http.ResponseWriter, r *http.Request) *data.User {
+	claims := app.claimsFromContext(r.Context())
+	if claims == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return nil
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		app.problemJSON(w, errors.New("invalid subject"), http.StatusUnauthorized)
+		return nil
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		app.problemJSON(w, err)
+		return nil
+	}
+	return user
+}
+
+// getMe sends the caller's own user, so that clients can find out who they are.
+func (app *application) getMe(w http.ResponseWriter, r *http.Request) {
+	user := app.me(w, r)
+	if user == nil {
+		return
+	}
+
+	app.writeUser(w, r, user)
+}
+
+// updateMe changes the names and email address of the caller, if the user is still the version
+// named by the If-Match header.
+func (app *application) updateMe(w http.ResponseWriter, r *http.Request) {
+	version, err := ifMatchVersion(r)
+	if err != nil {
+		app.preconditionFailed(w, err)
+		return
+	}
+
+	var payload updateMeRequest
+	err = app.readJSON(w, r, &payload)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	existing := app.me(w, r)
+	if existing == nil {
+		return
+	}
+
+	user := *existing
+	err = payload.apply(&user)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+	user.Version = version
+
+	err = app.DB.UpdateUser(user)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{Action: "user.update", Target: fmt.Sprintf("user:%d", user.ID), Changes: data.UserChanges(existing, &user)})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// changePassword sets a new password for the caller, who has to know the current one. Every other
+// session of the user ends; the caller gets a new token pair in place of the revoked one.
+func (app *application) changePassword(w http.ResponseWriter, r *http.Request) {
+	var payload changePasswordRequest
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user := app.me(w, r)
+	if user == nil {
+		return
+	}
+
+	v := validation.New()
+	v.Struct(&payload)
+	if payload.NewPassword != "" {
+		v.Password("new_password", payload.NewPassword, validation.DefaultPasswordPolicy, personalValues(user.Email, user.FirstName, user.LastName)...)
+	}
+	if !v.Valid() {
+		app.problemJSON(w, v.Err())
+		return
+	}
+
+	// a stolen token must not become a way of guessing the password
+	ip := clientIP(r)
+	keys := loginKeys(user.Email, ip)
+	if wait := app.loginWait(keys...); wait > 0 {
+		app.tooManyLogins(w, wait)
+		return
+	}
+
+	ok, err := user.PasswordMatches(payload.CurrentPassword)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		app.loginFailed(ip, keys...)
+		app.problemJSON(w, validation.Errors{"current_password": {"is incorrect"}})
+		return
+	}
+
+	err = app.DB.ResetPassword(user.ID, payload.NewPassword)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+
+	// the caller's token too; the pair issued below stays valid, as long as it is from a later
+	// microsecond, which tokens tell apart
+	err = app.Denylist.DenyUser(user.ID, time.Now())
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	time.Sleep(time.Microsecond)
+
+	err = app.DB.RevokeUserRefreshTokens(user.ID)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	tokenPairs, err := app.issueTokenPairs(user, r.UserAgent())
+	if err != nil {
+		app.problemJSON(w, errors.New("password changed, but no new tokens could be issued; please log in again"), http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{Action: "password.change", Target: fmt.Sprintf("user:%d", user.ID), Changes: data.Changes{"password": {After: data.Redacted}}})
+
+	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/lockout"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_app_getMe(t *testing.T) {
+	claims := &Claims{Roles: []string{data.RoleUser}}
+	claims.Subject = "1"
+
+	req, _ := http.NewRequest("GET", "/me", nil)
+	req = req.WithContext(context.WithValue(req.Context(), contextClaimsKey, claims))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.getMe).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %v, but got %v", http.StatusOK, rr.Code)
+	}
+
+	var user data.User
+	_ = json.NewDecoder(rr.Body).Decode(&user)
+	if user.ID != 1 || user.Email != "admin@example.com" {
+		t.Errorf("expected the admin user, but got %+v", user)
+	}
+
+	// the client has this version already
+	etag := rr.Header().Get("ETag")
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.getMe).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("expected code %v for If-None-Match %s, but got %v", http.StatusNotModified, etag, rr.Code)
+	}
+
+	// tokens of users who are gone
+	claims.Subject = "9"
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.getMe).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown user: expected code %v, but got %v", http.StatusNotFound, rr.Code)
+	}
+}
+
+func Test_app_updateMe(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	db := &dbrepo.TestDBRepo{}
+	db.AddUsers(data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Roles: []string{data.RoleUser}})
+	app.DB = db
+
+	tests := []struct {
+		name               string
+		json               string
+		ifMatch            string
+		expectedStatusCode int
+	}{
+		{"first name only", `{"first_name":"Janet"}`, `"1"`, http.StatusNoContent},
+		{"stale version", `{"last_name":"Dough"}`, `"1"`, http.StatusPreconditionFailed},
+		{"without If-Match", `{"last_name":"Dough"}`, "", http.StatusPreconditionRequired},
+		{"invalid email", `{"email":"jane"}`, `"2"`, http.StatusUnprocessableEntity},
+		{"blank name", `{"last_name":""}`, `"2"`, http.StatusUnprocessableEntity},
+		{"duplicate email", `{"email":"admin@example.com"}`, `"2"`, http.StatusConflict},
+		{"roles", `{"roles":["admin"]}`, `"2"`, http.StatusBadRequest},
+		{"password", `{"password":"correct horse 1"}`, `"2"`, http.StatusBadRequest},
+		{"email", `{"email":"janet@example.com"}`, `"2"`, http.StatusNoContent},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("PATCH", "/me", strings.NewReader(e.json))
+		if e.ifMatch != "" {
+			req.Header.Set("If-Match", e.ifMatch)
+		}
+		claims := &Claims{Roles: []string{data.RoleUser}}
+		claims.Subject = "2"
+		req = req.WithContext(context.WithValue(req.Context(), contextClaimsKey, claims))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.updateMe)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	user, _ := db.GetUser(2)
+	if user.FirstName != "Janet" || user.LastName != "Doe" || user.Email != "janet@example.com" {
+		t.Errorf("expected only the first name and email address to change, but got %+v", user)
+	}
+	if len(user.Roles) != 1 || user.Roles[0] != data.RoleUser {
+		t.Errorf("expected the roles to be kept, but got %v", user.Roles)
+	}
+
+	entries := db.AuditLog()
+	if len(entries) == 0 {
+		t.Fatal("expected an audit entry")
+	}
+	last := entries[len(entries)-1]
+	if last.Action != "user.update" || last.Actor != "2" || last.Changes["email"].After != "janet@example.com" {
+		t.Errorf("expected the update to be audited, but got %+v", last)
+	}
+}
+
+func Test_app_changePassword(t *testing.T) {
+	oldDB, oldDenylist, oldLockout := app.DB, app.Denylist, app.Lockout
+	defer func() { app.DB, app.Denylist, app.Lockout = oldDB, oldDenylist, oldLockout }()
+	db := &dbrepo.TestDBRepo{}
+	app.DB = db
+	app.Denylist = &dbrepo.MemoryDenylist{}
+	app.Lockout = &lockout.Guard{Repo: db, Policy: lockout.DefaultPolicy()}
+
+	user, _ := db.GetUser(1)
+	tokens, _ := app.issueTokenPairs(user, "test")
+
+	var tests = []struct {
+		name               string
+		json               string
+		expectedStatusCode int
+	}{
+		{"wrong password", `{"current_password":"wrong","new_password":"a new password"}`, http.StatusUnprocessableEntity},
+		{"weak password", `{"current_password":"secret","new_password":"short"}`, http.StatusUnprocessableEntity},
+		{"missing password", `{"new_password":"a new password"}`, http.StatusUnprocessableEntity},
+		{"unknown field", `{"current_password":"secret","password":"a new password"}`, http.StatusBadRequest},
+		{"valid", `{"current_password":"secret","new_password":"a new password"}`, http.StatusOK},
+	}
+
+	var rr *httptest.ResponseRecorder
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/me/password", strings.NewReader(e.json))
+		req.Header.Set("Authorization", "Bearer "+tokens.Token)
+		rr = httptest.NewRecorder()
+
+		handler := app.bearerRequired(http.HandlerFunc(app.changePassword))
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	user, _ = db.GetUser(1)
+	if ok, _ := user.PasswordMatches("a new password"); !ok {
+		t.Error("expected the new password to be set")
+	}
+
+	// the old session has ended, the new pair works
+	verify := func(token string) error {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, _, err := app.getTokenFromHeaderAndVerify(httptest.NewRecorder(), req)
+		return err
+	}
+	if verify(tokens.Token) == nil {
+		t.Error("expected the old access token to be revoked")
+	}
+
+	var newTokens TokenPairs
+	_ = json.NewDecoder(rr.Body).Decode(&newTokens)
+	if err := verify(newTokens.Token); err != nil {
+		t.Errorf("expected the new access token to be valid, but got %v", err)
+	}
+
+	entries := db.AuditLog()
+	if len(entries) == 0 {
+		t.Fatal("expected an audit entry")
+	}
+	last := entries[len(entries)-1]
+	if last.Action != "password.change" || last.Changes["password"].After != data.Redacted {
+		t.Errorf("expected the change to be audited, but got %+v", last)
+	}
+}
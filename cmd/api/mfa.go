@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/totp"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// mfaAudience is the audience of mfa challenge tokens; it keeps them from being accepted as access tokens.
+const mfaAudience = "mfa"
+
+// MFAChallenge is the answer to a correct password of a user with two-factor authentication. The
+// token has to be exchanged, together with a code, at /auth/mfa.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type mfaCredentials struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// requiresMFA reports whether the user has finished enrolling a second factor.
+func (app *application) requiresMFA(userID int) (bool, error) {
+	enrollment, err := app.DB.GetUserTOTP(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return enrollment.IsConfirmed(), nil
+}
+
+// secondFactorPasses checks code as second factor of the user. Users without two-factor
+// authentication always pass.
+func (app *application) secondFactorPasses(userID int, code string) (bool, error) {
+	enrollment, err := app.DB.GetUserTOTP(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !enrollment.IsConfirmed() {
+		return true, nil
+	}
+	if code == "" {
+		return false, nil
+	}
+
+	return totp.Verify(app.DB, enrollment, code, time.Now())
+}
+
+// generateMFAToken returns a short-lived token which proves that the user got their password right.
+func (app *application) generateMFAToken(user *data.User) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{}
+	claims["sub"] = fmt.Sprint(user.ID)
+	claims["aud"] = mfaAudience
+	claims["iss"] = app.Domain
+	claims["jti"] = tokenID
+	claims["iat"] = newIssueTime()
+	claims["exp"] = time.Now().Add(app.Lifetimes.MFA).Unix()
+
+	return app.Keys.Sign(claims)
+}
+
+// authenticateMFA exchanges an mfa challenge token and a TOTP or recovery code for a token pair.
+func (app *application) authenticateMFA(w http.ResponseWriter, r *http.Request) {
+	var creds mfaCredentials
+
+	err := app.readJSON(w, r, &creds)
+	if err != nil {
+		app.problemJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(creds.MFAToken, claims, app.Keys.Keyfunc)
+	if err != nil || claims.Issuer != app.Domain || !claims.VerifyAudience(mfaAudience, true) ||
+		claims.IssuedAt == nil || claims.ExpiresAt == nil {
+		app.problemJSON(w, errors.New("invalid mfa token"), http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := claims.UserID()
+	if err != nil {
+		app.problemJSON(w, errors.New("invalid mfa token"), http.StatusUnauthorized)
+		return
+	}
+
+	// challenge tokens are single use
+	denied, err := app.Denylist.IsDenied(claims.ID, userID, claims.IssueTime())
+	if err != nil || denied {
+		app.problemJSON(w, errors.New("invalid mfa token"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		app.problemJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	// guessing codes counts against the same limits as guessing passwords
+	ip := clientIP(r)
+	keys := loginKeys(user.Email, ip)
+	if wait := app.loginWait(keys...); wait > 0 {
+		app.tooManyLogins(w, wait)
+		return
+	}
+
+	ok, err := app.secondFactorPasses(user.ID, creds.Code)
+	if err != nil || !ok {
+		app.loginFailed(ip, keys...)
+		app.problemJSON(w, errors.New("invalid code"), http.StatusUnauthorized)
+		return
+	}
+	app.loginSucceeded(user.Email)
+
+	err = app.Denylist.DenyToken(claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	tokenPairs, err := app.issueTokenPairs(user, r.UserAgent())
+	if err != nil {
+		app.problemJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{Actor: fmt.Sprint(user.ID), Action: "login", Target: fmt.Sprintf("user:%d", user.ID), Details: "password and second factor"})
+
+	_ = app.writeJSON(w, http.StatusOK, tokenPairs)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/lockout"
+	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/totp"
+)
+
+func Test_app_authenticateMFA(t *testing.T) {
+	oldDB, oldLockout := app.DB, app.Lockout
+	defer func() { app.DB, app.Lockout = oldDB, oldLockout }()
+	app.DB = &dbrepo.TestDBRepo{}
+	app.Lockout = &lockout.Guard{Repo: app.DB, Policy: lockout.DefaultPolicy()}
+
+	// enroll the admin user
+	secret, _ := totp.GenerateSecret()
+	_ = app.DB.SaveUserTOTP(data.UserTOTP{UserID: 1, Secret: secret})
+	_ = app.DB.ConfirmUserTOTP(1, []string{totp.HashRecoveryCode("aaaaa-bbbbb")})
+
+	// the password alone only gets a challenge
+	req, _ := http.NewRequest("POST", "/auth", strings.NewReader(`{"email":"admin@example.com","password":"secret"}`))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.authenticate).ServeHTTP(rr, req)
+
+	var challenge MFAChallenge
+	_ = json.NewDecoder(rr.Body).Decode(&challenge)
+	if rr.Code != http.StatusOK || !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("expected an mfa challenge, got %d %+v", rr.Code, challenge)
+	}
+
+	// the challenge is no access token
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+challenge.MFAToken)
+	if _, _, err := app.getTokenFromHeaderAndVerify(httptest.NewRecorder(), req); err == nil {
+		t.Error("expected the mfa token to be rejected as access token")
+	}
+
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+
+	var tests = []struct {
+		name               string
+		token              string
+		code               string
+		expectedStatusCode int
+	}{
+		{"wrong code", challenge.MFAToken, "000000", http.StatusUnauthorized},
+		{"bad token", "nonsense", code, http.StatusUnauthorized},
+		{"valid code", challenge.MFAToken, code, http.StatusOK},
+		{"replayed token", challenge.MFAToken, code, http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		body := fmt.Sprintf(`{"mfa_token":%q,"code":%q}`, e.token, e.code)
+		req, _ := http.NewRequest("POST", "/auth/mfa", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.authenticateMFA)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	// recovery codes work once
+	user, _ := app.DB.GetUser(1)
+	for i, expected := range []int{http.StatusOK, http.StatusUnauthorized} {
+		token, _ := app.generateMFAToken(user)
+		body := fmt.Sprintf(`{"mfa_token":%q,"code":"AAAAA-BBBBB"}`, token)
+		req, _ := http.NewRequest("POST", "/auth/mfa", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.authenticateMFA).ServeHTTP(rr, req)
+
+		if rr.Code != expected {
+			t.Errorf("recovery code, attempt %d: expected code %v, but got %v", i, expected, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+const authorizationCodeExpiry = time.Minute * 2
+
+var supportedScopes = []string{"openid", "profile", "email"}
+
+// oauthAudience is the audience of the access tokens of OAuth clients; it keeps them from being
+// accepted as access tokens of the api, which carry the roles of the user.
+const oauthAudience = "oauth"
+
+// authorizeRequest holds the parameters of an authorization request. They are carried through
+// the login and consent form unchanged.
+type authorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func authorizeRequestFrom(v url.Values) authorizeRequest {
+	return authorizeRequest{
+		ClientID:            v.Get("client_id"),
+		RedirectURI:         v.Get("redirect_uri"),
+		ResponseType:        v.Get("response_type"),
+		Scope:               v.Get("scope"),
+		State:               v.Get("state"),
+		Nonce:               v.Get("nonce"),
+		CodeChallenge:       v.Get("code_challenge"),
+		CodeChallengeMethod: v.Get("code_challenge_method"),
+	}
+}
+
+// authorizePage is the template data of the login and consent page
+type authorizePage struct {
+	Client  *data.OAuthClient
+	Request authorizeRequest
+	Scopes  []string
+	Email   string
+	Error   string
+}
+
+// openIDConfiguration serves the OpenID Connect discovery document.
+func (app *application) openIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	var payload = struct {
+		Issuer                            string   `json:"issuer"`
+		AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+		TokenEndpoint                     string   `json:"token_endpoint"`
+		UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+		JWKSURI                           string   `json:"jwks_uri"`
+		ResponseTypesSupported            []string `json:"response_types_supported"`
+		GrantTypesSupported               []string `json:"grant_types_supported"`
+		SubjectTypesSupported             []string `json:"subject_types_supported"`
+		IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+		ScopesSupported                   []string `json:"scopes_supported"`
+		TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+		CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+		ClaimsSupported                   []string `json:"claims_supported"`
+	}{
+		Issuer:                            app.BaseURL,
+		AuthorizationEndpoint:             app.BaseURL + "/authorize",
+		TokenEndpoint:                     app.BaseURL + "/token",
+		UserInfoEndpoint:                  app.BaseURL + "/userinfo",
+		JWKSURI:                           app.BaseURL + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()},
+		ScopesSupported:                   supportedScopes,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "name", "given_name", "family_name", "email", "nonce", "auth_time"},
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// authorize shows the login and consent page for a valid authorization request.
+func (app *application) authorize(w http.ResponseWriter, r *http.Request) {
+	ar := authorizeRequestFrom(r.URL.Query())
+
+	client, ok := app.checkAuthorizeRequest(w, r, ar)
+	if !ok {
+		return
+	}
+
+	app.renderAuthorizePage(w, http.StatusOK, authorizePage{
+		Client:  client,
+		Request: ar,
+		Scopes:  strings.Fields(ar.Scope),
+	})
+}
+
+// authorizeLogin handles the login and consent form. On success, it redirects back to the client
+// with a single use authorization code.
+func (app *application) authorizeLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	ar := authorizeRequestFrom(r.PostForm)
+
+	client, ok := app.checkAuthorizeRequest(w, r, ar)
+	if !ok {
+		return
+	}
+
+	if r.PostForm.Get("consent") != "allow" {
+		app.redirectAuthorizeError(w, r, ar, "access_denied", "the user denied the request")
+		return
+	}
+
+	page := authorizePage{
+		Client:  client,
+		Request: ar,
+		Scopes:  strings.Fields(ar.Scope),
+		Email:   r.PostForm.Get("email"),
+		Error:   "Invalid login!",
+	}
+
+	ip := clientIP(r)
+	keys := loginKeys(page.Email, ip)
+	if wait := app.loginWait(keys...); wait > 0 {
+		page.Error = fmt.Sprintf("Too many failed logins, try again in %s.", wait.Round(time.Second))
+		app.renderAuthorizePage(w, http.StatusTooManyRequests, page)
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(page.Email)
+	if err != nil {
+		app.loginFailed(ip, keys...)
+		app.renderAuthorizePage(w, http.StatusUnauthorized, page)
+		return
+	}
+
+	if valid, err := user.PasswordMatches(r.PostForm.Get("password")); err != nil || !valid {
+		app.loginFailed(ip, keys...)
+		app.renderAuthorizePage(w, http.StatusUnauthorized, page)
+		return
+	}
+
+	if !user.Verified() {
+		page.Error = "Please confirm your email address first!"
+		app.renderAuthorizePage(w, http.StatusForbidden, page)
+		return
+	}
+
+	// users with two-factor authentication send their code along with the password
+	ok, err = app.secondFactorPasses(user.ID, r.PostForm.Get("code"))
+	if err != nil || !ok {
+		if r.PostForm.Get("code") != "" {
+			app.loginFailed(ip, keys...)
+		}
+		page.Error = "Invalid or missing authentication code!"
+		app.renderAuthorizePage(w, http.StatusUnauthorized, page)
+		return
+	}
+	app.loginSucceeded(page.Email)
+
+	code, err := newTokenID()
+	if err != nil {
+		app.redirectAuthorizeError(w, r, ar, "server_error", "could not issue code")
+		return
+	}
+
+	err = app.DB.InsertAuthorizationCode(data.AuthorizationCode{
+		CodeHash:            hashCode(code),
+		ClientID:            client.ID,
+		UserID:              user.ID,
+		RedirectURI:         ar.RedirectURI,
+		Scope:               ar.Scope,
+		Nonce:               ar.Nonce,
+		CodeChallenge:       ar.CodeChallenge,
+		CodeChallengeMethod: ar.CodeChallengeMethod,
+		ExpiresAt:           time.Now().Add(authorizationCodeExpiry),
+	})
+	if err != nil {
+		log.Printf("error storing authorization code: %v", err)
+		app.redirectAuthorizeError(w, r, ar, "server_error", "could not issue code")
+		return
+	}
+
+	app.audit(r, data.AuditEntry{Actor: fmt.Sprint(user.ID), Action: "login", Target: fmt.Sprintf("user:%d", user.ID), Details: "oauth client " + client.ID})
+
+	app.redirectToClient(w, r, ar, url.Values{"code": {code}})
+}
+
+// checkAuthorizeRequest validates an authorization request. Problems with the client or the
+// redirect uri are shown to the user, since we cannot trust the redirect uri; all other problems
+// are reported back to the client. It returns false if a response was written.
+func (app *application) checkAuthorizeRequest(w http.ResponseWriter, r *http.Request, ar authorizeRequest) (*data.OAuthClient, bool) {
+	client, err := app.DB.GetOAuthClient(ar.ClientID)
+	if err != nil {
+		app.renderAuthorizePage(w, http.StatusBadRequest, authorizePage{Error: "Unknown client."})
+		return nil, false
+	}
+
+	if !client.AllowsRedirect(ar.RedirectURI) {
+		app.renderAuthorizePage(w, http.StatusBadRequest, authorizePage{Error: "Invalid redirect uri."})
+		return nil, false
+	}
+
+	if ar.ResponseType != "code" {
+		app.redirectAuthorizeError(w, r, ar, "unsupported_response_type", "only the code response type is supported")
+		return nil, false
+	}
+
+	scopes := strings.Fields(ar.Scope)
+	if !containsString(scopes, "openid") {
+		app.redirectAuthorizeError(w, r, ar, "invalid_scope", "the openid scope is required")
+		return nil, false
+	}
+	for _, scope := range scopes {
+		if !containsString(supportedScopes, scope) {
+			app.redirectAuthorizeError(w, r, ar, "invalid_scope", fmt.Sprintf("unsupported scope %s", scope))
+			return nil, false
+		}
+	}
+
+	// PKCE is required for every client
+	if ar.CodeChallenge == "" || ar.CodeChallengeMethod != "S256" {
+		app.redirectAuthorizeError(w, r, ar, "invalid_request", "a S256 code challenge is required")
+		return nil, false
+	}
+
+	return client, true
+}
+
+func (app *application) redirectAuthorizeError(w http.ResponseWriter, r *http.Request, ar authorizeRequest, code, description string) {
+	app.redirectToClient(w, r, ar, url.Values{
+		"error":             {code},
+		"error_description": {description},
+	})
+}
+
+// redirectToClient sends the user back to the redirect uri of the request, adding params and the state.
+func (app *application) redirectToClient(w http.ResponseWriter, r *http.Request, ar authorizeRequest, params url.Values) {
+	target, err := url.Parse(ar.RedirectURI)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if ar.State != "" {
+		query.Set("state", ar.State)
+	}
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (app *application) renderAuthorizePage(w http.ResponseWriter, status int, page authorizePage) {
+	parsedTemplate, err := template.ParseFS(templateFS, "templates/authorize.page.gohtml")
+	if err != nil {
+		log.Printf("error parsing authorize template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	err = parsedTemplate.Execute(w, page)
+	if err != nil {
+		log.Printf("error executing authorize template: %v", err)
+	}
+}
+
+// token exchanges an authorization code for an access token and an id token. The access token only
+// grants the scopes of the code, and is not accepted by the rest of the api.
+func (app *application) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.oauthError(w, http.StatusBadRequest, "invalid_request", "malformed form")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		app.oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "only the authorization_code grant is supported")
+		return
+	}
+
+	// clients authenticate with basic auth, with form values, or - if public - not at all
+	clientID, clientSecret, hasBasicAuth := r.BasicAuth()
+	if !hasBasicAuth {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+
+	client, err := app.DB.GetOAuthClient(clientID)
+	if err != nil {
+		app.oauthError(w, http.StatusUnauthorized, "invalid_client", "unknown client")
+		return
+	}
+
+	if !client.IsPublic() && !client.SecretMatches(clientSecret) {
+		app.oauthError(w, http.StatusUnauthorized, "invalid_client", "invalid client credentials")
+		return
+	}
+
+	code, err := app.DB.ConsumeAuthorizationCode(hashCode(r.PostForm.Get("code")))
+	if err != nil {
+		app.oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid authorization code")
+		return
+	}
+
+	if code.ClientID != client.ID || code.RedirectURI != r.PostForm.Get("redirect_uri") || time.Now().After(code.ExpiresAt) {
+		app.oauthError(w, http.StatusBadRequest, "invalid_grant", "invalid authorization code")
+		return
+	}
+
+	if !verifyCodeChallenge(code.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		app.oauthError(w, http.StatusBadRequest, "invalid_grant", "code verifier does not match")
+		return
+	}
+
+	user, err := app.DB.GetUser(code.UserID)
+	if err != nil {
+		app.oauthError(w, http.StatusBadRequest, "invalid_grant", "unknown user")
+		return
+	}
+
+	accessToken, err := app.generateOAuthAccessToken(user, client.ID, code.Scope)
+	if err != nil {
+		app.oauthError(w, http.StatusInternalServerError, "server_error", "could not issue tokens")
+		return
+	}
+
+	idToken, err := app.generateIDToken(user, client.ID, code.Nonce, strings.Fields(code.Scope))
+	if err != nil {
+		app.oauthError(w, http.StatusInternalServerError, "server_error", "could not issue tokens")
+		return
+	}
+
+	var payload = struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+		IDToken     string `json:"id_token"`
+		Scope       string `json:"scope"`
+	}{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(app.Lifetimes.Access.Seconds()),
+		IDToken:     idToken,
+		Scope:       code.Scope,
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// userInfo returns the claims about the user the access token was issued to, as far as the scopes
+// granted to the client allow.
+func (app *application) userInfo(w http.ResponseWriter, r *http.Request) {
+	claims := app.claimsFromContext(r.Context())
+
+	userID, err := claims.UserID()
+	if err != nil {
+		app.problemJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		app.problemJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
+		return
+	}
+
+	var payload = struct {
+		Subject    string `json:"sub"`
+		Name       string `json:"name,omitempty"`
+		GivenName  string `json:"given_name,omitempty"`
+		FamilyName string `json:"family_name,omitempty"`
+		Email      string `json:"email,omitempty"`
+	}{
+		Subject: claims.Subject,
+	}
+	if claims.HasScope("profile") {
+		payload.Name = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+		payload.GivenName = user.FirstName
+		payload.FamilyName = user.LastName
+	}
+	if claims.HasScope("email") {
+		payload.Email = user.Email
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// registerOAuthClient registers a new client. The client secret is only returned once; we only keep its hash.
+func (app *application) registerOAuthClient(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Public       bool     `json:"public"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.Name == "" || len(requestPayload.RedirectURIs) == 0 {
+		app.problemJSON(w, errors.New("name and redirect_uris are required"), http.StatusBadRequest)
+		return
+	}
+
+	for _, uri := range requestPayload.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			app.problemJSON(w, fmt.Errorf("invalid redirect uri %s", uri), http.StatusBadRequest)
+			return
+		}
+	}
+
+	clientID, err := newTokenID()
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	client := data.OAuthClient{
+		ID:           clientID,
+		Name:         requestPayload.Name,
+		RedirectURIs: requestPayload.RedirectURIs,
+	}
+
+	var clientSecret string
+	if !requestPayload.Public {
+		clientSecret, err = newTokenID()
+		if err != nil {
+			app.problemJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(clientSecret), 12)
+		if err != nil {
+			app.problemJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		client.SecretHash = string(hash)
+	}
+
+	err = app.DB.InsertOAuthClient(client)
+	if err != nil {
+		app.problemJSON(w, err)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{Action: "oauth_client.create", Target: "oauth_client:" + client.ID, Details: client.Name})
+
+	var payload = struct {
+		ClientID     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret,omitempty"`
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+	}{
+		ClientID:     client.ID,
+		ClientSecret: clientSecret,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, payload)
+}
+
+// generateOAuthAccessToken creates the access token of a client, for the scopes the user granted.
+// It carries no roles, and is only accepted by the routes for OAuth clients.
+func (app *application) generateOAuthAccessToken(user *data.User, clientID, scope string) (string, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{}
+	claims["sub"] = fmt.Sprint(user.ID)
+	claims["aud"] = oauthAudience
+	claims["iss"] = app.Domain
+	claims["jti"] = tokenID
+	claims["client_id"] = clientID
+	claims["scope"] = scope
+	claims["iat"] = newIssueTime()
+	claims["exp"] = time.Now().Add(app.Lifetimes.Access).Unix()
+
+	return app.Keys.Sign(claims)
+}
+
+// generateIDToken creates the OpenID Connect id token for a client.
+func (app *application) generateIDToken(user *data.User, clientID, nonce string, scopes []string) (string, error) {
+	now := time.Now()
+
+	claims := jwt.MapClaims{}
+	claims["iss"] = app.BaseURL
+	claims["sub"] = fmt.Sprint(user.ID)
+	claims["aud"] = clientID
+	claims["iat"] = now.Unix()
+	claims["auth_time"] = now.Unix()
+	claims["exp"] = now.Add(app.Lifetimes.Access).Unix()
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if containsString(scopes, "profile") {
+		claims["name"] = fmt.Sprintf("%s %s", user.FirstName, user.LastName)
+		claims["given_name"] = user.FirstName
+		claims["family_name"] = user.LastName
+	}
+	if containsString(scopes, "email") {
+		claims["email"] = user.Email
+	}
+
+	return app.Keys.Sign(claims)
+}
+
+// oauthError writes an error response in the format defined by RFC 6749.
+func (app *application) oauthError(w http.ResponseWriter, status int, code, description string) {
+	var payload = struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{
+		Error:            code,
+		ErrorDescription: description,
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	_ = app.writeJSON(w, status, payload)
+}
+
+// verifyCodeChallenge checks a PKCE code verifier against the S256 challenge sent with the authorization request.
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if challenge == "" || verifier == "" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// hashCode returns the hash under which an authorization code is stored.
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
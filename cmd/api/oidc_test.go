@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const testRedirectURI = "http://localhost:3000/callback"
+
+func setupTestOAuthClient() {
+	_ = app.DB.InsertOAuthClient(data.OAuthClient{
+		ID:           "test-client",
+		Name:         "Test Client",
+		RedirectURIs: []string{testRedirectURI},
+	})
+}
+
+func codeChallengeFor(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func Test_app_openIDConfiguration(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/.well-known/openid-configuration", nil)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(app.openIDConfiguration)
+	handler.ServeHTTP(rr, req)
+
+	var doc map[string]interface{}
+	_ = json.NewDecoder(rr.Body).Decode(&doc)
+
+	if doc["issuer"] != app.BaseURL {
+		t.Errorf("expected issuer %s, but got %v", app.BaseURL, doc["issuer"])
+	}
+
+	if doc["jwks_uri"] != app.BaseURL+"/.well-known/jwks.json" {
+		t.Errorf("unexpected jwks_uri %v", doc["jwks_uri"])
+	}
+}
+
+func Test_app_authorize(t *testing.T) {
+	setupTestOAuthClient()
+
+	valid := url.Values{
+		"client_id":             {"test-client"},
+		"redirect_uri":          {testRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid email"},
+		"state":                 {"xyz"},
+		"code_challenge":        {codeChallengeFor("verifier")},
+		"code_challenge_method": {"S256"},
+	}
+
+	with := func(key, value string) url.Values {
+		v := url.Values{}
+		for k, vs := range valid {
+			v[k] = vs
+		}
+		v.Set(key, value)
+		return v
+	}
+
+	tests := []struct {
+		name               string
+		query              url.Values
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{"valid", valid, http.StatusOK, ""},
+		{"unknown client", with("client_id", "nope"), http.StatusBadRequest, ""},
+		{"unregistered redirect", with("redirect_uri", "http://evil.com/callback"), http.StatusBadRequest, ""},
+		{"no pkce", with("code_challenge", ""), http.StatusFound, "invalid_request"},
+		{"plain pkce", with("code_challenge_method", "plain"), http.StatusFound, "invalid_request"},
+		{"no openid scope", with("scope", "email"), http.StatusFound, "invalid_scope"},
+		{"wrong response type", with("response_type", "token"), http.StatusFound, "unsupported_response_type"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/authorize?"+e.query.Encode(), nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.authorize)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedError != "" {
+			loc, _ := url.Parse(rr.Header().Get("Location"))
+			if loc.Query().Get("error") != e.expectedError || loc.Query().Get("state") != "xyz" {
+				t.Errorf("%s: expected redirect with error %s, but got %s", e.name, e.expectedError, loc)
+			}
+		}
+
+		if e.name == "valid" {
+			body, _ := io.ReadAll(rr.Body)
+			if !strings.Contains(string(body), "Test Client") {
+				t.Errorf("%s: expected consent page to name the client", e.name)
+			}
+		}
+	}
+}
+
+func Test_app_authorizeLogin(t *testing.T) {
+	setupTestOAuthClient()
+
+	form := url.Values{
+		"client_id":             {"test-client"},
+		"redirect_uri":          {testRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {"openid"},
+		"state":                 {"xyz"},
+		"code_challenge":        {codeChallengeFor("verifier")},
+		"code_challenge_method": {"S256"},
+		"email":                 {"admin@example.com"},
+		"password":              {"secret"},
+		"consent":               {"allow"},
+	}
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/authorize", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.authorizeLogin)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := post(form)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("valid login: expected code %v, but got %v", http.StatusFound, rr.Code)
+	}
+
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	if loc.Query().Get("code") == "" || loc.Query().Get("state") != "xyz" {
+		t.Errorf("valid login: expected redirect with code and state, but got %s", loc)
+	}
+
+	form.Set("consent", "deny")
+	rr = post(form)
+	loc, _ = url.Parse(rr.Header().Get("Location"))
+	if loc.Query().Get("error") != "access_denied" {
+		t.Errorf("denied consent: expected access_denied, but got %s", loc)
+	}
+
+	form.Set("consent", "allow")
+	form.Set("password", "wrong")
+	rr = post(form)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("wrong password: expected code %v, but got %v", http.StatusUnauthorized, rr.Code)
+	}
+}
+
+func Test_app_token(t *testing.T) {
+	setupTestOAuthClient()
+
+	issueCode := func(code string) {
+		_ = app.DB.InsertAuthorizationCode(data.AuthorizationCode{
+			CodeHash:            hashCode(code),
+			ClientID:            "test-client",
+			UserID:              1,
+			RedirectURI:         testRedirectURI,
+			Scope:               "openid email",
+			Nonce:               "n-0S6_WzA2Mj",
+			CodeChallenge:       codeChallengeFor("verifier"),
+			CodeChallengeMethod: "S256",
+			ExpiresAt:           time.Now().Add(time.Minute),
+		})
+	}
+
+	exchange := func(code, verifier string) *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {testRedirectURI},
+			"client_id":     {"test-client"},
+			"code_verifier": {verifier},
+		}
+		req, _ := http.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.token)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// a wrong verifier fails, and burns the code
+	issueCode("code-1")
+	if rr := exchange("code-1", "wrong"); rr.Code != http.StatusBadRequest {
+		t.Errorf("wrong verifier: expected code %v, but got %v", http.StatusBadRequest, rr.Code)
+	}
+	if rr := exchange("code-1", "verifier"); rr.Code != http.StatusBadRequest {
+		t.Errorf("reused code: expected code %v, but got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	issueCode("code-2")
+	rr := exchange("code-2", "verifier")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("valid exchange: expected code %v, but got %v", http.StatusOK, rr.Code)
+	}
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	_ = json.NewDecoder(rr.Body).Decode(&response)
+
+	idClaims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(response.IDToken, idClaims, app.Keys.Keyfunc)
+	if err != nil {
+		t.Fatalf("expected id token to verify, but got %s", err)
+	}
+
+	if idClaims["aud"] != "test-client" || idClaims["nonce"] != "n-0S6_WzA2Mj" || idClaims["email"] != "admin@example.com" {
+		t.Errorf("unexpected id token claims %v", idClaims)
+	}
+
+	// the access token works against userinfo, within the scopes granted
+	req, _ := http.NewRequest("GET", "/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+response.AccessToken)
+	rr = httptest.NewRecorder()
+
+	handler := app.oauthRequired(app.RequireScope("openid")(http.HandlerFunc(app.userInfo)))
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("userinfo: expected code %v, but got %v", http.StatusOK, rr.Code)
+	}
+
+	var info map[string]string
+	_ = json.NewDecoder(rr.Body).Decode(&info)
+	if info["sub"] != "1" || info["email"] != "admin@example.com" || info["name"] != "" {
+		t.Errorf("unexpected userinfo %v", info)
+	}
+
+	// but nowhere else: it carries no roles, and none of the scopes of the api
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for name, handler := range map[string]http.Handler{
+		"authRequired":   app.authRequired(next),
+		"bearerRequired": app.bearerRequired(next),
+	} {
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected the token of a client to be refused, but got %v", name, rr.Code)
+		}
+	}
+
+	claims := &Claims{ClientID: "test-client", Scopes: []string{"openid", "email"}}
+	if claims.HasScope(data.ScopeUsersWrite) || !claims.HasScope("email") {
+		t.Error("expected the claims of a client to be limited to its scopes")
+	}
+
+	// and tokens of the api don't work against userinfo
+	tokens, _ := app.issueTokenPairs(&data.User{ID: 1, FirstName: "Admin", LastName: "User"}, "test")
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	rr = httptest.NewRecorder()
+	app.oauthRequired(next).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("userinfo: expected an api token to be refused, but got %v", rr.Code)
+	}
+}
+
+func Test_verifyCodeChallenge(t *testing.T) {
+	// example from RFC 7636, appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	if !verifyCodeChallenge(challenge, verifier) {
+		t.Error("expected verifier to match challenge")
+	}
+
+	if verifyCodeChallenge(challenge, "wrong") {
+		t.Error("expected wrong verifier not to match")
+	}
+
+	if verifyCodeChallenge("", "") {
+		t.Error("expected empty challenge not to match")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// UserList is one page of GET /users.
+type UserList struct {
+	Users []*data.User `json:"users"`
+	Page  PageMeta     `json:"page"`
+}
+
+// PageMeta describes the page returned, and how to get the next one.
+type PageMeta struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// parseUserQuery reads the filters, sort order and page of GET /users from the query string.
+func parseUserQuery(r *http.Request) (repository.UserQuery, error) {
+	params := r.URL.Query()
+	q := repository.UserQuery{
+		EmailPrefix: params.Get("email"),
+		Sort:        params.Get("sort"),
+		Limit:       repository.DefaultUserLimit,
+	}
+
+	var err error
+
+	if v := params.Get("limit"); v != "" {
+		q.Limit, err = strconv.Atoi(v)
+		if err != nil {
+			return q, errors.New("limit must be a number")
+		}
+	}
+
+	if v := params.Get("offset"); v != "" {
+		q.Offset, err = strconv.Atoi(v)
+		if err != nil {
+			return q, errors.New("offset must be a number")
+		}
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		q.After, err = repository.ParseCursor(v)
+		if err != nil {
+			return q, err
+		}
+	}
+
+	if v := params.Get("admin"); v != "" {
+		isAdmin, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, errors.New("admin must be true or false")
+		}
+		q.IsAdmin = &isAdmin
+	}
+
+	if v := params.Get("deleted"); v != "" {
+		q.Deleted, err = strconv.ParseBool(v)
+		if err != nil {
+			return q, errors.New("deleted must be true or false")
+		}
+	}
+
+	q.CreatedAfter, err = parseDate(params.Get("created_after"))
+	if err != nil {
+		return q, fmt.Errorf("created_after: %w", err)
+	}
+
+	q.CreatedBefore, err = parseDate(params.Get("created_before"))
+	if err != nil {
+		return q, fmt.Errorf("created_before: %w", err)
+	}
+
+	return q, q.Validate()
+}
+
+// parseDate accepts RFC 3339 timestamps and plain dates. The empty string is the zero time.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.New("expected a date like 2006-01-02 or 2006-01-02T15:04:05Z")
+}
+
+// pageLinks returns the Link header (RFC 8288) for a page of users. Pages requested by cursor
+// only link to the first and next page; pages requested by offset link to all four.
+func (app *application) pageLinks(r *http.Request, q repository.UserQuery, page *repository.UserPage) string {
+	link := func(rel string, set func(params url.Values)) string {
+		params := r.URL.Query()
+		params.Del("cursor")
+		params.Del("offset")
+		set(params)
+		return fmt.Sprintf(`<%s%s?%s>; rel="%s"`, app.BaseURL, r.URL.Path, params.Encode(), rel)
+	}
+	offset := func(n int) func(url.Values) {
+		return func(params url.Values) {
+			if n > 0 {
+				params.Set("offset", strconv.Itoa(n))
+			}
+		}
+	}
+
+	links := []string{link("first", offset(0))}
+
+	if q.After != nil {
+		if page.Next != nil {
+			links = append(links, link("next", func(params url.Values) { params.Set("cursor", page.Next.Encode()) }))
+		}
+		return strings.Join(links, ", ")
+	}
+
+	if q.Offset > 0 {
+		prev := q.Offset - q.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link("prev", offset(prev)))
+	}
+	if page.Next != nil {
+		links = append(links, link("next", offset(q.Offset+q.Limit)))
+	}
+	if page.Total > 0 {
+		links = append(links, link("last", offset((page.Total-1)/q.Limit*q.Limit)))
+	}
+
+	return strings.Join(links, ", ")
+}
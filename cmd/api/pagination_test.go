@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_app_allUsers_paging(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	db := &dbrepo.TestDBRepo{}
+	db.AddUsers(
+		data.User{ID: 2, FirstName: "Bea", LastName: "Brown", Email: "bea@example.com", Roles: []string{data.RoleUser}, CreatedAt: time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)},
+		data.User{ID: 3, FirstName: "Cid", LastName: "Clark", Email: "cid@example.com", Roles: []string{data.RoleUser}, CreatedAt: time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)},
+		data.User{ID: 4, FirstName: "Dee", LastName: "Clark", Email: "dee@example.com", Roles: []string{data.RoleAdmin}, CreatedAt: time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)},
+		data.User{ID: 5, FirstName: "Eve", LastName: "Adams", Email: "eve@example.org", Roles: []string{data.RoleUser}, CreatedAt: time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)},
+		data.User{ID: 6, FirstName: "Fay", LastName: "Adams", Email: "fay@example.com", Roles: []string{data.RoleUser}, CreatedAt: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC), DeletedAt: time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)},
+	)
+	app.DB = db
+
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedIDs        string
+		expectedTotal      int
+		expectedLinks      []string
+	}{
+		{"default sort by last name", "", http.StatusOK, "5 2 3 4 1", 5, []string{`rel="first"`}},
+		{"first page", "limit=2", http.StatusOK, "5 2", 5, []string{`?limit=2>; rel="first"`, `limit=2&offset=2>; rel="next"`, `limit=2&offset=4>; rel="last"`}},
+		{"middle page", "limit=2&offset=2", http.StatusOK, "3 4", 5, []string{`?limit=2>; rel="prev"`, `limit=2&offset=4>; rel="next"`}},
+		{"descending", "sort=-created_at&limit=3", http.StatusOK, "5 4 3", 5, nil},
+		{"email prefix", "email=DE", http.StatusOK, "4", 1, nil},
+		{"admins", "admin=true&sort=id", http.StatusOK, "1 4", 2, nil},
+		{"non admins", "admin=false&sort=id", http.StatusOK, "2 3 5", 3, nil},
+		{"deleted", "deleted=true", http.StatusOK, "6", 1, nil},
+		{"created range", "created_after=2022-02-01&created_before=2022-04-01T00:00:00Z&sort=id", http.StatusOK, "2 3", 2, nil},
+		{"unknown sort", "sort=password", http.StatusBadRequest, "", 0, nil},
+		{"limit too large", "limit=1000", http.StatusBadRequest, "", 0, nil},
+		{"negative offset", "offset=-1", http.StatusBadRequest, "", 0, nil},
+		{"bad date", "created_after=yesterday", http.StatusBadRequest, "", 0, nil},
+		{"bad admin flag", "admin=maybe", http.StatusBadRequest, "", 0, nil},
+		{"bad deleted flag", "deleted=maybe", http.StatusBadRequest, "", 0, nil},
+		{"bad cursor", "cursor=nonsense", http.StatusBadRequest, "", 0, nil},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/users/?"+e.query, nil)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.allUsers).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var list UserList
+		_ = json.NewDecoder(rr.Body).Decode(&list)
+
+		if ids := userIDs(list.Users); ids != e.expectedIDs {
+			t.Errorf("%s: expected users %s, but got %s", e.name, e.expectedIDs, ids)
+		}
+		if list.Page.Total != e.expectedTotal {
+			t.Errorf("%s: expected total %d, but got %d", e.name, e.expectedTotal, list.Page.Total)
+		}
+		for _, link := range e.expectedLinks {
+			if !strings.Contains(rr.Header().Get("Link"), link) {
+				t.Errorf("%s: expected Link header to contain %s, got %s", e.name, link, rr.Header().Get("Link"))
+			}
+		}
+	}
+}
+
+func Test_app_allUsers_cursor(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+
+	db := &dbrepo.TestDBRepo{}
+	for i := 2; i <= 7; i++ {
+		// users share last names, so that the id has to break ties
+		db.AddUsers(data.User{ID: i, LastName: fmt.Sprintf("Name%d", i%3), Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+	app.DB = db
+
+	var seen []string
+	query := "limit=2&sort=-last_name"
+
+	for pages := 0; pages < 10; pages++ {
+		req, _ := http.NewRequest("GET", "/users/?"+query, nil)
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.allUsers).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected code %v, but got %v: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+
+		var list UserList
+		_ = json.NewDecoder(rr.Body).Decode(&list)
+		seen = append(seen, userIDs(list.Users))
+
+		if list.Page.NextCursor == "" {
+			if strings.Contains(rr.Header().Get("Link"), `rel="next"`) {
+				t.Error("expected no next link on the last page")
+			}
+			break
+		}
+		if !strings.Contains(rr.Header().Get("Link"), "cursor="+list.Page.NextCursor) && pages > 0 {
+			t.Errorf("expected next link to carry the cursor, got %s", rr.Header().Get("Link"))
+		}
+		query = "limit=2&sort=-last_name&cursor=" + list.Page.NextCursor
+	}
+
+	if all := strings.Join(seen, " "); all != "1 5 2 7 4 6 3" {
+		t.Errorf("expected to walk all users in order, got %s", all)
+	}
+
+	// a cursor is only valid for the sort order it was issued for
+	req, _ := http.NewRequest("GET", "/users/?limit=2&sort=-last_name", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(app.allUsers).ServeHTTP(rr, req)
+
+	var list UserList
+	_ = json.NewDecoder(rr.Body).Decode(&list)
+
+	req, _ = http.NewRequest("GET", "/users/?limit=2&sort=email&cursor="+list.Page.NextCursor, nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.allUsers).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected cursor of another sort order to be rejected, got %v", rr.Code)
+	}
+}
+
+func userIDs(users []*data.User) string {
+	var ids []string
+	for _, u := range users {
+		ids = append(ids, fmt.Sprint(u.ID))
+	}
+	return strings.Join(ids, " ")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/repository"
+	"webapp/pkg/signedtoken"
+	"webapp/pkg/validation"
+)
+
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// forgotPassword emails a password reset link to the user. It answers the same whether or not
+// the account exists, so that it can't be used to find out who has one.
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload forgotPasswordRequest
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(payload.Email)
+	if err == nil {
+		err = app.sendPasswordResetLink(user)
+		if err != nil {
+			log.Println("error sending password reset link:", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendPasswordResetLink signs a reset token bound to the current password hash, so that it stops
+// working once the password was changed, and mails it to the user.
+func (app *application) sendPasswordResetLink(user *data.User) error {
+	token := app.Tokens.Sign(signedtoken.PasswordReset, user.ID, time.Now().Add(app.Lifetimes.PasswordReset), user.Password)
+	link := app.PasswordResetURL + "?token=" + url.QueryEscape(token)
+
+	return app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nsomeone asked to reset the password of your account. If it was you, follow this link within %s:\n\n%s\n\nIf it wasn't, you can ignore this email.\n",
+			user.FirstName, app.Lifetimes.PasswordReset, link),
+	})
+}
+
+// resetPassword sets a new password with a token from a reset link, and ends every session of the user.
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload resetPasswordRequest
+
+	err := app.readJSON(w, r, &payload)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	userID, err := signedtoken.Subject(payload.Token)
+	if err != nil {
+		app.problemJSON(w, errors.New("invalid or expired token"), http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		app.problemJSON(w, errors.New("invalid or expired token"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.Tokens.Verify(payload.Token, signedtoken.PasswordReset, user.Password, time.Now())
+	if err != nil {
+		app.problemJSON(w, errors.New("invalid or expired token"), http.StatusBadRequest)
+		return
+	}
+
+	v := validation.New()
+	v.Password("password", payload.Password, validation.DefaultPasswordPolicy, personalValues(user.Email, user.FirstName, user.LastName)...)
+	if !v.Valid() {
+		app.problemJSON(w, v.Err())
+		return
+	}
+
+	err = app.DB.ResetPassword(user.ID, payload.Password)
+	if errors.Is(err, repository.ErrNotFound) {
+		// the user was deleted since the token was sent
+		app.problemJSON(w, errors.New("invalid or expired token"), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// whoever knew the old password must not stay logged in
+	err = app.Denylist.DenyUser(user.ID, time.Now())
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.RevokeUserRefreshTokens(user.ID)
+	if err != nil {
+		app.problemJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{
+		Actor:   fmt.Sprint(user.ID),
+		Action:  "password.reset",
+		Target:  fmt.Sprintf("user:%d", user.ID),
+		Changes: data.Changes{"password": {After: data.Redacted}},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
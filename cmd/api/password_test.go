@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/mailer"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func Test_app_passwordReset(t *testing.T) {
+	oldDB, oldDenylist, oldMailer := app.DB, app.Denylist, app.Mailer
+	defer func() { app.DB, app.Denylist, app.Mailer = oldDB, oldDenylist, oldMailer }()
+	app.DB = &dbrepo.TestDBRepo{}
+	app.Denylist = &dbrepo.MemoryDenylist{}
+	mails := &mailer.MemoryMailer{}
+	app.Mailer = mails
+
+	user, _ := app.DB.GetUser(1)
+	tokens, _ := app.issueTokenPairs(user, "test")
+
+	// unknown addresses get the same answer, but no mail
+	for _, email := range []string{"nobody@example.com", "admin@example.com"} {
+		req, _ := http.NewRequest("POST", "/password/forgot", strings.NewReader(fmt.Sprintf(`{"email":%q}`, email)))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.forgotPassword).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusAccepted {
+			t.Errorf("%s: expected code %v, but got %v", email, http.StatusAccepted, rr.Code)
+		}
+	}
+
+	sent := mails.Sent()
+	if len(sent) != 1 || sent[0].To != "admin@example.com" {
+		t.Fatalf("expected one mail to the admin, got %v", sent)
+	}
+
+	link := sent[0].Body[strings.Index(sent[0].Body, app.PasswordResetURL):]
+	link = strings.Fields(link)[0]
+	u, _ := url.Parse(link)
+	token := u.Query().Get("token")
+
+	var tests = []struct {
+		name               string
+		token              string
+		password           string
+		expectedStatusCode int
+	}{
+		{"short password", token, "short", http.StatusUnprocessableEntity},
+		{"bad token", "nonsense", "a new password", http.StatusBadRequest},
+		{"valid", token, "a new password", http.StatusNoContent},
+		{"token used", token, "another password", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		body := fmt.Sprintf(`{"token":%q,"password":%q}`, e.token, e.password)
+		req, _ := http.NewRequest("POST", "/password/reset", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.resetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected code %v, but got %v", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+
+	user, _ = app.DB.GetUser(1)
+	if ok, _ := user.PasswordMatches("a new password"); !ok {
+		t.Error("expected the password to be changed")
+	}
+
+	// sessions from before the reset are over
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	if _, _, err := app.getTokenFromHeaderAndVerify(httptest.NewRecorder(), req); err == nil {
+		t.Error("expected the access token to be revoked")
+	}
+
+	stored, _ := app.DB.GetRefreshToken(tokens.RefreshTokenID)
+	if stored.RevokedAt.IsZero() {
+		t.Error("expected the refresh token to be revoked")
+	}
+}
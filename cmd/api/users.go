@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/validation"
+)
+
+// createUserRequest is the payload of PUT /users/.
+type createUserRequest struct {
+	FirstName string   `json:"first_name" validate:"required,max=255"`
+	LastName  string   `json:"last_name" validate:"required,max=255"`
+	Email     string   `json:"email" validate:"required,email,max=255"`
+	Password  string   `json:"password" validate:"required"`
+	Roles     []string `json:"roles" validate:"oneof=admin user"` // the user role if empty
+}
+
+func (p *createUserRequest) validate() error {
+	v := validation.New()
+	v.Struct(p)
+	if p.Password != "" {
+		v.Password("password", p.Password, validation.DefaultPasswordPolicy, personalValues(p.Email, p.FirstName, p.LastName)...)
+	}
+	return v.Err()
+}
+
+// user returns the user to insert; the repository hashes the password.
+func (p *createUserRequest) user() data.User {
+	roles := p.Roles
+	if len(roles) == 0 {
+		roles = []string{data.RoleUser}
+	}
+
+	// admins vouch for the email addresses of the users they create
+	return data.User{
+		FirstName:  p.FirstName,
+		LastName:   p.LastName,
+		Email:      p.Email,
+		Password:   p.Password,
+		Roles:      roles,
+		VerifiedAt: time.Now(),
+	}
+}
+
+// updateUserRequest is the payload of PATCH /users/. The password is only changed if one is given,
+// which only admins may do, for other users.
+type updateUserRequest struct {
+	ID        int      `json:"id"`
+	FirstName string   `json:"first_name" validate:"required,max=255"`
+	LastName  string   `json:"last_name" validate:"required,max=255"`
+	Email     string   `json:"email" validate:"required,email,max=255"`
+	Password  string   `json:"password,omitempty"`
+	Roles     []string `json:"roles" validate:"oneof=admin user"`
+}
+
+func (p *updateUserRequest) validate() error {
+	v := validation.New()
+	v.Check(p.ID > 0, "id", "must be set")
+	v.Struct(p)
+	if p.Password != "" {
+		v.Password("password", p.Password, validation.DefaultPasswordPolicy, personalValues(p.Email, p.FirstName, p.LastName)...)
+	}
+	return v.Err()
+}
+
+// user returns the user to update.
+func (p *updateUserRequest) user() data.User {
+	return data.User{
+		ID:        p.ID,
+		FirstName: p.FirstName,
+		LastName:  p.LastName,
+		Email:     p.Email,
+		Roles:     p.Roles,
+	}
+}
+
+// personalValues returns what a password must not contain: the local part of the email
+// address and the names of the user.
+func personalValues(email string, names ...string) []string {
+	local, _, _ := strings.Cut(email, "@")
+	return append([]string{local}, names...)
+}
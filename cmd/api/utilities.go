@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"log"
 	"net/http"
+	"webapp/pkg/repository"
+	"webapp/pkg/validation"
 )
 
 func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, wrap ...string) error {
@@ -42,26 +45,80 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data interf
 	return nil
 }
 
-func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) {
-	statusCode := http.StatusBadRequest
+// Problem is an error response in the problem details format of RFC 7807.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+
+	// Errors lists the problems with each field of an invalid payload
+	Errors validation.Errors `json:"errors,omitempty"`
+}
+
+// problemJSON sends err as application/problem+json. Without an explicit status, the status is
+// derived from the repository error err matches, see errorStatus. The details of server errors
+// are logged instead of sent, so that no database internals leak to clients.
+func (app *application) problemJSON(w http.ResponseWriter, err error, status ...int) {
+	statusCode := errorStatus(err)
 	if len(status) > 0 {
 		statusCode = status[0]
 	}
 
-	type jsonError struct {
-		Message string `json:"message"`
+	problem := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(statusCode),
+		Status: statusCode,
+		Detail: err.Error(),
 	}
 
-	theError := jsonError{
-		Message: err.Error(),
+	var constraintErr *repository.ConstraintError
+	var fieldErrs validation.Errors
+	switch {
+	case statusCode >= http.StatusInternalServerError:
+		log.Printf("server error: %v", err)
+		problem.Detail = ""
+	case errors.As(err, &constraintErr):
+		// the name of the constraint is for the logs only
+		problem.Detail = constraintErr.Kind.Error()
+	case errors.As(err, &fieldErrs):
+		problem.Detail = "the request has invalid fields"
+		problem.Errors = fieldErrs
 	}
 
-	_ = app.writeJSON(w, statusCode, theError, "error")
+	out, err := json.Marshal(problem)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(out)
+}
+
+// errorStatus returns the HTTP status matching a validation error or an error of the repository;
+// 500 for any other error.
+func errorStatus(err error) int {
+	var fieldErrs validation.Errors
+	switch {
+	case errors.As(err, &fieldErrs):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrDuplicateEmail), errors.Is(err, repository.ErrConflict), errors.Is(err, repository.ErrRefreshTokenUsed):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrConstraint):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, repository.ErrStale):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
-	maxBytes := 1024 * 1024 // 1MiB
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	r.Body = http.MaxBytesReader(w, r.Body, app.MaxJSONBytes)
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"webapp/pkg/repository"
+	"webapp/pkg/validation"
+)
+
+func Test_app_problemJSON(t *testing.T) {
+	var tests = []struct {
+		name           string
+		err            error
+		status         []int
+		expectedStatus int
+		expectedDetail string
+	}{
+		{"explicit status", errors.New("name is required"), []int{http.StatusBadRequest}, http.StatusBadRequest, "name is required"},
+		{"not found", repository.ErrNotFound, nil, http.StatusNotFound, "record not found"},
+		{"duplicate email", &repository.ConstraintError{Kind: repository.ErrDuplicateEmail, Constraint: "users_email_key"}, nil, http.StatusConflict, "email address already in use"},
+		{"constraint", &repository.ConstraintError{Kind: repository.ErrConstraint, Constraint: "user_roles_role_id_fkey"}, nil, http.StatusUnprocessableEntity, "record violates a constraint"},
+		{"conflict", repository.ErrConflict, nil, http.StatusConflict, "record conflicts with an existing one"},
+		{"unknown error is hidden", errors.New(`pq: relation "users" does not exist`), nil, http.StatusInternalServerError, ""},
+		{"invalid fields", validation.Errors{"email": {"must be a valid email address"}}, nil, http.StatusUnprocessableEntity, "the request has invalid fields"},
+		{"explicit server error is hidden", errors.New("connection refused"), []int{http.StatusInternalServerError}, http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		app.problemJSON(rr, e.err, e.status...)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s: expected problem content type, but got %s", e.name, ct)
+		}
+
+		var problem Problem
+		err := json.NewDecoder(rr.Body).Decode(&problem)
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		if problem.Status != e.expectedStatus || problem.Title != http.StatusText(e.expectedStatus) || problem.Type != "about:blank" {
+			t.Errorf("%s: unexpected problem %+v", e.name, problem)
+		}
+		if problem.Detail != e.expectedDetail {
+			t.Errorf("%s: expected detail %q, but got %q", e.name, e.expectedDetail, problem.Detail)
+		}
+		if _, ok := e.err.(validation.Errors); ok && problem.Errors.Get("email") != "must be a valid email address" {
+			t.Errorf("%s: expected the field errors, but got %v", e.name, problem.Errors)
+		}
+		if strings.Contains(rr.Body.String(), "_key") || strings.Contains(rr.Body.String(), "relation") {
+			t.Errorf("%s: database internals leaked: %s", e.name, rr.Body.String())
+		}
+	}
+}
@@ -5,34 +5,81 @@ import (
 	"fmt"
 	"log"
 	"time"
+	"webapp/pkg/config"
+	"webapp/pkg/keyring"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
 type application struct {
-	JWTSecret string
-	Action    string
+	KeyDir string
+	Action string
+
+	// for import and export
+	DSN    string
+	File   string
+	Format string
+	DryRun bool
+
+	// for purge
+	UploadDir string
+	Retention time.Duration
 }
 
-// This is used to generate a token, so that we can test our api.
+// This is used to generate a token, so that we can test our api, and to import and export users.
 // Run this with go run ./cmd/cli and copy the token that is printed out.
+// The token is signed with the current key in -jwt-key-dir, which must be the same directory
+// the api was started with.
 // go run ./cmd/cli -action=valid     // will produce a valid token
 // go run ./cmd.cli -action=expired   // will produce an expired token
+// go run ./cmd/cli -action=import -file=users.csv -dry-run   // will check the users of a file
+// go run ./cmd/cli -action=import -file=users.ndjson         // will create the users of a file
+// go run ./cmd/cli -action=export -format=ndjson > users.ndjson
+// go run ./cmd/cli -action=purge -purge-retention=0s   // will remove every deleted user now
 func main() {
 	var app application
 
-	flag.StringVar(&app.JWTSecret, "jwt-secret", "eraser-secret", "secret")
-	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired")
+	flag.StringVar(&app.KeyDir, "jwt-key-dir", "./keys", "directory holding the api signing keys")
+	flag.StringVar(&app.Action, "action", "valid", "action: valid|expired|import|export|purge")
+	flag.StringVar(&app.DSN, "dsn", config.Defaults().DSN, "Postgres Connection, for import and export")
+	flag.StringVar(&app.File, "file", "-", "file to import from or export to; - for stdin or stdout")
+	flag.StringVar(&app.Format, "format", "", "csv|ndjson; by default the extension of -file, else csv")
+	flag.BoolVar(&app.DryRun, "dry-run", false, "only check the users to import")
+	flag.StringVar(&app.UploadDir, "upload-dir", config.Defaults().Uploads.Dir, "directory the web app stores uploaded images in, for purge")
+	flag.DurationVar(&app.Retention, "purge-retention", config.Defaults().Purge.Retention, "only purge users deleted longer ago than this")
 	flag.Parse()
 
-	// generate a token
-	token := jwt.New(jwt.SigningMethodHS256)
+	var err error
+	switch app.Action {
+	case "valid", "expired":
+		err = app.printToken()
+	case "import":
+		err = app.importUsers()
+	case "export":
+		err = app.exportUsers()
+	case "purge":
+		err = app.purgeUsers()
+	default:
+		err = fmt.Errorf("unknown action %q", app.Action)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// printToken prints a valid or an expired token of the admin user.
+func (app *application) printToken() error {
+	// load the signing keys
+	keys, err := keyring.Load(app.KeyDir, keyring.RS256, time.Hour*24)
+	if err != nil {
+		return err
+	}
 
 	// set claims
-	claims := token.Claims.(jwt.MapClaims)
+	claims := jwt.MapClaims{}
 	claims["name"] = "John Doe"
 	claims["sub"] = "1"
-	claims["admin"] = true
+	claims["roles"] = []string{"admin"}
 	claims["aud"] = "example.com"
 
 	// leave this to 3 days, for easy manual testing
@@ -50,11 +97,12 @@ func main() {
 	} else {
 		fmt.Println("EXPIRED Token:")
 	}
-	signedAccessToken, err := token.SignedString([]byte(app.JWTSecret))
+	signedAccessToken, err := keys.Sign(claims)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	// print to console
 	fmt.Println(string(signedAccessToken))
+	return nil
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"webapp/pkg/bulk"
+	"webapp/pkg/purge"
+	"webapp/pkg/repository/dbrepo"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// importUsers creates the users of -file, or only checks them with -dry-run. The report is
+// printed as JSON, like the api answers imports.
+func (app *application) importUsers() error {
+	format, err := app.format()
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if app.File != "-" {
+		in, err = os.Open(app.File)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	rows, err := bulk.Read(in, format)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(app.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := bulk.Import(&dbrepo.PostgresDBRepo{DB: db}, rows, app.DryRun)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(report)
+	if err != nil {
+		return err
+	}
+
+	if report.Invalid > 0 {
+		return fmt.Errorf("%d of %d users are invalid, none was imported", report.Invalid, report.Total)
+	}
+	return nil
+}
+
+// exportUsers writes every user to -file.
+func (app *application) exportUsers() error {
+	format, err := app.format()
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB(app.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var out io.Writer = os.Stdout
+	if app.File != "-" {
+		f, err := os.Create(app.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	return bulk.Export(out, format, &dbrepo.PostgresDBRepo{DB: db})
+}
+
+// purgeUsers removes the users deleted longer than -purge-retention ago, like the web app does
+// periodically, along with their images in -upload-dir.
+func (app *application) purgeUsers() error {
+	db, err := openDB(app.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	purger := &purge.Purger{Repo: &dbrepo.PostgresDBRepo{DB: db}, UploadDir: app.UploadDir, Retention: app.Retention}
+	removed, err := purger.Purge(time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("purged deleted users, removed %d images\n", removed)
+	return nil
+}
+
+// format returns the format of -format, or else of the extension of -file.
+func (app *application) format() (bulk.Format, error) {
+	name := app.Format
+	if name == "" {
+		name = strings.TrimPrefix(filepath.Ext(app.File), ".")
+	}
+	if name == "" {
+		return bulk.CSV, nil
+	}
+	return bulk.ParseFormat(name)
+}
+
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
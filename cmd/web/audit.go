@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"webapp/pkg/data"
+)
+
+// audit writes e to the audit log, as done by the user of the session from the IP address of the
+// request, unless e names another actor. Failures are logged only, so that they don't fail the action.
+func (app *application) audit(r *http.Request, e data.AuditEntry) {
+	if userID := app.sessionUserID(r.Context()); e.Actor == "" && userID != 0 {
+		e.Actor = fmt.Sprint(userID)
+	}
+	e.IP = app.ipFromContext(r.Context())
+
+	err := app.DB.InsertAuditEntry(e)
+	if err != nil {
+		log.Println("writing audit log:", err)
+	}
+}
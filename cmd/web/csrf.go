@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"mime"
+	"net/http"
+)
+
+// csrfField is the name of the form field, and csrfHeader the header, carrying the CSRF token
+const (
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// csrfToken returns the CSRF token of the session, creating one if the session has none yet.
+// The token lives as long as the session; logging in starts a new one.
+func (app *application) csrfToken(ctx context.Context) string {
+	if token := app.Session.GetString(ctx, csrfField); token != "" {
+		return token
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		log.Println("error creating csrf token:", err)
+		return ""
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	app.Session.Put(ctx, csrfField, token)
+	return token
+}
+
+// csrf rejects requests which may change state, unless they carry the CSRF token of the session,
+// in the csrf_token form field or the X-CSRF-Token header. It must run after the session is loaded.
+func (app *application) csrf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sent := r.Header.Get(csrfHeader)
+		if sent == "" {
+			// uploads are read here already, so they are limited here as well; the handler finds
+			// the parsed form on the request
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+				r.Body = http.MaxBytesReader(w, r.Body, app.Uploads.MaxBytes)
+				err := r.ParseMultipartForm(app.Uploads.MaxBytes)
+				if err != nil {
+					app.renderError(w, r, http.StatusBadRequest, "Upload failed", "The upload could not be read. Please check that the file isn't too large and try again.")
+					return
+				}
+			}
+			sent = r.PostFormValue(csrfField)
+		}
+
+		expected := app.Session.GetString(r.Context(), csrfField)
+		if expected == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(expected)) != 1 {
+			app.renderError(w, r, http.StatusForbidden, "This form has expired",
+				"For your security, forms can only be sent from the page they were shown on, while your session lasts. Please go back, reload the page and try again.")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
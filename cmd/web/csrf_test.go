@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestApp_csrf(t *testing.T) {
+	var reached bool
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true })
+
+	// upload returns a multipart body holding the token and a file of size bytes
+	upload := func(token string, size int) (string, *bytes.Buffer) {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		_ = mw.WriteField(csrfField, token)
+		part, _ := mw.CreateFormFile("image", "img.png")
+		_, _ = part.Write(bytes.Repeat([]byte{0}, size))
+		_ = mw.Close()
+		return mw.FormDataContentType(), body
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		form         url.Values // the token of the session replaces "valid"
+		header       string
+		multipart    int // size of the uploaded file, if >0
+		expectedCode int
+	}{
+		{"get", "GET", nil, "", 0, http.StatusOK},
+		{"no token", "POST", url.Values{"email": {"admin@example.com"}}, "", 0, http.StatusForbidden},
+		{"wrong token", "POST", url.Values{csrfField: {"guessed"}}, "", 0, http.StatusForbidden},
+		{"valid token", "POST", url.Values{csrfField: {"valid"}}, "", 0, http.StatusOK},
+		{"valid header", "POST", nil, "valid", 0, http.StatusOK},
+		{"upload", "POST", nil, "", 1024, http.StatusOK},
+		{"upload too big", "POST", nil, "", int(app.Uploads.MaxBytes) + 1, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		reached = false
+
+		req := httptest.NewRequest(e.method, "/", nil)
+		req = addContextAndSessionToRequest(req, app)
+		token := app.csrfToken(req.Context())
+
+		switch {
+		case e.multipart > 0:
+			contentType, body := upload(token, e.multipart)
+			req, _ = http.NewRequestWithContext(req.Context(), e.method, "/", body)
+			req.Header.Set("Content-Type", contentType)
+		case e.form != nil:
+			if e.form.Get(csrfField) == "valid" {
+				e.form.Set(csrfField, token)
+			}
+			req, _ = http.NewRequestWithContext(req.Context(), e.method, "/", strings.NewReader(e.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if e.header == "valid" {
+			req.Header.Set(csrfHeader, token)
+		}
+
+		rr := httptest.NewRecorder()
+		app.csrf(nextHandler).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected status code %d, but got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if reached != (e.expectedCode == http.StatusOK) {
+			t.Errorf("%s: expected the handler to be reached: %v", e.name, !reached)
+		}
+		if e.expectedCode == http.StatusForbidden && !strings.Contains(rr.Body.String(), "This form has expired") {
+			t.Errorf("%s: expected the error page", e.name)
+		}
+	}
+}
+
+func TestApp_csrfTokenInForms(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/", nil)
+	req = addContextAndSessionToRequest(req, app)
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.Home).ServeHTTP(rr, req)
+
+	token := app.csrfToken(req.Context())
+	if token == "" || !strings.Contains(rr.Body.String(), `name="csrf_token" value="`+token+`"`) {
+		t.Error("expected the login form to carry the csrf token of the session")
+	}
+
+	// logging in starts a new token
+	user, _ := app.DB.GetUser(1)
+	app.logIn(req, user)
+	if app.csrfToken(req.Context()) == token {
+		t.Error("expected a new csrf token after logging in")
+	}
+}
@@ -1,37 +1,25 @@
 package main
 
 import (
+	"fmt"
 	"net/url"
+	"regexp"
 	"strings"
+	"webapp/pkg/validation"
 )
 
-// convenience type to have functions tied to our map
-type errors map[string][]string
-
-func (e errors) Get(field string) string {
-	errorSlice := e[field]
-	if len(errorSlice) == 0 {
-		return ""
-	}
-	return errorSlice[0]
-}
-
-// Add adds an error message for a given form field
-func (e errors) Add(field, message string) {
-	e[field] = append(e[field], message)
-}
-
-// Form is a type used to instantiate form validation
+// Form is a type used to instantiate form validation. Pages get it back through TemplateData, to
+// show the errors next to their fields and fill in what the user entered.
 type Form struct {
 	Data   url.Values
-	Errors errors
+	Errors validation.Errors
 }
 
 // NewForm instantiates a form struct
 func NewForm(data url.Values) *Form {
 	return &Form{
 		Data:   data,
-		Errors: map[string][]string{},
+		Errors: validation.Errors{},
 	}
 }
 
@@ -44,13 +32,15 @@ func (f *Form) Has(field string) bool {
 	return true
 }
 
+// Get returns the value of a field
+func (f *Form) Get(field string) string {
+	return f.Data.Get(field)
+}
+
 // Required valiedates for required fields
 func (f *Form) Required(fields ...string) {
 	for _, field := range fields {
-		value := f.Data.Get(field)
-		if strings.TrimSpace(value) == "" {
-			f.Errors.Add(field, "This field cannot be blank")
-		}
+		f.Check(validation.NotBlank(f.Data.Get(field)), field, "This field cannot be blank")
 	}
 }
 
@@ -60,6 +50,51 @@ func (f *Form) Check(ok bool, key, message string) {
 	}
 }
 
+// Func checks the value of a field with a custom function. Like the other validators below, it
+// leaves blank fields alone, for Required to report.
+func (f *Form) Func(field string, valid func(value string) bool, message string) {
+	if value := f.Data.Get(field); validation.NotBlank(value) && !valid(value) {
+		f.Errors.Add(field, message)
+	}
+}
+
+// Email checks that a field holds an email address
+func (f *Form) Email(field string) {
+	f.Func(field, validation.IsEmail, "Please enter a valid email address")
+}
+
+// MinLength checks that a field has at least n characters
+func (f *Form) MinLength(field string, n int) {
+	f.Func(field, func(value string) bool { return validation.MinLength(value, n) }, fmt.Sprintf("This field must be at least %d characters long", n))
+}
+
+// MaxLength checks that a field has at most n characters
+func (f *Form) MaxLength(field string, n int) {
+	f.Func(field, func(value string) bool { return validation.MaxLength(value, n) }, fmt.Sprintf("This field must be at most %d characters long", n))
+}
+
+// Equal checks that a field holds the same as another one, like a password confirmation
+func (f *Form) Equal(field, other, message string) {
+	f.Func(field, func(value string) bool { return value == f.Data.Get(other) }, message)
+}
+
+// Matches checks that a field matches a regular expression
+func (f *Form) Matches(field string, pattern *regexp.Regexp, message string) {
+	f.Func(field, pattern.MatchString, message)
+}
+
+// OneOf checks that a field holds one of the given options
+func (f *Form) OneOf(field string, options ...string) {
+	f.Func(field, func(value string) bool { return validation.In(value, options...) }, "Please choose one of "+strings.Join(options, ", "))
+}
+
+// Password checks a password field against the password policy shared with the API
+func (f *Form) Password(field string, personal ...string) {
+	for _, problem := range validation.DefaultPasswordPolicy.Check(f.Data.Get(field), personal...) {
+		f.Errors.Add(field, "Password "+problem)
+	}
+}
+
 func (f *Form) Valid() bool {
 	return len(f.Errors) == 0
 }
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"regexp"
 	"testing"
 )
 
@@ -83,3 +84,70 @@ func TestForm_Get(t *testing.T) {
 		t.Error("Should not have an error, but got one.")
 	}
 }
+
+func TestForm_validators(t *testing.T) {
+	postedData := url.Values{
+		"email":    {"jane@example.com"},
+		"bad":      {"jane"},
+		"name":     {"Jane"},
+		"password": {"secret"},
+		"confirm":  {"secret"},
+		"other":    {"secrets"},
+		"color":    {"red"},
+		"zip":      {"12345"},
+		"blank":    {""},
+	}
+	zip := regexp.MustCompile(`^\d{5}$`)
+
+	tests := []struct {
+		name     string
+		validate func(f *Form)
+		valid    bool
+	}{
+		{"email", func(f *Form) { f.Email("email") }, true},
+		{"not an email", func(f *Form) { f.Email("bad") }, false},
+		{"min length", func(f *Form) { f.MinLength("name", 4) }, true},
+		{"too short", func(f *Form) { f.MinLength("name", 5) }, false},
+		{"max length", func(f *Form) { f.MaxLength("name", 4) }, true},
+		{"too long", func(f *Form) { f.MaxLength("name", 3) }, false},
+		{"equal", func(f *Form) { f.Equal("confirm", "password", "Passwords do not match") }, true},
+		{"not equal", func(f *Form) { f.Equal("other", "password", "Passwords do not match") }, false},
+		{"matches", func(f *Form) { f.Matches("zip", zip, "Please enter five digits") }, true},
+		{"does not match", func(f *Form) { f.Matches("name", zip, "Please enter five digits") }, false},
+		{"one of", func(f *Form) { f.OneOf("color", "red", "green") }, true},
+		{"none of", func(f *Form) { f.OneOf("color", "blue", "green") }, false},
+		{"func", func(f *Form) { f.Func("name", func(s string) bool { return s == "Jane" }, "Not Jane") }, true},
+		{"func fails", func(f *Form) { f.Func("name", func(s string) bool { return s == "John" }, "Not John") }, false},
+		{"blank is left to Required", func(f *Form) { f.Email("blank"); f.MinLength("blank", 3); f.OneOf("blank", "red") }, true},
+	}
+
+	for _, e := range tests {
+		form := NewForm(postedData)
+		e.validate(form)
+
+		if form.Valid() != e.valid {
+			t.Errorf("%s: expected valid to be %v, but got errors %v", e.name, e.valid, form.Errors)
+		}
+	}
+}
+
+func Test_templateFuncs(t *testing.T) {
+	form := NewForm(url.Values{"email": {"jane"}})
+	form.Email("email")
+
+	fieldError := templateFuncs["fieldError"].(func(*Form, string) string)
+	fieldValue := templateFuncs["fieldValue"].(func(*Form, string) string)
+	invalid := templateFuncs["invalid"].(func(*Form, string) bool)
+
+	if fieldError(form, "email") == "" || !invalid(form, "email") || fieldValue(form, "email") != "jane" {
+		t.Error("expected the error and the value of the field")
+	}
+	if fieldError(form, "name") != "" || invalid(form, "name") {
+		t.Error("expected no error for a valid field")
+	}
+
+	// pages which weren't posted have no form
+	if fieldError(nil, "email") != "" || fieldValue(nil, "email") != "" || invalid(nil, "email") {
+		t.Error("expected nothing for a missing form")
+	}
+}
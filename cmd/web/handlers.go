@@ -1,22 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"html/template"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"path"
-	"path/filepath"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/lockout"
+	"webapp/pkg/upload"
 )
 
-// package level variable
-var pathToTemplates = "./templates/"
-var uploadPath = "./static/img"
-
 // Home handler
 func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 	// template data to be passed to template
@@ -30,37 +24,58 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 		app.Session.Put(r.Context(), "test", "Hit this page at "+time.Now().UTC().String())
 	}
 
+	// set by Login for accounts which still have to confirm their email address
+	if email := app.Session.PopString(r.Context(), "unverified_email"); email != "" {
+		td["unverified_email"] = email
+	}
+
 	_ = app.render(w, r, "home.page.gohtml", &TemplateData{Data: td})
 }
 
 // Profile Handler
 func (app *application) Profile(w http.ResponseWriter, r *http.Request) {
 
-	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{})
+	var td = make(map[string]any)
+
+	user := app.Session.Get(r.Context(), "user").(data.User)
+	enabled, err := app.requiresMFA(user.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	td["totp_enabled"] = enabled
+
+	_ = app.render(w, r, "profile.page.gohtml", &TemplateData{Data: td})
 
 }
 
 type TemplateData struct {
-	IP    string
-	Data  map[string]any
-	Error string
-	Flash string
-	User  data.User
+	IP        string
+	Data      map[string]any
+	Error     string
+	Flash     string
+	User      data.User
+	CSRFToken string // for the csrf_token field of every form which is posted
+	Form      *Form  // the form which was posted, if it has to be corrected
 }
 
-// renderer
+// render shows a page with status 200
 func (app *application) render(w http.ResponseWriter, r *http.Request, t string, td *TemplateData) error {
+	return app.renderStatus(w, r, http.StatusOK, t, td)
+}
 
-	// parse the template from disk
-	parsedTemplate, err := template.ParseFiles(path.Join(pathToTemplates, t), path.Join(pathToTemplates, "base.layout.gohtml"))
-
+// renderStatus shows a page from the template cache. The page is rendered in full before anything
+// is sent, so that a template which fails halfway still gets a clean 500.
+func (app *application) renderStatus(w http.ResponseWriter, r *http.Request, status int, t string, td *TemplateData) error {
+	parsedTemplate, err := app.Templates.lookup(t)
 	if err != nil {
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return err
 	}
 
 	// retrieve user ip
 	td.IP = app.ipFromContext(r.Context())
+	td.CSRFToken = app.csrfToken(r.Context())
 
 	// retrieve data from session
 	td.Error = app.Session.PopString(r.Context(), "error")
@@ -70,12 +85,23 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, t string,
 	}
 
 	// execute the template, passing it data, if any
-	err = parsedTemplate.Execute(w, td)
+	buf := new(bytes.Buffer)
+	err = parsedTemplate.Execute(buf, td)
 	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return err
 	}
 
-	return nil
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// renderError shows the error page with the given status
+func (app *application) renderError(w http.ResponseWriter, r *http.Request, status int, title, message string) {
+	_ = app.renderStatus(w, r, status, "error.page.gohtml", &TemplateData{Data: map[string]any{"title": title, "message": message}})
 }
 
 func (app *application) Login(w http.ResponseWriter, r *http.Request) {
@@ -86,21 +112,34 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// validate login data
+	// validate login data; the form is shown again with what is wrong
 	form := NewForm(r.PostForm)
 	form.Required("email", "password")
+	form.Email("email")
 	if !form.Valid() {
-		// redirect to the login page with the error message
-		app.Session.Put(r.Context(), "error", "Invalid login credentials")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		_ = app.renderStatus(w, r, http.StatusUnprocessableEntity, "home.page.gohtml", &TemplateData{Form: form})
 		return
 	}
 
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
 
+	// don't even look at the password while the account or the client is locked out
+	ip := app.ipFromContext(r.Context())
+	keys := []string{lockout.AccountKey(email), lockout.IPKey(ip)}
+	wait, err := app.Lockout.Check(keys...)
+	if err != nil {
+		log.Println("error checking login throttle:", err)
+	}
+	if wait > 0 {
+		app.Session.Put(r.Context(), "error", fmt.Sprintf("Too many failed logins, try again in %s.", wait.Round(time.Second)))
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
 	user, err := app.DB.GetUserByEmail(email)
 	if err != nil {
+		app.loginFailed(ip, keys...)
 		app.Session.Put(r.Context(), "error", "Invalid login!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -111,13 +150,44 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	// authenticate the user
 	// if not authenticated, then redirect with error
 	if !app.authenticate(r, user, password) {
+		app.loginFailed(ip, keys...)
 		app.Session.Put(r.Context(), "error", "Invalid login!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	// prevent fixation attack
-	_ = app.Session.RenewToken(r.Context())
+	// new accounts have to confirm their email address first; knowing the password, the user may
+	// ask for another link
+	if !user.Verified() {
+		app.Session.Put(r.Context(), "error", "Please confirm your email address first, with the link we sent you.")
+		app.Session.Put(r.Context(), "unverified_email", user.Email)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// users with two-factor authentication still need to enter a code before they are logged in
+	mfa, err := app.requiresMFA(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if mfa {
+		_ = app.Session.RenewToken(r.Context())
+		app.Session.Put(r.Context(), "mfa_user_id", user.ID)
+		app.Session.Put(r.Context(), "mfa_started", time.Now().Unix())
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	// only the account is forgiven; the client keeps its failures
+	err = app.Lockout.Succeed(lockout.AccountKey(email))
+	if err != nil {
+		log.Println("error resetting login throttle:", err)
+	}
+
+	app.logIn(r, user)
+	app.audit(r, data.AuditEntry{Action: "login", Target: fmt.Sprintf("user:%d", user.ID), Details: "password"})
 
 	// store success message in session
 
@@ -127,19 +197,37 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// logIn puts the user into a fresh session, whose lifetime starts now
+func (app *application) logIn(r *http.Request, user *data.User) {
+	// prevent fixation attack; the anonymous session goes with everything in it, its csrf
+	// token and its deadline included
+	_ = app.Session.Destroy(r.Context())
+
+	now := time.Now()
+	app.Session.Put(r.Context(), "user", user)
+	app.Session.Put(r.Context(), "logged_in_at", now.UnixNano())
+	app.registerSession(r, user.ID, now)
+}
+
 func (app *application) authenticate(r *http.Request, user *data.User, password string) bool {
 	if valid, err := user.PasswordMatches(password); err != nil || !valid {
 		return false
 	}
 
-	app.Session.Put(r.Context(), "user", user)
 	return true
 }
 
-// TODO: file name might clash
+// loginFailed records a failed login for keys
+func (app *application) loginFailed(ip string, keys ...string) {
+	err := app.Lockout.Fail(ip, keys...)
+	if err != nil {
+		log.Println("error recording failed login:", err)
+	}
+}
+
 func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request) {
 	// call a function that extracts a file from an upload (request)
-	files, err := app.uploadFiles(r, uploadPath)
+	file, err := app.uploadFile(r, app.Uploads.Dir)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -151,7 +239,7 @@ func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request)
 	// create a variable of type data.UserImage
 	var i = data.UserImage{
 		UserID:   user.ID,
-		FileName: files[0].OriginalFileName,
+		FileName: file.FileName,
 	}
 
 	// insert the user image into user_images
@@ -160,6 +248,7 @@ func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	app.removeUnusedImage(user.ProfilePic.FileName)
 
 	// refresh the sessional variable "user"
 	updatedUser, err := app.DB.GetUser(user.ID)
@@ -169,56 +258,34 @@ func (app *application) UploadProfilePic(w http.ResponseWriter, r *http.Request)
 	}
 	app.Session.Put(r.Context(), "user", updatedUser)
 
+	app.audit(r, data.AuditEntry{
+		Action:  "user.update",
+		Target:  fmt.Sprintf("user:%d", user.ID),
+		Changes: data.Changes{"profile_pic": {Before: user.ProfilePic.FileName, After: i.FileName}},
+	})
+
 	// redirect back to profile page
 	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
 }
 
-type UploadedFile struct {
-	OriginalFileName string
-	FileSize         int64
-}
-
-func (app *application) uploadFiles(r *http.Request, uploadDir string) ([]*UploadedFile, error) {
-
-	var uploadedFiles []*UploadedFile
+// removeUnusedImage removes the image file name, which was replaced, unless some user still has it
+// as profile picture. The replacement went through either way, so failures are only logged.
+func (app *application) removeUnusedImage(name string) {
+	if name == "" {
+		return
+	}
 
-	err := r.ParseMultipartForm(int64(1024 * 1024 * 5)) // 5MiB
+	inUse, err := app.DB.UserImageInUse(name)
+	if err == nil && !inUse {
+		err = (&upload.Uploader{Dir: app.Uploads.Dir}).Remove(name)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("the uploaded file is too big, and must be less than %d bytes", 1024*1024*5)
-	}
-
-	for _, fHeaders := range r.MultipartForm.File {
-		for _, hdr := range fHeaders {
-			uploadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
-				var uploadedFile UploadedFile
-
-				infile, err := hdr.Open()
-				if err != nil {
-					return nil, err
-				}
-				defer infile.Close()
-				uploadedFile.OriginalFileName = hdr.Filename
-
-				var outfile *os.File
-				defer outfile.Close()
-				if outfile, err = os.Create(filepath.Join(uploadDir, uploadedFile.OriginalFileName)); err != nil {
-					return nil, err
-				} else {
-					fileSize, err := io.Copy(outfile, infile)
-					if err != nil {
-						return nil, err
-					}
-					uploadedFile.FileSize = fileSize
-				}
-
-				uploadedFiles = append(uploadedFiles, &uploadedFile)
-				return uploadedFiles, nil
-			}(uploadedFiles)
-			if err != nil {
-				return uploadedFiles, err
-			}
-		}
-	}
-
-	return uploadedFiles, nil
+		log.Printf("error removing replaced image %s: %v", name, err)
+	}
+}
+
+// uploadFile stores the image uploaded with r in uploadDir; r must hold no other file.
+func (app *application) uploadFile(r *http.Request, uploadDir string) (*upload.File, error) {
+	u := &upload.Uploader{Dir: uploadDir, MaxBytes: app.Uploads.MaxBytes, Types: upload.ImageTypes}
+	return u.SaveOne(r)
 }
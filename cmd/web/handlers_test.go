@@ -17,7 +17,11 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/lockout"
+	"webapp/pkg/repository/dbrepo"
 )
 
 func Test_application_handlers(t *testing.T) {
@@ -119,45 +123,112 @@ func TestAppHome(t *testing.T) {
 
 func TestApp_renderWithBadTemplate(t *testing.T) {
 
-	// set templatepath to a location with a bad template
-	pathToTemplates = "./testdata/"
+	// use a template set with a template which parses, but fails to execute
+	oldTemplates := app.Templates
+	defer func() { app.Templates = oldTemplates }()
+	templates, err := newTemplateSet(os.DirFS("./testdata"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Templates = templates
 
 	req, _ := http.NewRequest("GET", "/", nil)
 	req = addContextAndSessionToRequest(req, app)
 	res := httptest.NewRecorder()
 
-	err := app.render(res, req, "bad.page.gohtml", &TemplateData{})
+	err = app.render(res, req, "bad.page.gohtml", &TemplateData{})
 	t.Log(err)
 	if err == nil {
 		t.Error("Expected error from bad template, but did not get one.")
 	}
+	if res.Code != http.StatusInternalServerError || strings.Contains(res.Body.String(), "Home Page") {
+		t.Errorf("Expected a clean %d, but got %d", http.StatusInternalServerError, res.Code)
+	}
 
-	// restore bad template path
-	pathToTemplates = "./../../templates/"
-
+	// pages which don't exist are an error as well
+	res = httptest.NewRecorder()
+	err = app.render(res, req, "missing.page.gohtml", &TemplateData{})
+	if err == nil || res.Code != http.StatusInternalServerError {
+		t.Errorf("Expected an error and %d for a missing template, but got %v and %d", http.StatusInternalServerError, err, res.Code)
+	}
 }
 
 func TestApp_renderParseWithBadTemplate(t *testing.T) {
 
-	pathToTemplates = "./testdata/"
+	bad, _ := os.ReadFile("./testdata/bad.template.gohtml")
+	base, _ := os.ReadFile("./testdata/base.layout.gohtml")
+	fsys := fstest.MapFS{
+		"bad.page.gohtml":    {Data: bad},
+		"base.layout.gohtml": {Data: base},
+	}
+
+	// html/template finds the broken tag when it escapes the page, on the first execution
+	oldTemplates := app.Templates
+	defer func() { app.Templates = oldTemplates }()
+	templates, err := newTemplateSet(fsys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.Templates = templates
 
 	req, _ := http.NewRequest("GET", "/", nil)
 	req = addContextAndSessionToRequest(req, app)
 	res := httptest.NewRecorder()
 
-	err := app.render(res, req, "bad.template.gohtml", &TemplateData{})
+	err = app.render(res, req, "bad.page.gohtml", &TemplateData{})
 	t.Log(err)
+	if err == nil || res.Code != http.StatusInternalServerError {
+		t.Errorf("Expected error and %d when parsing bad template, but got %v and %d", http.StatusInternalServerError, err, res.Code)
+	}
+
+	// templates which don't parse at all are found at startup
+	fsys["broken.page.gohtml"] = &fstest.MapFile{Data: []byte(`{{define "content"}}`)}
+	_, err = newTemplateSet(fsys, false)
 	if err == nil {
 		t.Error("Expected error when parsing bad template, but did not get one.")
 	}
 }
 
+func TestApp_templateReload(t *testing.T) {
+	dir := t.TempDir()
+	base, _ := os.ReadFile("./testdata/base.layout.gohtml")
+	_ = os.WriteFile(path.Join(dir, "base.layout.gohtml"), base, 0644)
+	page := path.Join(dir, "hello.page.gohtml")
+	_ = os.WriteFile(page, []byte(`{{template "base" .}}{{define "content"}}Hello{{end}}`), 0644)
+
+	for _, reload := range []bool{false, true} {
+		templates, err := newTemplateSet(os.DirFS(dir), reload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_ = os.WriteFile(page, []byte(`{{template "base" .}}{{define "content"}}Goodbye{{end}}`), 0644)
+		// make sure the change is seen on file systems with coarse modification times
+		_ = os.Chtimes(page, time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+
+		ts, err := templates.lookup("hello.page.gohtml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		_ = ts.Execute(buf, &TemplateData{})
+
+		if expected := map[bool]string{false: "Hello", true: "Goodbye"}[reload]; !strings.Contains(buf.String(), expected) {
+			t.Errorf("reload %v: expected %q, but got %s", reload, expected, buf)
+		}
+
+		_ = os.WriteFile(page, []byte(`{{template "base" .}}{{define "content"}}Hello{{end}}`), 0644)
+		_ = os.Chtimes(page, time.Now(), time.Now())
+	}
+}
+
 func TestApp_login(t *testing.T) {
 	tests := []struct {
 		name               string
 		postedData         url.Values
 		expectedStatusCode int
 		expectedLoc        string
+		expectedHTML       string // of forms shown again to be corrected
 	}{
 		{
 			name: "valid login",
@@ -174,8 +245,17 @@ func TestApp_login(t *testing.T) {
 				"email":    {"admin@example.com"}, // you might have more than one thing(e.g. checkboxes)
 				"password": {""},
 			},
-			expectedStatusCode: http.StatusSeeOther,
-			expectedLoc:        "/",
+			expectedStatusCode: http.StatusUnprocessableEntity,
+			expectedHTML:       `value="admin@example.com"`,
+		},
+		{
+			name: "invalid email",
+			postedData: url.Values{
+				"email":    {"admin"},
+				"password": {"secret"},
+			},
+			expectedStatusCode: http.StatusUnprocessableEntity,
+			expectedHTML:       "Please enter a valid email address",
 		},
 		{
 			name: "user not found",
@@ -211,6 +291,13 @@ func TestApp_login(t *testing.T) {
 			t.Errorf("%s: returned wrong status code; expected: %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
 		}
 
+		if e.expectedHTML != "" {
+			if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+				t.Errorf("%s: expected %s in the form shown again", e.name, e.expectedHTML)
+			}
+			continue
+		}
+
 		actualLoc, err := rr.Result().Location()
 		if err == nil {
 			if actualLoc.String() != e.expectedLoc {
@@ -239,7 +326,7 @@ func addContextAndSessionToRequest(req *http.Request, app application) *http.Req
 
 }
 
-func TestApp_uploadFiles(t *testing.T) {
+func TestApp_uploadFile(t *testing.T) {
 	// set up pipes
 	pr, pw := io.Pipe()
 
@@ -257,25 +344,30 @@ func TestApp_uploadFiles(t *testing.T) {
 	request := httptest.NewRequest("POST", "/", pr)
 	request.Header.Add("Content-Type", writer.FormDataContentType())
 
-	// call app.UploadFiles
-	uploadedFiles, err := app.uploadFiles(request, "./testdata/uploads/")
+	// call app.uploadFile
+	uploadedFile, err := app.uploadFile(request, "./testdata/uploads/")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
-	// perform tests
-	if _, err := os.Stat(fmt.Sprintf("./testdata/uploads/%s", uploadedFiles[0].OriginalFileName)); os.IsNotExist(err) {
+	// perform tests; the file is stored under a name of its own
+	if uploadedFile.OriginalFileName != "img.png" || uploadedFile.FileName == uploadedFile.OriginalFileName {
+		t.Errorf("unexpected names %q and %q", uploadedFile.FileName, uploadedFile.OriginalFileName)
+	}
+	if _, err := os.Stat(fmt.Sprintf("./testdata/uploads/%s", uploadedFile.FileName)); os.IsNotExist(err) {
 		t.Errorf("expected file to exist: %s", err.Error())
 	}
 
 	// clean up
-	_ = os.Remove(fmt.Sprintf("./testdata/uploads/%s", uploadedFiles[0].OriginalFileName))
+	_ = os.Remove(fmt.Sprintf("./testdata/uploads/%s", uploadedFile.FileName))
 
 	wg.Wait()
 }
 
 func TestApp_UploadProfilePic(t *testing.T) {
-	uploadPath = "./testdata/uploads"
+	oldDir := app.Uploads.Dir
+	defer func() { app.Uploads.Dir = oldDir }()
+	app.Uploads.Dir = "./testdata/uploads"
 	fileName := "img.png"
 	filePath := fmt.Sprintf("./testdata/%s", fileName)
 
@@ -319,7 +411,17 @@ func TestApp_UploadProfilePic(t *testing.T) {
 		t.Errorf("wrong status code")
 	}
 
-	_ = os.Remove(fmt.Sprintf("%s/%s", uploadPath, fileName))
+	entries := app.DB.(*dbrepo.TestDBRepo).AuditLog()
+	if len(entries) == 0 {
+		t.Fatal("expected the upload to be audited")
+	}
+	last := entries[len(entries)-1]
+	stored, _ := last.Changes["profile_pic"].After.(string)
+	if last.Actor != "1" || last.Action != "user.update" || stored == "" || stored == fileName {
+		t.Errorf("unexpected audit entry %+v", last)
+	}
+
+	_ = os.Remove(fmt.Sprintf("%s/%s", app.Uploads.Dir, stored))
 }
 
 func simulatePNGUpload(fileToUpload string, writer *multipart.Writer, t *testing.T, wg *sync.WaitGroup) {
@@ -351,3 +453,44 @@ func simulatePNGUpload(fileToUpload string, writer *multipart.Writer, t *testing
 		t.Error(err)
 	}
 }
+
+func TestApp_loginLockout(t *testing.T) {
+	oldLockout := app.Lockout
+	defer func() { app.Lockout = oldLockout }()
+
+	app.Lockout = &lockout.Guard{
+		Repo: app.DB,
+		Policy: lockout.Policy{
+			BaseDelay:       time.Minute,
+			MaxDelay:        time.Minute,
+			LockoutAfter:    5,
+			LockoutDuration: time.Hour,
+			ResetAfter:      time.Hour,
+		},
+	}
+	defer func() { _ = app.Lockout.Succeed(lockout.AccountKey("admin@example.com"), lockout.IPKey("unknown")) }()
+
+	postedData := []url.Values{
+		{"email": {"admin@example.com"}, "password": {"wrong"}},
+		{"email": {"admin@example.com"}, "password": {"secret"}},
+	}
+	expectedErrors := []string{"Invalid login!", "Too many failed logins, try again in 1m0s."}
+
+	for i, values := range postedData {
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(values.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(app.Login)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("attempt %d: expected code %d, but got %d", i, http.StatusSeeOther, rr.Code)
+		}
+
+		if msg := app.Session.GetString(req.Context(), "error"); msg != expectedErrors[i] {
+			t.Errorf("attempt %d: expected error %q, but got %q", i, expectedErrors[i], msg)
+		}
+	}
+}
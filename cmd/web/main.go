@@ -5,9 +5,16 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"os"
+	"webapp/pkg/config"
 	"webapp/pkg/data"
+	"webapp/pkg/lockout"
+	"webapp/pkg/mailer"
+	"webapp/pkg/purge"
 	"webapp/pkg/repository"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/signedtoken"
+	"webapp/templates"
 
 	"github.com/alexedwards/scs/v2"
 )
@@ -16,6 +23,17 @@ type application struct {
 	DSN     string
 	DB      repository.DatabaseRepo
 	Session *scs.SessionManager
+	Lockout *lockout.Guard
+
+	// BaseURL is the public URL of the web app, used in links sent by email
+	BaseURL  string
+	Denylist repository.TokenDenylist
+	Mailer   mailer.Mailer
+	Tokens   *signedtoken.Signer
+
+	Lifetimes config.Lifetimes
+	Uploads   config.Uploads
+	Templates *templateSet
 }
 
 func main() {
@@ -25,9 +43,30 @@ func main() {
 	// set up an app config
 	app := application{}
 
-	// parse command line flag
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5", "Postgres Connection")
-	flag.Parse()
+	// load the configuration from defaults, file, environment and command line
+	cfg, err := config.Load(os.Args[0], os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app.DSN = cfg.DSN
+	app.BaseURL = cfg.Web.BaseURL
+	app.Lifetimes = cfg.Lifetimes
+	app.Uploads = cfg.Uploads
+
+	// the embedded templates, unless they are being worked on
+	if cfg.TemplateDir != "" {
+		log.Println("reading templates from", cfg.TemplateDir, "and reloading them when they change")
+		app.Templates, err = newTemplateSet(os.DirFS(cfg.TemplateDir), true)
+	} else {
+		app.Templates, err = newTemplateSet(templates.FS, false)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// connect to db
 	conn, err := app.connectToDB()
@@ -36,18 +75,46 @@ func main() {
 	}
 	defer conn.Close()
 	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
+	app.Lockout = &lockout.Guard{Repo: app.DB, Policy: lockout.DefaultPolicy()}
+
+	// sessions are ended through the same denylist the api revokes tokens with
+	app.Denylist = &dbrepo.PostgresDenylist{DB: conn}
+
+	app.Mailer, err = mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// without a shared secret, reset links only work with this very process
+	if cfg.TokenSecret == "" {
+		log.Println("no token secret configured, using a random one")
+		key, err := signedtoken.NewKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		app.Tokens = &signedtoken.Signer{Key: key}
+	} else {
+		app.Tokens = &signedtoken.Signer{Key: []byte(cfg.TokenSecret)}
+	}
+
+	// deleted users and their images are removed for good once they can't be restored any more
+	if cfg.Purge.Interval > 0 {
+		purger := &purge.Purger{Repo: app.DB, UploadDir: app.Uploads.Dir, Retention: cfg.Purge.Retention}
+		stop := purger.Start(cfg.Purge.Interval, func(err error) { log.Println("purging deleted users:", err) })
+		defer stop()
+	}
 
 	// get a session manager
-	app.Session = getSession()
+	app.Session = getSession(cfg.Session)
 
 	// get application routes
 	mux := app.routes()
 
 	// print out a message
-	log.Println("Starting server on port 8080...")
+	log.Printf("Starting server on port %d (%s profile)...\n", cfg.Web.Port, cfg.Profile)
 
 	// start the server
-	err = http.ListenAndServe(":8080", mux)
+	err = http.ListenAndServe(cfg.Web.Addr(), mux)
 	if err != nil {
 		log.Fatal(err)
 	}
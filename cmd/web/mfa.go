@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/lockout"
+	"webapp/pkg/repository"
+	"webapp/pkg/totp"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// totpIssuer is the name authenticator apps show next to our codes
+const totpIssuer = "webapp"
+
+const recoveryCodeCount = 10
+
+// mfaLoginTimeout is how long a user has to enter their code after the password
+var mfaLoginTimeout = 5 * time.Minute
+
+// requiresMFA reports whether the user has finished enrolling a second factor.
+func (app *application) requiresMFA(userID int) (bool, error) {
+	enrollment, err := app.DB.GetUserTOTP(userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return enrollment.IsConfirmed(), nil
+}
+
+// LoginMFA shows the second step of the login, asking for a TOTP or recovery code.
+func (app *application) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	if !app.Session.Exists(r.Context(), "mfa_user_id") {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	_ = app.render(w, r, "mfa.page.gohtml", &TemplateData{})
+}
+
+// PostLoginMFA checks the code of the second login step, and logs the user in.
+func (app *application) PostLoginMFA(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	userID := app.Session.GetInt(r.Context(), "mfa_user_id")
+	started := time.Unix(app.Session.GetInt64(r.Context(), "mfa_started"), 0)
+	if userID == 0 || time.Since(started) > mfaLoginTimeout {
+		app.Session.Remove(r.Context(), "mfa_user_id")
+		app.Session.Remove(r.Context(), "mfa_started")
+		app.Session.Put(r.Context(), "error", "Login expired, please log in again.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Invalid login!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// guessing codes counts against the same limits as guessing passwords
+	ip := app.ipFromContext(r.Context())
+	keys := []string{lockout.AccountKey(user.Email), lockout.IPKey(ip)}
+	wait, err := app.Lockout.Check(keys...)
+	if err != nil {
+		log.Println("error checking login throttle:", err)
+	}
+	if wait > 0 {
+		app.Session.Put(r.Context(), "error", fmt.Sprintf("Too many failed logins, try again in %s.", wait.Round(time.Second)))
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	enrollment, err := app.DB.GetUserTOTP(userID)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "Invalid login!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	ok, err := totp.Verify(app.DB, enrollment, r.Form.Get("code"), time.Now())
+	if err != nil || !ok {
+		app.loginFailed(ip, keys...)
+		app.Session.Put(r.Context(), "error", "Invalid authentication code!")
+		http.Redirect(w, r, "/login/mfa", http.StatusSeeOther)
+		return
+	}
+
+	err = app.Lockout.Succeed(lockout.AccountKey(user.Email))
+	if err != nil {
+		log.Println("error resetting login throttle:", err)
+	}
+
+	app.Session.Remove(r.Context(), "mfa_user_id")
+	app.Session.Remove(r.Context(), "mfa_started")
+	app.logIn(r, user)
+	app.audit(r, data.AuditEntry{Action: "login", Target: fmt.Sprintf("user:%d", user.ID), Details: "password and second factor"})
+
+	app.Session.Put(r.Context(), "flash", "Successfully logged in!")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// TOTPSetup shows a QR code of a new TOTP secret for the user to scan with their authenticator app.
+func (app *application) TOTPSetup(w http.ResponseWriter, r *http.Request) {
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	enrollment, err := app.DB.GetUserTOTP(user.ID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if enrollment != nil && enrollment.IsConfirmed() {
+		app.Session.Put(r.Context(), "flash", "Two-factor authentication is already enabled.")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	// keep a pending secret, so that reloading the page doesn't invalidate a QR code already scanned
+	if enrollment == nil {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		enrollment = &data.UserTOTP{UserID: user.ID, Secret: secret}
+		err = app.DB.SaveUserTOTP(*enrollment)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	png, err := qrcode.Encode(totp.URL(totpIssuer, user.Email, enrollment.Secret), qrcode.Medium, 256)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var td = make(map[string]any)
+	td["secret"] = enrollment.Secret
+	td["qr_code"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+
+	_ = app.render(w, r, "totp-setup.page.gohtml", &TemplateData{Data: td})
+}
+
+// PostTOTPSetup confirms the enrollment with a first code, and shows the user's recovery codes, once.
+func (app *application) PostTOTPSetup(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	user := app.Session.Get(r.Context(), "user").(data.User)
+
+	enrollment, err := app.DB.GetUserTOTP(user.ID)
+	if err != nil || enrollment.IsConfirmed() {
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+
+	step, ok := totp.Validate(enrollment.Secret, r.Form.Get("code"), time.Now())
+	if !ok {
+		app.Session.Put(r.Context(), "error", "Invalid authentication code, please try again.")
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+
+	codes, err := totp.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = totp.HashRecoveryCode(code)
+	}
+
+	err = app.DB.ConfirmUserTOTP(user.ID, hashes)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// the code used for the enrollment can't be used to log in
+	_, _ = app.DB.UseTOTPStep(user.ID, step)
+
+	app.audit(r, data.AuditEntry{Action: "mfa.enable", Target: fmt.Sprintf("user:%d", user.ID)})
+
+	var td = make(map[string]any)
+	td["recovery_codes"] = codes
+
+	app.Session.Put(r.Context(), "flash", "Two-factor authentication is enabled.")
+	_ = app.render(w, r, "recovery-codes.page.gohtml", &TemplateData{Data: td})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/lockout"
+	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/totp"
+)
+
+func TestApp_loginMFA(t *testing.T) {
+	oldDB, oldLockout := app.DB, app.Lockout
+	defer func() { app.DB, app.Lockout = oldDB, oldLockout }()
+	app.DB = &dbrepo.TestDBRepo{}
+	app.Lockout = &lockout.Guard{Repo: app.DB, Policy: lockout.DefaultPolicy()}
+
+	secret, _ := totp.GenerateSecret()
+	_ = app.DB.SaveUserTOTP(data.UserTOTP{UserID: 1, Secret: secret})
+	_ = app.DB.ConfirmUserTOTP(1, nil)
+
+	// the password alone leads to the second step, without logging the user in
+	postedData := url.Values{"email": {"admin@example.com"}, "password": {"secret"}}
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/login/mfa" {
+		t.Errorf("expected redirect to /login/mfa, but got %q", loc)
+	}
+	if app.Session.Exists(req.Context(), "user") {
+		t.Error("expected the user not to be logged in before the second factor")
+	}
+
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+
+	tests := []struct {
+		name        string
+		code        string
+		started     time.Time
+		expectedLoc string
+	}{
+		{"wrong code", "000000", time.Now(), "/login/mfa"},
+		{"expired", code, time.Now().Add(-time.Hour), "/"},
+		{"valid code", code, time.Now(), "/user/profile"},
+		{"replayed code", code, time.Now(), "/login/mfa"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"code": {e.code}}
+		req, _ := http.NewRequest("POST", "/login/mfa", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		app.Session.Put(req.Context(), "mfa_user_id", 1)
+		app.Session.Put(req.Context(), "mfa_started", e.started.Unix())
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.PostLoginMFA).ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLoc {
+			t.Errorf("%s: expected redirect to %s, but got %s", e.name, e.expectedLoc, loc)
+		}
+		if loggedIn := app.Session.Exists(req.Context(), "user"); loggedIn != (e.expectedLoc == "/user/profile") {
+			t.Errorf("%s: unexpected login state %t", e.name, loggedIn)
+		}
+	}
+}
+
+func TestApp_TOTPSetup(t *testing.T) {
+	oldDB := app.DB
+	defer func() { app.DB = oldDB }()
+	app.DB = &dbrepo.TestDBRepo{}
+
+	req, _ := http.NewRequest("GET", "/user/2fa", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1, Email: "admin@example.com"})
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.TOTPSetup).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "data:image/png;base64,") {
+		t.Fatalf("expected the setup page with a QR code, got %d", rr.Code)
+	}
+
+	enrollment, err := app.DB.GetUserTOTP(1)
+	if err != nil || enrollment.IsConfirmed() {
+		t.Fatalf("expected a pending enrollment, got %v %v", enrollment, err)
+	}
+
+	code, _ := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	postedData := url.Values{"code": {code}}
+	req, _ = http.NewRequest("POST", "/user/2fa", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	app.Session.Put(req.Context(), "user", data.User{ID: 1, Email: "admin@example.com"})
+	rr = httptest.NewRecorder()
+
+	http.HandlerFunc(app.PostTOTPSetup).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), "<code>") != recoveryCodeCount {
+		t.Errorf("expected the recovery codes to be shown, got %d", rr.Code)
+	}
+
+	if mfa, _ := app.requiresMFA(1); !mfa {
+		t.Error("expected the enrollment to be confirmed")
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"time"
 )
 
 type contextKey string
@@ -66,6 +68,32 @@ func (app *application) auth(next http.Handler) http.Handler {
 			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 			return
 		}
+
+		// sessions of users whose logins were all revoked since, e.g. by a password reset, are over
+		if app.sessionRevoked(r.Context()) {
+			_ = app.Session.Destroy(r.Context())
+			app.Session.Put(r.Context(), "error", "Your session has ended, please log in again.")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+
+		app.touchSession(r.Context(), time.Now())
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// sessionRevoked reports whether the session was revoked from the sessions page, or the logins of
+// the session's user were revoked after the session began
+func (app *application) sessionRevoked(ctx context.Context) bool {
+	userID := app.sessionUserID(ctx)
+	loggedInAt := time.Unix(0, app.Session.GetInt64(ctx, "logged_in_at"))
+
+	denied, err := app.Denylist.IsDenied(app.Session.GetString(ctx, "session_id"), userID, loggedInAt)
+	if err != nil {
+		log.Println("error checking session:", err)
+		return false
+	}
+
+	return denied
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/repository"
+	"webapp/pkg/signedtoken"
+)
+
+// ForgotPassword shows the form to ask for a password reset link
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "forgot-password.page.gohtml", &TemplateData{})
+}
+
+// PostForgotPassword emails a password reset link. It answers the same whether or not the account
+// exists, so that it can't be used to find out who has one.
+func (app *application) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("email")
+	form.Email("email")
+	if !form.Valid() {
+		_ = app.renderStatus(w, r, http.StatusUnprocessableEntity, "forgot-password.page.gohtml", &TemplateData{Form: form})
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Form.Get("email"))
+	if err == nil {
+		err = app.sendPasswordResetLink(user)
+		if err != nil {
+			log.Println("error sending password reset link:", err)
+		}
+	}
+
+	app.Session.Put(r.Context(), "flash", "If there is an account with this address, we've sent it a link to reset the password.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// sendPasswordResetLink signs a reset token bound to the current password hash, so that it stops
+// working once the password was changed, and mails it to the user.
+func (app *application) sendPasswordResetLink(user *data.User) error {
+	token := app.Tokens.Sign(signedtoken.PasswordReset, user.ID, time.Now().Add(app.Lifetimes.PasswordReset), user.Password)
+	link := app.BaseURL + "/reset-password?token=" + url.QueryEscape(token)
+
+	return app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nsomeone asked to reset the password of your account. If it was you, follow this link within %s:\n\n%s\n\nIf it wasn't, you can ignore this email.\n",
+			user.FirstName, app.Lifetimes.PasswordReset, link),
+	})
+}
+
+// ResetPassword shows the form to choose a new password, if the link is valid
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	if _, err := app.verifyPasswordResetToken(token); err != nil {
+		app.Session.Put(r.Context(), "error", "This password reset link is invalid or has expired.")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	var td = make(map[string]any)
+	td["token"] = token
+
+	_ = app.render(w, r, "reset-password.page.gohtml", &TemplateData{Data: td})
+}
+
+// PostResetPassword sets the new password, and ends every session of the user
+func (app *application) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	token := r.Form.Get("token")
+
+	user, err := app.verifyPasswordResetToken(token)
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "This password reset link is invalid or has expired.")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("password", "confirm_password")
+	form.Password("password", strings.Split(user.Email, "@")[0], user.FirstName, user.LastName)
+	form.Equal("confirm_password", "password", "Passwords do not match")
+	if !form.Valid() {
+		td := &TemplateData{Data: map[string]any{"token": token}, Form: form}
+		_ = app.renderStatus(w, r, http.StatusUnprocessableEntity, "reset-password.page.gohtml", td)
+		return
+	}
+
+	err = app.DB.ResetPassword(user.ID, r.Form.Get("password"))
+	if errors.Is(err, repository.ErrNotFound) {
+		// the user was deleted since the link was sent
+		app.Session.Put(r.Context(), "error", "This password reset link is invalid or has expired.")
+		http.Redirect(w, r, "/forgot-password", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// whoever knew the old password must not stay logged in, neither here nor in the api
+	err = app.Denylist.DenyUser(user.ID, time.Now())
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.RevokeUserRefreshTokens(user.ID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{
+		Actor:   fmt.Sprint(user.ID),
+		Action:  "password.reset",
+		Target:  fmt.Sprintf("user:%d", user.ID),
+		Changes: data.Changes{"password": {After: data.Redacted}},
+	})
+
+	_ = app.Session.RenewToken(r.Context())
+	app.Session.Remove(r.Context(), "user")
+	app.Session.Put(r.Context(), "flash", "Your password was changed, please log in.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// verifyPasswordResetToken returns the user a password reset token was issued to, if it is valid
+func (app *application) verifyPasswordResetToken(token string) (*data.User, error) {
+	userID, err := signedtoken.Subject(token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.Tokens.Verify(token, signedtoken.PasswordReset, user.Password, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
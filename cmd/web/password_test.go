@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func TestApp_passwordReset(t *testing.T) {
+	oldDB, oldDenylist, oldMailer := app.DB, app.Denylist, app.Mailer
+	defer func() { app.DB, app.Denylist, app.Mailer = oldDB, oldDenylist, oldMailer }()
+	app.DB = &dbrepo.TestDBRepo{}
+	app.Denylist = &dbrepo.MemoryDenylist{}
+	mails := &mailer.MemoryMailer{}
+	app.Mailer = mails
+
+	loggedInAt := time.Now()
+
+	postedData := url.Values{"email": {"admin@example.com"}}
+	req, _ := http.NewRequest("POST", "/forgot-password", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.PostForgotPassword).ServeHTTP(rr, req)
+
+	sent := mails.Sent()
+	if rr.Code != http.StatusSeeOther || len(sent) != 1 {
+		t.Fatalf("expected a redirect and a mail, got %d and %d mails", rr.Code, len(sent))
+	}
+
+	link := strings.Fields(sent[0].Body[strings.Index(sent[0].Body, app.BaseURL):])[0]
+	u, _ := url.Parse(link)
+	token := u.Query().Get("token")
+
+	// the link leads to the form
+	req, _ = http.NewRequest("GET", u.RequestURI(), nil)
+	req = addContextAndSessionToRequest(req, app)
+	rr = httptest.NewRecorder()
+
+	http.HandlerFunc(app.ResetPassword).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the reset form, got %d", rr.Code)
+	}
+
+	tests := []struct {
+		name            string
+		password        string
+		confirmPassword string
+		expectedLoc     string
+	}{
+		{"passwords differ", "a new password", "another password", ""},
+		{"too short", "short", "short", ""},
+		{"valid", "a new password", "a new password", "/"},
+		{"link used", "another password", "another password", "/forgot-password"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"token": {token}, "password": {e.password}, "confirm_password": {e.confirmPassword}}
+		req, _ := http.NewRequest("POST", "/reset-password", strings.NewReader(postedData.Encode()))
+		req = addContextAndSessionToRequest(req, app)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.PostResetPassword).ServeHTTP(rr, req)
+
+		// forms to correct are shown again, along with the link's token
+		if e.expectedLoc == "" && (rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), url.QueryEscape(token))) {
+			t.Errorf("%s: expected the form to be shown again, but got %d", e.name, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLoc {
+			t.Errorf("%s: expected redirect to %s, but got %s", e.name, e.expectedLoc, loc)
+		}
+	}
+
+	user, _ := app.DB.GetUser(1)
+	if ok, _ := user.PasswordMatches("a new password"); !ok {
+		t.Error("expected the password to be changed")
+	}
+
+	// a session from before the reset is over
+	req, _ = http.NewRequest("GET", "/user/profile", nil)
+	req = addContextAndSessionToRequest(req, app)
+	app.Session.Put(req.Context(), "user", data.User{ID: 1})
+	app.Session.Put(req.Context(), "logged_in_at", loggedInAt.UnixNano())
+	rr = httptest.NewRecorder()
+
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	app.auth(nextHandler).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("expected the old session to be rejected, got %d", rr.Code)
+	}
+}
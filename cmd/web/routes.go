@@ -14,15 +14,31 @@ func (app *application) routes() http.Handler {
 	mux.Use(middleware.Recoverer)
 	mux.Use(app.addIPToContext)      // custom middleware
 	mux.Use(app.Session.LoadAndSave) // persist session and load
+	mux.Use(app.csrf)                // reject forms posted from other sites
 
 	// register routes
 	mux.Get("/", app.Home)
 	mux.Post("/login", app.Login)
+	mux.Post("/logout", app.Logout)
+	mux.Get("/login/mfa", app.LoginMFA)
+	mux.Post("/login/mfa", app.PostLoginMFA)
+	mux.Get("/forgot-password", app.ForgotPassword)
+	mux.Post("/forgot-password", app.PostForgotPassword)
+	mux.Get("/reset-password", app.ResetPassword)
+	mux.Post("/reset-password", app.PostResetPassword)
+	mux.Get("/signup", app.Signup)
+	mux.Post("/signup", app.PostSignup)
+	mux.Get("/verify-email", app.VerifyEmail)
+	mux.Post("/verify-email/resend", app.PostResendVerification)
 
 	mux.Route("/user", func(mux chi.Router) {
 		mux.Use(app.auth)
 		mux.Get("/profile", app.Profile)
 		mux.Post("/upload-profile-pic", app.UploadProfilePic)
+		mux.Get("/2fa", app.TOTPSetup)
+		mux.Post("/2fa", app.PostTOTPSetup)
+		mux.Get("/sessions", app.Sessions)
+		mux.Post("/sessions/revoke", app.PostRevokeSession)
 	})
 
 	// static assets
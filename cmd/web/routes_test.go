@@ -16,7 +16,22 @@ func Test_application_routes(t *testing.T) {
 	}{
 		{"/", "GET"},
 		{"/login", "POST"},
+		{"/logout", "POST"},
+		{"/login/mfa", "GET"},
+		{"/login/mfa", "POST"},
+		{"/forgot-password", "GET"},
+		{"/forgot-password", "POST"},
+		{"/reset-password", "GET"},
+		{"/reset-password", "POST"},
+		{"/signup", "GET"},
+		{"/signup", "POST"},
+		{"/verify-email", "GET"},
+		{"/verify-email/resend", "POST"},
 		{"/user/profile", "GET"},
+		{"/user/2fa", "GET"},
+		{"/user/2fa", "POST"},
+		{"/user/sessions", "GET"},
+		{"/user/sessions/revoke", "POST"},
 		{"/static/*", "GET"},
 	}
 
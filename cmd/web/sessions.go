@@ -1,18 +1,274 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
 	"time"
+	"webapp/pkg/config"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
 
 	"github.com/alexedwards/scs/v2"
 )
 
-func getSession() *scs.SessionManager {
+// sessionTouchInterval is how often the last activity of a session is written to the database;
+// the session store itself keeps track of it on every request.
+const sessionTouchInterval = time.Minute
+
+func getSession(c config.Session) *scs.SessionManager {
 	session := scs.New()
-	session.Lifetime = 24 * time.Hour
+	session.Lifetime = c.Lifetime // counted from the login, which starts a fresh session
+	session.IdleTimeout = c.IdleTimeout
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
-	session.Cookie.Secure = true
+	session.Cookie.Secure = c.SecureCookie
 
 	return session
 }
+
+// sessionUserID returns the id of the user logged in to the session, or 0
+func (app *application) sessionUserID(ctx context.Context) int {
+	// the session holds a pointer until it was saved and loaded again
+	switch user := app.Session.Get(ctx, "user").(type) {
+	case data.User:
+		return user.ID
+	case *data.User:
+		return user.ID
+	}
+	return 0
+}
+
+// newSessionID returns a random id for the sessions page. It is not the session token, which
+// must never be shown.
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// registerSession records the session a user just logged in to, so that it shows up on the
+// sessions page and can be revoked from there. The login goes ahead even if this fails.
+func (app *application) registerSession(r *http.Request, userID int, now time.Time) {
+	id, err := newSessionID()
+	if err != nil {
+		log.Println("error creating session id:", err)
+		return
+	}
+
+	app.Session.Put(r.Context(), "session_id", id)
+	app.Session.Put(r.Context(), "last_seen_at", now.UnixNano())
+
+	err = app.DB.InsertWebSession(data.WebSession{
+		ID:         id,
+		UserID:     userID,
+		UserAgent:  r.UserAgent(),
+		IP:         app.ipFromContext(r.Context()),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(app.Session.Lifetime),
+	})
+	if err != nil {
+		log.Println("error registering session:", err)
+	}
+}
+
+// touchSession records the activity of the session, at most every sessionTouchInterval
+func (app *application) touchSession(ctx context.Context, now time.Time) {
+	id := app.Session.GetString(ctx, "session_id")
+	if id == "" {
+		return
+	}
+
+	lastSeenAt := time.Unix(0, app.Session.GetInt64(ctx, "last_seen_at"))
+	if now.Sub(lastSeenAt) < sessionTouchInterval {
+		return
+	}
+
+	app.Session.Put(ctx, "last_seen_at", now.UnixNano())
+	err := app.DB.TouchWebSession(id, now)
+	if err != nil {
+		log.Println("error recording session activity:", err)
+	}
+}
+
+// endSession forgets the session with the given id of a user, and revokes it through the denylist,
+// as it may be in use somewhere else. It returns repository.ErrNotFound for sessions which are not
+// the user's, without revoking them.
+func (app *application) endSession(userID int, id string) error {
+	err := app.DB.DeleteWebSession(userID, id)
+	if err != nil {
+		return err
+	}
+
+	// no session lives longer than the session lifetime from now
+	return app.Denylist.DenyToken(id, time.Now().Add(app.Session.Lifetime))
+}
+
+// sessionView is a session as shown on the sessions page
+type sessionView struct {
+	ID         string
+	Device     string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	Current    bool
+}
+
+// Sessions lists the sessions of the user which are still alive, the current one first
+func (app *application) Sessions(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionUserID(r.Context())
+	current := app.Session.GetString(r.Context(), "session_id")
+
+	sessions, err := app.DB.ListWebSessions(userID)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	var views []sessionView
+	for _, s := range sessions {
+		// sessions which timed out, or were ended along with all others of the user, are gone
+		if !s.IsActive(now, app.Session.IdleTimeout) {
+			continue
+		}
+		if denied, err := app.Denylist.IsDenied(s.ID, userID, s.CreatedAt); err != nil || denied {
+			continue
+		}
+
+		view := sessionView{
+			ID:         s.ID,
+			Device:     describeDevice(s.UserAgent),
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			Current:    s.ID == current,
+		}
+		if view.Current {
+			views = append([]sessionView{view}, views...)
+		} else {
+			views = append(views, view)
+		}
+	}
+
+	_ = app.render(w, r, "sessions.page.gohtml", &TemplateData{Data: map[string]any{"sessions": views}})
+}
+
+// PostRevokeSession ends one of the sessions of the user. Revoking the current session logs out.
+func (app *application) PostRevokeSession(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	id := r.PostForm.Get("id")
+	if id == app.Session.GetString(r.Context(), "session_id") {
+		app.Logout(w, r)
+		return
+	}
+
+	userID := app.sessionUserID(r.Context())
+	err = app.endSession(userID, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		app.Session.Put(r.Context(), "error", "This session has already ended.")
+		http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	app.audit(r, data.AuditEntry{Action: "session.revoke", Target: fmt.Sprintf("user:%d", userID)})
+
+	app.Session.Put(r.Context(), "flash", "The session was ended.")
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
+// Logout ends the current session
+func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionUserID(r.Context())
+	if id := app.Session.GetString(r.Context(), "session_id"); userID != 0 && id != "" {
+		err := app.endSession(userID, id)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Println("error ending session:", err)
+		}
+	}
+	if userID != 0 {
+		app.audit(r, data.AuditEntry{Action: "logout", Target: fmt.Sprintf("user:%d", userID)})
+	}
+
+	err := app.Session.Destroy(r.Context())
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	app.Session.Put(r.Context(), "flash", "You have been logged out.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// describeDevice names the browser and operating system of a user agent, like "Firefox on
+// Windows", falling back to the user agent itself.
+func describeDevice(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	// the order matters: Edge claims to be Chrome, which claims to be Safari
+	browsers := []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	systems := []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+
+	find := func(candidates []struct{ token, name string }) string {
+		for _, c := range candidates {
+			if strings.Contains(userAgent, c.token) {
+				return c.name
+			}
+		}
+		return ""
+	}
+
+	browser, system := find(browsers), find(systems)
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+
+	if len(userAgent) > 60 {
+		return userAgent[:60] + "…"
+	}
+	return userAgent
+}
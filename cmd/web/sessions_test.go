@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+)
+
+// logInAs logs the admin in from a browser with the given user agent, and returns the request
+// holding the new session
+func logInAs(t *testing.T, userAgent string) *http.Request {
+	postedData := url.Values{"email": {"admin@example.com"}, "password": {"secret"}}
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.Login).ServeHTTP(rr, req)
+
+	if loc := rr.Header().Get("Location"); loc != "/user/profile" {
+		t.Fatalf("expected to be logged in, but got redirected to %s", loc)
+	}
+
+	// the session holds a value once it was saved and loaded again
+	user := app.Session.Get(req.Context(), "user").(*data.User)
+	app.Session.Put(req.Context(), "user", *user)
+
+	return req
+}
+
+func TestApp_sessions(t *testing.T) {
+	oldDB, oldDenylist := app.DB, app.Denylist
+	defer func() { app.DB, app.Denylist = oldDB, oldDenylist }()
+	db := &dbrepo.TestDBRepo{}
+	app.DB = db
+	app.Denylist = &dbrepo.MemoryDenylist{}
+
+	firefox := logInAs(t, "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:105.0) Gecko/20100101 Firefox/105.0")
+	phone := logInAs(t, "Mozilla/5.0 (Linux; Android 13) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Mobile Safari/537.36")
+
+	// a session which was left alone for longer than the idle timeout is over
+	now := time.Now()
+	_ = db.InsertWebSession(data.WebSession{ID: "idle", UserID: 1, UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) Version/16.0 Safari/605.1.15",
+		CreatedAt: now.Add(-2 * app.Session.IdleTimeout), LastSeenAt: now.Add(-2 * app.Session.IdleTimeout), ExpiresAt: now.Add(time.Hour)})
+
+	sessionsPage := func(req *http.Request) string {
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.Sessions).ServeHTTP(rr, req)
+		return rr.Body.String()
+	}
+
+	body := sessionsPage(firefox)
+	for _, expected := range []string{"Firefox on Windows", "Chrome on Android", "this session"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected the sessions page to show %q", expected)
+		}
+	}
+	if strings.Contains(body, "Safari on macOS") {
+		t.Error("expected the idle session not to be listed")
+	}
+
+	revoke := func(req *http.Request, id string) *httptest.ResponseRecorder {
+		postedData := url.Values{"id": {id}}
+		r, _ := http.NewRequest("POST", "/user/sessions/revoke", strings.NewReader(postedData.Encode()))
+		r = r.WithContext(req.Context())
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(app.PostRevokeSession).ServeHTTP(rr, r)
+		return rr
+	}
+
+	// the phone is logged out from the other browser
+	rr := revoke(firefox, app.Session.GetString(phone.Context(), "session_id"))
+	if loc := rr.Header().Get("Location"); loc != "/user/sessions" || app.Session.GetString(firefox.Context(), "flash") == "" {
+		t.Errorf("revoke: expected to be back at the sessions page, but got redirected to %s", loc)
+	}
+
+	rr = httptest.NewRecorder()
+	app.auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, phone)
+	if rr.Code != http.StatusTemporaryRedirect {
+		t.Errorf("revoke: expected the revoked session to be rejected, got %d", rr.Code)
+	}
+
+	if strings.Contains(sessionsPage(firefox), "Chrome on Android") {
+		t.Error("revoke: expected the revoked session not to be listed")
+	}
+
+	// sessions of others, or which are gone, can't be revoked
+	_ = db.InsertWebSession(data.WebSession{ID: "other", UserID: 2, CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)})
+	for _, id := range []string{"other", "unknown"} {
+		rr = revoke(firefox, id)
+		if app.Session.PopString(firefox.Context(), "error") == "" {
+			t.Errorf("revoke %s: expected an error", id)
+		}
+	}
+	if denied, _ := app.Denylist.IsDenied("other", 2, now); denied {
+		t.Error("revoke: expected the session of another user to be left alone")
+	}
+
+	// revoking the current session logs out
+	rr = revoke(firefox, app.Session.GetString(firefox.Context(), "session_id"))
+	if loc := rr.Header().Get("Location"); loc != "/" || app.Session.Exists(firefox.Context(), "user") {
+		t.Errorf("logout: expected to be logged out, but got redirected to %s", loc)
+	}
+
+	sessions, _ := db.ListWebSessions(1)
+	for _, s := range sessions {
+		if s.ID != "idle" {
+			t.Errorf("logout: expected no sessions but the idle one, got %+v", s)
+		}
+	}
+}
+
+func TestApp_loginResetsDeadline(t *testing.T) {
+	// an anonymous session, like the one the login form was shown in, created long ago
+	token := "anonymous-session"
+	b, _ := app.Session.Codec.Encode(time.Now().Add(time.Minute), map[string]interface{}{"test": "value"})
+	_ = app.Session.Store.Commit(token, b, time.Now().Add(time.Minute))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Session", token)
+	req = addContextAndSessionToRequest(req, app)
+	if app.Session.GetString(req.Context(), "test") != "value" {
+		t.Fatal("expected the anonymous session to be loaded")
+	}
+
+	user, _ := app.DB.GetUser(1)
+	before := time.Now()
+	app.logIn(req, user)
+
+	deadline := app.Session.Deadline(req.Context())
+	if deadline.Before(before.Add(app.Session.Lifetime)) {
+		t.Errorf("expected the session to last %s from the login, but it ends at %s", app.Session.Lifetime, deadline)
+	}
+	if app.Session.Exists(req.Context(), "test") {
+		t.Error("expected the values of the anonymous session to be gone")
+	}
+	if _, found, _ := app.Session.Store.Find(token); found {
+		t.Error("expected the anonymous session to be removed from the store")
+	}
+}
+
+func TestApp_logout(t *testing.T) {
+	req := logInAs(t, "curl/7.85.0")
+
+	r, _ := http.NewRequest("POST", "/logout", nil)
+	r = r.WithContext(req.Context())
+	rr := httptest.NewRecorder()
+
+	http.HandlerFunc(app.Logout).ServeHTTP(rr, r)
+
+	if loc := rr.Header().Get("Location"); loc != "/" {
+		t.Errorf("expected redirect to /, but got %s", loc)
+	}
+	if app.Session.Exists(req.Context(), "user") || app.Session.GetString(req.Context(), "flash") == "" {
+		t.Error("expected the session to be destroyed, with a note")
+	}
+}
+
+func Test_describeDevice(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		expected  string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Safari/537.36 Edg/106.0.1370.47", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 16_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.0 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:105.0) Gecko/20100101 Firefox/105.0", "Firefox on Linux"},
+		{"curl/7.85.0", "curl"},
+		{"", "Unknown device"},
+		{"SomeBot", "SomeBot"},
+	}
+
+	for _, e := range tests {
+		if got := describeDevice(e.userAgent); got != e.expected {
+			t.Errorf("%q: expected %q, but got %q", e.userAgent, e.expected, got)
+		}
+	}
+}
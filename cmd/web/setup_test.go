@@ -1,9 +1,15 @@
 package main
 
 import (
+	"log"
 	"os"
 	"testing"
+	"webapp/pkg/config"
+	"webapp/pkg/lockout"
+	"webapp/pkg/mailer"
 	"webapp/pkg/repository/dbrepo"
+	"webapp/pkg/signedtoken"
+	"webapp/templates"
 )
 
 // all the references of app
@@ -11,10 +17,23 @@ var app application
 
 func TestMain(m *testing.M) {
 
-	pathToTemplates = "./../../templates/"
-	app.Session = getSession()
+	defaults := config.Defaults()
+	app.Session = getSession(defaults.Session)
+	app.Lifetimes = defaults.Lifetimes
+	app.Uploads = defaults.Uploads
 
 	app.DB = &dbrepo.TestDBRepo{}
+	app.Lockout = &lockout.Guard{Repo: app.DB, Policy: lockout.DefaultPolicy()}
+	app.BaseURL = "http://localhost:8080"
+	app.Denylist = &dbrepo.MemoryDenylist{}
+	app.Mailer = &mailer.MemoryMailer{}
+	app.Tokens = &signedtoken.Signer{Key: []byte("test-secret")}
+
+	var err error
+	app.Templates, err = newTemplateSet(templates.FS, false)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	os.Exit(m.Run())
 
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/repository"
+	"webapp/pkg/signedtoken"
+)
+
+// Signup shows the form to create an account
+func (app *application) Signup(w http.ResponseWriter, r *http.Request) {
+	_ = app.render(w, r, "signup.page.gohtml", &TemplateData{})
+}
+
+// PostSignup creates an account, which can't be logged in to until the link sent to its email
+// address was followed; the repository hashes the password. Taken addresses get the same answer,
+// and a note to their owner instead of a link, so that the form can't be used to find out who has
+// an account.
+func (app *application) PostSignup(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("first_name", "last_name", "email", "password", "confirm_password")
+	for _, field := range []string{"first_name", "last_name", "email"} {
+		form.MaxLength(field, 255)
+	}
+	form.Email("email")
+	form.Password("password", strings.Split(form.Get("email"), "@")[0], form.Get("first_name"), form.Get("last_name"))
+	form.Equal("confirm_password", "password", "Passwords do not match")
+	if !form.Valid() {
+		_ = app.renderStatus(w, r, http.StatusUnprocessableEntity, "signup.page.gohtml", &TemplateData{Form: form})
+		return
+	}
+
+	user := data.User{
+		FirstName: strings.TrimSpace(form.Data.Get("first_name")),
+		LastName:  strings.TrimSpace(form.Data.Get("last_name")),
+		Email:     strings.TrimSpace(form.Data.Get("email")),
+		Password:  form.Data.Get("password"),
+		Roles:     []string{data.RoleUser},
+	}
+
+	user.ID, err = app.DB.InsertUser(user)
+	switch {
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		existing, err := app.DB.GetUserByEmail(user.Email)
+		if err == nil {
+			err = app.sendAccountExists(existing)
+		}
+		if err != nil {
+			log.Println("error sending account exists note:", err)
+		}
+	case err != nil:
+		log.Println(err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	default:
+		app.audit(r, data.AuditEntry{
+			Actor:   fmt.Sprint(user.ID),
+			Action:  "user.signup",
+			Target:  fmt.Sprintf("user:%d", user.ID),
+			Changes: data.UserChanges(nil, &user),
+		})
+
+		err = app.sendVerificationLink(&user)
+		if err != nil {
+			log.Println("error sending verification link:", err)
+		}
+	}
+
+	app.Session.Put(r.Context(), "flash", "We've sent you an email. Follow the link in it to confirm your address, then log in.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// sendVerificationLink signs a token bound to the email address of the user, so that it can only
+// confirm the address it was sent to, and mails it to the user.
+func (app *application) sendVerificationLink(user *data.User) error {
+	token := app.Tokens.Sign(signedtoken.EmailVerification, user.ID, time.Now().Add(app.Lifetimes.EmailVerification), user.Email)
+	link := app.BaseURL + "/verify-email?token=" + url.QueryEscape(token)
+
+	return app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nplease confirm your email address by following this link within %s:\n\n%s\n\nIf you didn't sign up, you can ignore this email.\n",
+			user.FirstName, app.Lifetimes.EmailVerification, link),
+	})
+}
+
+// sendAccountExists tells the owner of an address that someone tried to sign up with it again
+func (app *application) sendAccountExists(user *data.User) error {
+	return app.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "You already have an account",
+		Body: fmt.Sprintf("Hello %s,\n\nsomeone tried to sign up with your email address, but you already have an account. If you forgot your password, you can reset it here:\n\n%s\n\nIf it wasn't you, you can ignore this email.\n",
+			user.FirstName, app.BaseURL+"/forgot-password"),
+	})
+}
+
+// VerifyEmail confirms the email address of an account with the link sent by PostSignup
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := app.verifyEmailToken(r.URL.Query().Get("token"))
+	if err != nil {
+		app.Session.Put(r.Context(), "error", "This confirmation link is invalid or has expired. Log in to get a new one.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if !user.Verified() {
+		err = app.DB.VerifyEmail(user.ID)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		app.audit(r, data.AuditEntry{Actor: fmt.Sprint(user.ID), Action: "user.verify_email", Target: fmt.Sprintf("user:%d", user.ID)})
+	}
+
+	app.Session.Put(r.Context(), "flash", "Your email address is confirmed, you can log in now.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// PostResendVerification sends a new confirmation link. Like PostForgotPassword it answers the
+// same whether or not there is such an account.
+func (app *application) PostResendVerification(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	form := NewForm(r.PostForm)
+	form.Required("email")
+	if !form.Valid() {
+		app.Session.Put(r.Context(), "error", "Please enter your email address")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	user, err := app.DB.GetUserByEmail(form.Data.Get("email"))
+	if err == nil && !user.Verified() {
+		err = app.sendVerificationLink(user)
+		if err != nil {
+			log.Println("error sending verification link:", err)
+		}
+	}
+
+	app.Session.Put(r.Context(), "flash", "If there is an unconfirmed account with this address, we've sent it a new link.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// verifyEmailToken returns the user an email verification token was issued to, if it is valid
+func (app *application) verifyEmailToken(token string) (*data.User, error) {
+	userID, err := signedtoken.Subject(token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.DB.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.Tokens.Verify(token, signedtoken.EmailVerification, user.Email, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
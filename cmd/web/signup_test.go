@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/mailer"
+	"webapp/pkg/repository/dbrepo"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// postForm posts data to handler and returns the response
+func postForm(handler http.HandlerFunc, target string, postedData url.Values) (*httptest.ResponseRecorder, *http.Request) {
+	req, _ := http.NewRequest("POST", target, strings.NewReader(postedData.Encode()))
+	req = addContextAndSessionToRequest(req, app)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+	return rr, req
+}
+
+func TestApp_signup(t *testing.T) {
+	oldDB, oldMailer := app.DB, app.Mailer
+	defer func() { app.DB, app.Mailer = oldDB, oldMailer }()
+	db := &dbrepo.TestDBRepo{}
+	app.DB = db
+	mails := &mailer.MemoryMailer{}
+	app.Mailer = mails
+
+	valid := url.Values{
+		"first_name":       {"Jane"},
+		"last_name":        {"Doe"},
+		"email":            {"jane@example.com"},
+		"password":         {"correct horse battery"},
+		"confirm_password": {"correct horse battery"},
+	}
+	with := func(field, value string) url.Values {
+		v := url.Values{}
+		for k, vs := range valid {
+			v[k] = vs
+		}
+		v.Set(field, value)
+		return v
+	}
+
+	tests := []struct {
+		name          string
+		postedData    url.Values
+		expectedLoc   string
+		expectedMails int
+		expectedMail  string
+	}{
+		{"missing name", with("first_name", ""), "", 0, ""},
+		{"invalid email", with("email", "jane"), "", 0, ""},
+		{"passwords differ", with("confirm_password", "another password"), "", 0, ""},
+		{"weak password", with("password", "jane"), "", 0, ""},
+		{"valid", valid, "/", 1, "Confirm your email address"},
+		{"taken email", with("email", "admin@example.com"), "/", 1, "You already have an account"},
+	}
+
+	for _, e := range tests {
+		before := len(mails.Sent())
+		rr, _ := postForm(app.PostSignup, "/signup", e.postedData)
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLoc {
+			t.Errorf("%s: expected redirect to %s, but got %s", e.name, e.expectedLoc, loc)
+		}
+		// invalid forms are shown again, filled in with what was entered
+		if e.expectedLoc == "" && (rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "invalid-feedback") || !strings.Contains(rr.Body.String(), `value="Doe"`)) {
+			t.Errorf("%s: expected the form to be shown again with its errors, but got %d", e.name, rr.Code)
+		}
+		sent := mails.Sent()[before:]
+		if len(sent) != e.expectedMails {
+			t.Errorf("%s: expected %d mails, but got %d", e.name, e.expectedMails, len(sent))
+			continue
+		}
+		if e.expectedMails > 0 && sent[0].Subject != e.expectedMail {
+			t.Errorf("%s: expected mail %q, but got %q", e.name, e.expectedMail, sent[0].Subject)
+		}
+	}
+
+	sent := mails.Sent()
+	body := sent[len(sent)-2].Body
+	link := strings.Fields(body[strings.Index(body, app.BaseURL):])[0]
+	u, _ := url.Parse(link)
+
+	// the test repository doesn't keep inserted users; add the one which signed up
+	hash, _ := bcrypt.GenerateFromPassword([]byte("correct horse battery"), bcrypt.MinCost)
+	db.AddUsers(data.User{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Password: string(hash), Roles: []string{data.RoleUser}})
+
+	login := url.Values{"email": {"jane@example.com"}, "password": {"correct horse battery"}}
+	rr, req := postForm(app.Login, "/login", login)
+	if loc := rr.Header().Get("Location"); loc != "/" || app.Session.GetString(req.Context(), "unverified_email") != "jane@example.com" {
+		t.Errorf("unverified: expected to be refused and offered a new link, but got redirected to %s", loc)
+	}
+
+	// only unconfirmed accounts get a new link
+	for email, expectedMails := range map[string]int{"jane@example.com": 1, "admin@example.com": 0, "nobody@example.com": 0} {
+		before := len(mails.Sent())
+		rr, _ := postForm(app.PostResendVerification, "/verify-email/resend", url.Values{"email": {email}})
+		if rr.Code != http.StatusSeeOther || len(mails.Sent())-before != expectedMails {
+			t.Errorf("resend to %s: expected a redirect and %d mails, but got %d and %d", email, expectedMails, rr.Code, len(mails.Sent())-before)
+		}
+	}
+
+	for _, token := range []string{"not a token", u.Query().Get("token")} {
+		req, _ := http.NewRequest("GET", "/verify-email?token="+url.QueryEscape(token), nil)
+		req = addContextAndSessionToRequest(req, app)
+		rr := httptest.NewRecorder()
+
+		http.HandlerFunc(app.VerifyEmail).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("verify: expected a redirect, but got %d", rr.Code)
+		}
+	}
+
+	user, _ := db.GetUser(2)
+	if !user.Verified() {
+		t.Fatal("expected the email address to be confirmed")
+	}
+
+	rr, _ = postForm(app.Login, "/login", login)
+	if loc := rr.Header().Get("Location"); loc != "/user/profile" {
+		t.Errorf("verified: expected redirect to /user/profile, but got %s", loc)
+	}
+}
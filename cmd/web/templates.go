@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+)
+
+// templateFuncs are the helpers of the templates. They take the form of TemplateData, which is
+// nil on pages that weren't posted, e.g. {{fieldError .Form "email"}}.
+var templateFuncs = template.FuncMap{
+	// fieldError returns the first error of a field, or the empty string
+	"fieldError": func(f *Form, field string) string {
+		if f == nil {
+			return ""
+		}
+		return f.Errors.Get(field)
+	},
+	// fieldValue returns what was entered into a field, to fill it in again
+	"fieldValue": func(f *Form, field string) string {
+		if f == nil {
+			return ""
+		}
+		return f.Get(field)
+	},
+	// invalid reports whether a field has errors, to mark it with Bootstrap's is-invalid class
+	"invalid": func(f *Form, field string) bool {
+		return f != nil && len(f.Errors[field]) > 0
+	},
+}
+
+// templateCache holds the pages of the web app by file name, each parsed along with all layouts
+// and partials.
+type templateCache map[string]*template.Template
+
+// newTemplateCache parses every *.page.gohtml of fsys. Any template which doesn't parse fails it.
+func newTemplateCache(fsys fs.FS) (templateCache, error) {
+	pages, err := fs.Glob(fsys, "*.page.gohtml")
+	if err != nil {
+		return nil, err
+	}
+
+	// ParseFS fails for patterns which match nothing, so only the ones which do are passed on
+	var shared []string
+	for _, pattern := range []string{"*.layout.gohtml", "*.partial.gohtml"} {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) > 0 {
+			shared = append(shared, pattern)
+		}
+	}
+
+	cache := templateCache{}
+	for _, page := range pages {
+		name := path.Base(page)
+
+		ts, err := template.New(name).Funcs(templateFuncs).ParseFS(fsys, page)
+		if err != nil {
+			return nil, err
+		}
+		if len(shared) > 0 {
+			ts, err = ts.ParseFS(fsys, shared...)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		cache[name] = ts
+	}
+
+	return cache, nil
+}
+
+// templateSet hands out the parsed pages. With reload, meant for development with templates read
+// from disk, the pages are parsed again whenever a file changed since they were last parsed.
+type templateSet struct {
+	fsys   fs.FS
+	reload bool
+
+	mu      sync.Mutex
+	cache   templateCache
+	version templateVersion // of the files the cache was built from
+}
+
+// templateVersion tells whether template files were changed, added or removed.
+type templateVersion struct {
+	modTime time.Time // of the newest file
+	files   int
+}
+
+// newTemplateSet parses the pages of fsys once, so that broken templates are found at startup.
+func newTemplateSet(fsys fs.FS, reload bool) (*templateSet, error) {
+	version, err := currentTemplateVersion(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	cache, err := newTemplateCache(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &templateSet{fsys: fsys, reload: reload, cache: cache, version: version}, nil
+}
+
+// lookup returns the page with the given file name
+func (s *templateSet) lookup(name string) (*template.Template, error) {
+	cache := s.cache
+	if s.reload {
+		var err error
+		cache, err = s.reloaded()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ts, ok := cache[name]
+	if !ok {
+		return nil, fmt.Errorf("template %s does not exist", name)
+	}
+
+	return ts, nil
+}
+
+// reloaded parses the pages again if a file changed. A failed attempt is repeated on the next
+// call, so that the page works again as soon as the template is fixed.
+func (s *templateSet) reloaded() (templateCache, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	version, err := currentTemplateVersion(s.fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	if version != s.version {
+		cache, err := newTemplateCache(s.fsys)
+		if err != nil {
+			return nil, err
+		}
+		s.cache, s.version = cache, version
+	}
+
+	return s.cache, nil
+}
+
+// currentTemplateVersion looks at the templates of fsys. Embedded files have no modification
+// time, which is fine as they never change.
+func currentTemplateVersion(fsys fs.FS) (templateVersion, error) {
+	matches, err := fs.Glob(fsys, "*.gohtml")
+	if err != nil {
+		return templateVersion{}, err
+	}
+
+	version := templateVersion{files: len(matches)}
+	for _, match := range matches {
+		info, err := fs.Stat(fsys, match)
+		if err != nil {
+			return templateVersion{}, err
+		}
+		if info.ModTime().After(version.modTime) {
+			version.modTime = info.ModTime()
+		}
+	}
+
+	return version, nil
+}
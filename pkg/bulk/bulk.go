@@ -0,0 +1,405 @@
+// Package bulk imports and exports users in batches, as CSV or as newline delimited JSON. It is
+// shared by the admin endpoints of the api and by the cli.
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/validation"
+)
+
+// Format is the file format of an import or export.
+type Format string
+
+// Supported formats.
+const (
+	CSV    Format = "csv"
+	NDJSON Format = "ndjson"
+)
+
+// MaxRows is the largest number of users one import accepts.
+const MaxRows = 10000
+
+// MaxPasswordRows is the largest number of users with a password one import accepts. Each
+// password is hashed with bcrypt while the request waits, which takes a quarter of a second of
+// CPU.
+const MaxPasswordRows = 100
+
+// columns are the columns of CSV files, and the fields of NDJSON lines. Only the first three are
+// required when importing; password and roles are never exported.
+var columns = []string{"first_name", "last_name", "email", "password", "roles"}
+
+// ParseFormat returns the format named by s, which is either a format name or a content type.
+func ParseFormat(s string) (Format, error) {
+	mediaType, _, _ := strings.Cut(s, ";")
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "csv", "text/csv":
+		return CSV, nil
+	case "ndjson", "application/x-ndjson", "application/ndjson":
+		return NDJSON, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q, use csv or ndjson", s)
+	}
+}
+
+// ContentType returns the media type of files in format f.
+func (f Format) ContentType() string {
+	if f == CSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Row is one user of an import.
+type Row struct {
+	Line      int      `json:"-"` // where the row starts in the file; line 1 is the CSV header
+	FirstName string   `json:"first_name" validate:"required,max=255"`
+	LastName  string   `json:"last_name" validate:"required,max=255"`
+	Email     string   `json:"email" validate:"required,email,max=255"`
+	Password  string   `json:"password,omitempty"`                // none if empty, to be reset by the user
+	Roles     []string `json:"roles" validate:"oneof=admin user"` // the user role if empty
+}
+
+// User returns the user to insert for r. Users without a password are inserted with one nobody
+// knows, so that they have to reset it before they can log in.
+func (r *Row) User() data.User {
+	roles := r.Roles
+	if len(roles) == 0 {
+		roles = []string{data.RoleUser}
+	}
+
+	return data.User{
+		FirstName:  r.FirstName,
+		LastName:   r.LastName,
+		Email:      r.Email,
+		Password:   r.Password,
+		Roles:      roles,
+		VerifiedAt: time.Now(), // imported by an admin, like users created through the api
+	}
+}
+
+// Read parses the users of an import. It fails on malformed files, and on files with more than
+// MaxRows users or more than MaxPasswordRows passwords; the users themselves are checked by
+// Validate.
+func Read(r io.Reader, f Format) ([]Row, error) {
+	var rows []Row
+	var err error
+
+	switch f {
+	case CSV:
+		rows, err = readCSV(r)
+	case NDJSON:
+		rows, err = readNDJSON(r)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	passwords := 0
+	for _, row := range rows {
+		if row.Password != "" {
+			passwords++
+		}
+	}
+	if passwords > MaxPasswordRows {
+		return nil, fmt.Errorf("too many passwords, at most %d users with a password can be imported at once; import the others without one", MaxPasswordRows)
+	}
+
+	return rows, nil
+}
+
+func readCSV(r io.Reader) ([]Row, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty file, expected a header line")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !validation.In(name, columns...) {
+			return nil, fmt.Errorf("line 1: unknown column %q", name)
+		}
+		index[name] = i
+	}
+	for _, name := range columns[:3] {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("line 1: missing column %q", name)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		if i, ok := index[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	// the exported columns may be escaped, passwords never are
+	exported := func(record []string, name string) string {
+		return unescapeFormula(field(record, name))
+	}
+
+	var rows []Row
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+
+		if len(rows) == MaxRows {
+			return nil, fmt.Errorf("too many users, at most %d can be imported at once", MaxRows)
+		}
+
+		rows = append(rows, Row{
+			Line:      line,
+			FirstName: exported(record, "first_name"),
+			LastName:  exported(record, "last_name"),
+			Email:     exported(record, "email"),
+			Password:  field(record, "password"),
+			Roles:     splitRoles(exported(record, "roles")),
+		})
+	}
+
+	return rows, nil
+}
+
+func readNDJSON(r io.Reader) ([]Row, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []Row
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+
+		if len(rows) == MaxRows {
+			return nil, fmt.Errorf("too many users, at most %d can be imported at once", MaxRows)
+		}
+
+		var row Row
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(&row)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if dec.More() {
+			return nil, fmt.Errorf("line %d: expected one json object per line", line)
+		}
+
+		row.Line = line
+		rows = append(rows, row)
+	}
+
+	return rows, scanner.Err()
+}
+
+// splitRoles splits the roles column of CSV files, like "admin;user".
+func splitRoles(s string) []string {
+	var roles []string
+	for _, role := range strings.Split(s, ";") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// formulaPrefixes are the characters spreadsheets take as the start of a formula.
+const formulaPrefixes = "=+-@\t\r"
+
+// isFormula reports whether a spreadsheet would run s as a formula, or s is one escaped by
+// escapeFormula.
+func isFormula(s string) bool {
+	s = strings.TrimLeft(s, "'")
+	return s != "" && strings.ContainsRune(formulaPrefixes, rune(s[0]))
+}
+
+// escapeFormula puts a quote in front of CSV cells a spreadsheet would run as a formula, so that
+// it shows them as text. Quoted cells get one more quote, which keeps the escaping reversible.
+func escapeFormula(s string) string {
+	if isFormula(s) {
+		return "'" + s
+	}
+	return s
+}
+
+// unescapeFormula undoes escapeFormula.
+func unescapeFormula(s string) string {
+	if strings.HasPrefix(s, "'") && isFormula(s) {
+		return s[1:]
+	}
+	return s
+}
+
+// Result is the outcome of one row of an import.
+type Result struct {
+	Line   int               `json:"line"`
+	Email  string            `json:"email"`
+	Errors validation.Errors `json:"errors,omitempty"`
+}
+
+// Validate checks each row like a single new user is checked, and that no two rows, nor a row and
+// one of the taken email addresses, share an email address.
+func Validate(rows []Row, taken []string) []Result {
+	seen := map[string]int{}
+	for _, email := range taken {
+		seen[email] = 0
+	}
+
+	results := make([]Result, len(rows))
+	for i := range rows {
+		row := &rows[i]
+
+		v := validation.New()
+		v.Struct(row)
+		if row.Password != "" {
+			local, _, _ := strings.Cut(row.Email, "@")
+			v.Password("password", row.Password, validation.DefaultPasswordPolicy, local, row.FirstName, row.LastName)
+		}
+
+		if line, ok := seen[row.Email]; ok {
+			if line == 0 {
+				v.Errors.Add("email", repository.ErrDuplicateEmail.Error())
+			} else {
+				v.Errors.Add("email", fmt.Sprintf("is also used on line %d", line))
+			}
+		} else if row.Email != "" {
+			seen[row.Email] = row.Line
+		}
+
+		results[i] = Result{Line: row.Line, Email: row.Email}
+		if !v.Valid() {
+			results[i].Errors = v.Errors
+		}
+	}
+
+	return results
+}
+
+// Report sums up an import.
+type Report struct {
+	DryRun  bool     `json:"dry_run"`
+	Total   int      `json:"total"`
+	Invalid int      `json:"invalid"`
+	Created int      `json:"created"`
+	Results []Result `json:"results"`
+}
+
+// Import validates rows, and unless one of them is invalid or dryRun is set, inserts all of them
+// in one transaction. Either all users are created, or none is.
+func Import(repo repository.DatabaseRepo, rows []Row, dryRun bool) (*Report, error) {
+	emails := make([]string, 0, len(rows))
+	for _, row := range rows {
+		emails = append(emails, row.Email)
+	}
+
+	taken, err := repo.ExistingEmails(emails)
+	if err != nil {
+		return nil, err
+	}
+
+	report := Report{DryRun: dryRun, Total: len(rows), Results: Validate(rows, taken)}
+	for _, result := range report.Results {
+		if result.Errors != nil {
+			report.Invalid++
+		}
+	}
+
+	if dryRun || report.Invalid > 0 || len(rows) == 0 {
+		return &report, nil
+	}
+
+	users := make([]data.User, 0, len(rows))
+	for i := range rows {
+		users = append(users, rows[i].User())
+	}
+
+	_, err = repo.InsertUsers(users)
+	if err != nil {
+		return nil, err
+	}
+	report.Created = len(users)
+
+	return &report, nil
+}
+
+// Export writes every user to w in format f, ordered by id. The output can be imported again;
+// passwords are never exported. CSV cells which a spreadsheet would run as a formula are prefixed
+// with a quote.
+func Export(w io.Writer, f Format, repo repository.DatabaseRepo) error {
+	var write func(u *data.User) error
+	var flush func() error
+
+	switch f {
+	case CSV:
+		cw := csv.NewWriter(w)
+		err := cw.Write([]string{"first_name", "last_name", "email", "roles"})
+		if err != nil {
+			return err
+		}
+		write = func(u *data.User) error {
+			return cw.Write([]string{
+				escapeFormula(u.FirstName),
+				escapeFormula(u.LastName),
+				escapeFormula(u.Email),
+				escapeFormula(strings.Join(u.Roles, ";")),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case NDJSON:
+		enc := json.NewEncoder(w)
+		write = func(u *data.User) error {
+			return enc.Encode(Row{FirstName: u.FirstName, LastName: u.LastName, Email: u.Email, Roles: u.Roles})
+		}
+		flush = func() error { return nil }
+	default:
+		return fmt.Errorf("unsupported format %q", f)
+	}
+
+	q := repository.UserQuery{Sort: "id", Limit: repository.MaxUserLimit}
+	for {
+		page, err := repo.ListUsers(q)
+		if err != nil {
+			return err
+		}
+
+		for _, u := range page.Users {
+			err = write(u)
+			if err != nil {
+				return err
+			}
+		}
+
+		if page.Next == nil {
+			return flush()
+		}
+		q.After = page.Next
+	}
+}
@@ -0,0 +1,241 @@
+package bulk
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"testing"
+	"webapp/pkg/data"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func TestRead(t *testing.T) {
+	var tests = []struct {
+		name          string
+		format        Format
+		input         string
+		expectedRows  int
+		expectedError string
+	}{
+		{"csv", CSV, "first_name,last_name,email,roles\nJane,Doe,jane@example.com,admin;user\n\nJim,Doe,jim@example.com,\n", 2, ""},
+		{"csv columns in any order", CSV, "Email, First_Name, Last_Name\njane@example.com,Jane,Doe\n", 1, ""},
+		{"csv without header", CSV, "", 0, "empty file"},
+		{"csv missing column", CSV, "first_name,last_name\nJane,Doe\n", 0, `missing column "email"`},
+		{"csv unknown column", CSV, "first_name,last_name,email,age\nJane,Doe,jane@example.com,42\n", 0, `unknown column "age"`},
+		{"csv short record", CSV, "first_name,last_name,email\nJane,Doe\n", 0, "wrong number of fields"},
+		{"ndjson", NDJSON, `{"first_name":"Jane","last_name":"Doe","email":"jane@example.com","roles":["admin"]}` + "\n\n" + `{"first_name":"Jim","last_name":"Doe","email":"jim@example.com"}`, 2, ""},
+		{"ndjson unknown field", NDJSON, `{"first_name":"Jane","age":42}`, 0, "line 1"},
+		{"ndjson two objects on a line", NDJSON, `{"first_name":"Jane"} {"first_name":"Jim"}`, 0, "one json object per line"},
+		{"ndjson malformed", NDJSON, "{\"first_name\":\"Jane\"}\n{", 0, "line 2"},
+	}
+
+	for _, e := range tests {
+		rows, err := Read(strings.NewReader(e.input), e.format)
+		if e.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), e.expectedError) {
+				t.Errorf("%s: expected error %q, but got %v", e.name, e.expectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+			continue
+		}
+		if len(rows) != e.expectedRows {
+			t.Errorf("%s: expected %d rows, but got %d", e.name, e.expectedRows, len(rows))
+		}
+	}
+
+	passwords := "first_name,last_name,email,password\n"
+	for i := 0; i <= MaxPasswordRows; i++ {
+		passwords += fmt.Sprintf("Jane,Doe,jane%d@example.com,Secret-%d\n", i, i)
+	}
+	_, err := Read(strings.NewReader(passwords), CSV)
+	if err == nil || !strings.Contains(err.Error(), "too many passwords") {
+		t.Errorf("expected more than %d passwords to be refused, but got %v", MaxPasswordRows, err)
+	}
+
+	rows, _ := Read(strings.NewReader("first_name,last_name,email,roles\nJane,Doe,jane@example.com,admin;user\n\nJim,Doe,jim@example.com,\n"), CSV)
+	if rows[0].Line != 2 || rows[1].Line != 4 {
+		t.Errorf("expected rows on lines 2 and 4, but got %d and %d", rows[0].Line, rows[1].Line)
+	}
+	if len(rows[0].Roles) != 2 || rows[0].Roles[1] != "user" || rows[1].Roles != nil {
+		t.Errorf("unexpected roles %v and %v", rows[0].Roles, rows[1].Roles)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	rows := []Row{
+		{Line: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@example.com"},
+		{Line: 3, FirstName: "Jim", LastName: "Doe", Email: "jane@example.com"},
+		{Line: 4, FirstName: "Admin", LastName: "Again", Email: "admin@example.com"},
+		{Line: 5, FirstName: "", LastName: "Doe", Email: "nobody", Roles: []string{"root"}},
+		{Line: 6, FirstName: "Joe", LastName: "Doe", Email: "joe@example.com", Password: "Password1"},
+	}
+
+	results := Validate(rows, []string{"admin@example.com"})
+
+	var expected = []map[string]string{
+		nil,
+		{"email": "is also used on line 2"},
+		{"email": "email address already in use"},
+		{"first_name": "must not be blank", "email": "must be a valid email address", "roles": "must be one of admin, user"},
+		{"password": "is too common"},
+	}
+
+	for i, result := range results {
+		if result.Line != rows[i].Line {
+			t.Errorf("row %d: expected line %d, but got %d", i, rows[i].Line, result.Line)
+		}
+		if len(result.Errors) != len(expected[i]) {
+			t.Errorf("line %d: expected %v, but got %v", result.Line, expected[i], result.Errors)
+			continue
+		}
+		for field, message := range expected[i] {
+			if result.Errors.Get(field) != message {
+				t.Errorf("line %d: expected %q for %s, but got %q", result.Line, message, field, result.Errors.Get(field))
+			}
+		}
+	}
+}
+
+func TestImportAndExport(t *testing.T) {
+	repo := &dbrepo.TestDBRepo{}
+	input := "first_name,last_name,email,roles\nJane,Doe,jane@example.com,admin;user\nJim,Doe,jim@example.com,\n"
+
+	rows, err := Read(strings.NewReader(input), CSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Import(repo, rows, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.Total != 2 || report.Invalid != 0 || report.Created != 0 {
+		t.Errorf("unexpected dry run report %+v", report)
+	}
+	if _, err := repo.GetUserByEmail("jane@example.com"); err == nil {
+		t.Error("dry run created a user")
+	}
+
+	// one invalid row keeps all others out
+	invalid := append(rows, Row{Line: 4, FirstName: "Admin", LastName: "Again", Email: "admin@example.com"})
+	report, err = Import(repo, invalid, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Invalid != 1 || report.Created != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if _, err := repo.GetUserByEmail("jane@example.com"); err == nil {
+		t.Error("an invalid import created a user")
+	}
+
+	report, err = Import(repo, rows, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Created != 2 {
+		t.Errorf("expected 2 users created, but got %+v", report)
+	}
+
+	jim, err := repo.GetUserByEmail("jim@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jim.Roles) != 1 || jim.Roles[0] != "user" || jim.Password == "" {
+		t.Errorf("expected the user role and a random password, but got roles %v", jim.Roles)
+	}
+
+	var out bytes.Buffer
+	err = Export(&out, CSV, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "first_name,last_name,email,roles\nAdmin,User,admin@example.com,admin\nJane,Doe,jane@example.com,admin;user\nJim,Doe,jim@example.com,user\n"
+	if out.String() != expected {
+		t.Errorf("unexpected export:\n%s", out.String())
+	}
+
+	out.Reset()
+	err = Export(&out, NDJSON, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the export can be imported again
+	exported, err := Read(&out, NDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 3 || exported[2].Email != "jim@example.com" || exported[2].Line != 3 {
+		t.Errorf("unexpected ndjson export %+v", exported)
+	}
+}
+
+func TestExport_formulas(t *testing.T) {
+	repo := &dbrepo.TestDBRepo{}
+	names := []string{"=HYPERLINK(\"http://evil.example.com\")", "+1", "-2", "@SUM(A1)", "\tTab", "\rReturn", "'=quoted", "O'Brien"}
+
+	var users []data.User
+	for i, name := range names {
+		users = append(users, data.User{FirstName: name, LastName: "Doe", Email: fmt.Sprintf("user%d@example.com", i), Password: "secret", Roles: []string{"user"}})
+	}
+	_, err := repo.InsertUsers(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = Export(&out, CSV, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(bytes.NewReader(out.Bytes())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"'=HYPERLINK(\"http://evil.example.com\")", "'+1", "'-2", "'@SUM(A1)", "'\tTab", "'\rReturn", "''=quoted", "O'Brien"}
+	for i, e := range expected {
+		// the first record is the header, the second the admin
+		if got := records[i+2][0]; got != e {
+			t.Errorf("expected %q to be exported as %q, but got %q", names[i], e, got)
+		}
+	}
+
+	// the export can be imported again
+	rows, err := Read(&out, CSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range names {
+		if got := rows[i+1].FirstName; got != name {
+			t.Errorf("expected %q to be imported again, but got %q", name, got)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	var tests = []struct {
+		in       string
+		expected Format
+	}{
+		{"csv", CSV},
+		{"text/csv; charset=utf-8", CSV},
+		{"NDJSON", NDJSON},
+		{"application/x-ndjson", NDJSON},
+		{"application/json", ""},
+	}
+
+	for _, e := range tests {
+		f, err := ParseFormat(e.in)
+		if f != e.expected || (err != nil) != (e.expected == "") {
+			t.Errorf("%q: expected %q, but got %q (%v)", e.in, e.expected, f, err)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/keyring"
+	"webapp/pkg/mailer"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Profiles. The production profile refuses to start with the insecure development defaults.
+const (
+	Development = "development"
+	Production  = "production"
+)
+
+// EnvPrefix is put in front of every environment variable; the flag -jwt-alg is read from
+// WEBAPP_JWT_ALG, for example.
+const EnvPrefix = "WEBAPP_"
+
+// minSecretLength is the shortest token secret accepted in production.
+const minSecretLength = 32
+
+// Server is where one binary listens and the URL it is reached at.
+type Server struct {
+	Port    int    `yaml:"port"`
+	BaseURL string `yaml:"base_url"`
+}
+
+// Addr returns the address to listen on.
+func (s Server) Addr() string {
+	return ":" + strconv.Itoa(s.Port)
+}
+
+// Lifetimes are the lifetimes of the tokens issued by the api and the web app.
+type Lifetimes struct {
+	Access            time.Duration `yaml:"access"`
+	Refresh           time.Duration `yaml:"refresh"` // must be longer than Access
+	MFA               time.Duration `yaml:"mfa"`
+	PasswordReset     time.Duration `yaml:"password_reset"`
+	EmailVerification time.Duration `yaml:"email_verification"` // of the links confirming the email address of new accounts
+}
+
+// JWT configures the keys access tokens are signed with.
+type JWT struct {
+	Algorithm      string        `yaml:"algorithm"`
+	KeyDir         string        `yaml:"key_dir"`      // keys are kept in memory only if empty
+	KeyRotation    time.Duration `yaml:"key_rotation"` // 0 disables rotation
+	RefreshRenewal time.Duration `yaml:"refresh_renew_within"`
+}
+
+// Session configures the sessions of the web app.
+type Session struct {
+	Lifetime     time.Duration `yaml:"lifetime"`     // absolute, however active the session is
+	IdleTimeout  time.Duration `yaml:"idle_timeout"` // 0 disables it
+	SecureCookie bool          `yaml:"secure_cookie"`
+}
+
+// Uploads limits the size of request bodies.
+type Uploads struct {
+	Dir          string `yaml:"dir"`       // where the web app and the api store uploaded images
+	MaxBytes     int64  `yaml:"max_bytes"` // largest multipart upload
+	MaxJSONBytes int64  `yaml:"max_json_bytes"`
+}
+
+// Purge configures how long deleted users are kept before the web app removes them for good.
+type Purge struct {
+	Retention time.Duration `yaml:"retention"`
+	Interval  time.Duration `yaml:"interval"` // 0 disables purging
+}
+
+// CORS lists the origins allowed to call the api from a browser.
+type CORS struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// Config is the configuration shared by the api and the web app. Each binary ignores the
+// settings which don't apply to it.
+type Config struct {
+	Profile string `yaml:"profile"`
+	DSN     string `yaml:"dsn"`
+	Domain  string `yaml:"domain"`
+
+	// API is the public URL of the api, used as OpenID Connect issuer and in Link headers
+	API Server `yaml:"api"`
+	// Web is the public URL of the web app, used in links sent by email
+	Web Server `yaml:"web"`
+
+	// PasswordResetURL is the page password reset links sent by the api point to
+	PasswordResetURL string `yaml:"password_reset_url"`
+
+	// TokenSecret signs password reset links; the api and the web app must share it
+	TokenSecret   string `yaml:"token_secret"`
+	TokenDenylist string `yaml:"token_denylist"`
+
+	// TemplateDir is for development: the web app reads its templates from there instead of the
+	// embedded ones, and parses them again when they change
+	TemplateDir string `yaml:"template_dir"`
+
+	Lifetimes Lifetimes     `yaml:"lifetimes"`
+	JWT       JWT           `yaml:"jwt"`
+	Session   Session       `yaml:"session"`
+	Uploads   Uploads       `yaml:"uploads"`
+	Purge     Purge         `yaml:"purge"`
+	CORS      CORS          `yaml:"cors"`
+	Mail      mailer.Config `yaml:"mail"`
+}
+
+// defaultDSN is only good for the docker-compose database.
+const defaultDSN = "host=localhost port=5432 user=postgres password=postgres dbname=users sslmode=disable timezone=UTC connect_timeout=5"
+
+// Defaults returns the configuration for local development.
+func Defaults() *Config {
+	return &Config{
+		Profile:          Development,
+		DSN:              defaultDSN,
+		Domain:           "example.com",
+		API:              Server{Port: 8081, BaseURL: "http://localhost:8081"},
+		Web:              Server{Port: 8080, BaseURL: "http://localhost:8080"},
+		PasswordResetURL: "http://localhost:8080/reset-password",
+		TokenDenylist:    "postgres",
+		Lifetimes: Lifetimes{
+			Access:            15 * time.Minute,
+			Refresh:           24 * time.Hour,
+			MFA:               5 * time.Minute,
+			PasswordReset:     time.Hour,
+			EmailVerification: 48 * time.Hour,
+		},
+		JWT: JWT{
+			Algorithm:      keyring.RS256,
+			KeyRotation:    24 * time.Hour,
+			RefreshRenewal: 30 * time.Second,
+		},
+		Session: Session{
+			Lifetime:     24 * time.Hour,
+			IdleTimeout:  time.Hour,
+			SecureCookie: true,
+		},
+		Uploads: Uploads{
+			Dir:          "./static/img",
+			MaxBytes:     5 << 20,
+			MaxJSONBytes: 1 << 20,
+		},
+		Purge: Purge{
+			Retention: 30 * 24 * time.Hour,
+			Interval:  time.Hour,
+		},
+		CORS: CORS{
+			AllowedOrigins: []string{"http://localhost:8090"},
+		},
+		Mail: mailer.Config{
+			Kind:     mailer.KindLog,
+			From:     "no-reply@example.com",
+			Dir:      "./mail",
+			SMTPAddr: "localhost:25",
+		},
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence: the defaults, the
+// YAML file named by -config (or WEBAPP_CONFIG), environment variables and the command line
+// flags in args. The result is validated.
+func Load(name string, args []string, getenv func(string) string) (*Config, error) {
+	cfg := Defaults()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	var file string
+	fs.StringVar(&file, "config", getenv(EnvPrefix+"CONFIG"), "YAML configuration file")
+	cfg.bindFlags(fs)
+
+	// the flags are parsed twice: once to find the config file, and once more after the file
+	// and the environment have been applied, so that flags win
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	*cfg = *Defaults()
+
+	if file != "" {
+		err = cfg.readFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = applyEnv(fs, getenv)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Profile, "profile", c.Profile, "configuration profile: development|production")
+	fs.StringVar(&c.DSN, "dsn", c.DSN, "Postgres Connection")
+	fs.StringVar(&c.Domain, "domain", c.Domain, "Domain for application, e.g. company.com")
+	fs.IntVar(&c.API.Port, "api-port", c.API.Port, "port the api listens on")
+	fs.StringVar(&c.API.BaseURL, "api-base-url", c.API.BaseURL, "public URL of the api, used as OpenID Connect issuer")
+	fs.IntVar(&c.Web.Port, "web-port", c.Web.Port, "port the web app listens on")
+	fs.StringVar(&c.Web.BaseURL, "web-base-url", c.Web.BaseURL, "public URL of the web app, used in links sent by email")
+	fs.StringVar(&c.PasswordResetURL, "password-reset-url", c.PasswordResetURL, "page password reset links point to")
+	fs.StringVar(&c.TokenSecret, "token-secret", c.TokenSecret, "secret for signing password reset links; must be shared by the api and the web app. Random if empty")
+	fs.StringVar(&c.TokenDenylist, "token-denylist", c.TokenDenylist, "where revoked access tokens are kept: postgres|memory")
+	fs.StringVar(&c.TemplateDir, "template-dir", c.TemplateDir, "development only: read the web templates from this directory and reload them when they change, e.g. ./templates. Embedded templates are used if empty")
+
+	fs.DurationVar(&c.Lifetimes.Access, "access-token-lifetime", c.Lifetimes.Access, "lifetime of access tokens")
+	fs.DurationVar(&c.Lifetimes.Refresh, "refresh-token-lifetime", c.Lifetimes.Refresh, "lifetime of refresh tokens; must be longer than access tokens")
+	fs.DurationVar(&c.Lifetimes.MFA, "mfa-token-lifetime", c.Lifetimes.MFA, "time allowed for entering the second factor after the password")
+	fs.DurationVar(&c.Lifetimes.PasswordReset, "password-reset-lifetime", c.Lifetimes.PasswordReset, "lifetime of password reset links")
+	fs.DurationVar(&c.Lifetimes.EmailVerification, "email-verification-lifetime", c.Lifetimes.EmailVerification, "lifetime of the links confirming the email address of new accounts")
+
+	fs.StringVar(&c.JWT.Algorithm, "jwt-alg", c.JWT.Algorithm, "signing algorithm for new keys: RS256|EdDSA")
+	fs.StringVar(&c.JWT.KeyDir, "jwt-key-dir", c.JWT.KeyDir, "directory holding the signing keys; keys are kept in memory only if empty")
+	fs.DurationVar(&c.JWT.KeyRotation, "jwt-key-rotation", c.JWT.KeyRotation, "how often to rotate the signing key; 0 disables rotation")
+	fs.DurationVar(&c.JWT.RefreshRenewal, "refresh-renew-within", c.JWT.RefreshRenewal, "only allow refreshing tokens that expire within this duration; 0 allows refreshing at any time")
+
+	fs.DurationVar(&c.Session.Lifetime, "session-lifetime", c.Session.Lifetime, "absolute lifetime of web sessions")
+	fs.DurationVar(&c.Session.IdleTimeout, "session-idle-timeout", c.Session.IdleTimeout, "end web sessions which weren't used for this long; 0 disables the idle timeout")
+	fs.BoolVar(&c.Session.SecureCookie, "session-secure-cookie", c.Session.SecureCookie, "only send the session cookie over https")
+
+	fs.StringVar(&c.Uploads.Dir, "upload-dir", c.Uploads.Dir, "directory uploaded images are stored in")
+	fs.Int64Var(&c.Uploads.MaxBytes, "upload-max-bytes", c.Uploads.MaxBytes, "largest accepted upload in bytes")
+	fs.Int64Var(&c.Uploads.MaxJSONBytes, "json-max-bytes", c.Uploads.MaxJSONBytes, "largest accepted JSON request body in bytes")
+
+	fs.DurationVar(&c.Purge.Retention, "purge-retention", c.Purge.Retention, "how long deleted users can be restored before they are purged")
+	fs.DurationVar(&c.Purge.Interval, "purge-interval", c.Purge.Interval, "how often to purge deleted users; 0 disables purging")
+
+	fs.Var((*listValue)(&c.CORS.AllowedOrigins), "cors-origins", "comma separated origins allowed to call the api from a browser")
+
+	fs.StringVar(&c.Mail.Kind, "mailer", c.Mail.Kind, "how to send email: log|file|smtp")
+	fs.StringVar(&c.Mail.From, "mail-from", c.Mail.From, "sender address of emails")
+	fs.StringVar(&c.Mail.Dir, "mail-dir", c.Mail.Dir, "directory the file mailer writes emails to")
+	fs.StringVar(&c.Mail.SMTPAddr, "smtp-addr", c.Mail.SMTPAddr, "smtp server of the smtp mailer")
+	fs.StringVar(&c.Mail.SMTPUsername, "smtp-username", c.Mail.SMTPUsername, "smtp username; no authentication if empty")
+	fs.StringVar(&c.Mail.SMTPPassword, "smtp-password", c.Mail.SMTPPassword, "smtp password")
+}
+
+func (c *Config) readFile(file string) error {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	err = yaml.UnmarshalStrict(raw, c)
+	if err != nil {
+		return fmt.Errorf("reading config file %s: %w", file, err)
+	}
+
+	return nil
+}
+
+// applyEnv sets every flag which has a matching environment variable.
+func applyEnv(fs *flag.FlagSet, getenv func(string) string) error {
+	var err error
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" {
+			return
+		}
+
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value := getenv(name)
+		if value == "" {
+			return
+		}
+
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, name, setErr)
+		}
+	})
+
+	return err
+}
+
+// Validate checks that the configuration is usable, and in the production profile that it
+// doesn't rely on insecure defaults. All problems are reported at once.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Profile == Development || c.Profile == Production, "unknown profile %q", c.Profile)
+	check(c.DSN != "", "dsn must not be empty")
+	check(c.Domain != "", "domain must not be empty")
+	check(c.API.Port > 0 && c.API.Port < 65536, "api port %d is out of range", c.API.Port)
+	check(isAbsoluteURL(c.API.BaseURL), "api base url %q must be an absolute URL", c.API.BaseURL)
+	check(c.Web.Port > 0 && c.Web.Port < 65536, "web port %d is out of range", c.Web.Port)
+	check(isAbsoluteURL(c.Web.BaseURL), "web base url %q must be an absolute URL", c.Web.BaseURL)
+	check(isAbsoluteURL(c.PasswordResetURL), "password reset url %q must be an absolute URL", c.PasswordResetURL)
+	check(c.TokenDenylist == "postgres" || c.TokenDenylist == "memory", "unknown token denylist %q", c.TokenDenylist)
+
+	check(c.Lifetimes.Access > 0, "access token lifetime must be positive")
+	check(c.Lifetimes.Refresh > c.Lifetimes.Access, "refresh token lifetime must be longer than the access token lifetime")
+	check(c.Lifetimes.MFA > 0, "mfa token lifetime must be positive")
+	check(c.Lifetimes.PasswordReset > 0, "password reset lifetime must be positive")
+	check(c.Lifetimes.EmailVerification > 0, "email verification lifetime must be positive")
+
+	check(c.JWT.Algorithm == keyring.RS256 || c.JWT.Algorithm == keyring.EdDSA, "unsupported signing algorithm %q", c.JWT.Algorithm)
+	check(c.JWT.KeyRotation >= 0, "jwt key rotation must not be negative")
+	check(c.JWT.RefreshRenewal >= 0 && c.JWT.RefreshRenewal <= c.Lifetimes.Refresh, "refresh renewal window must be between 0 and the refresh token lifetime")
+
+	check(c.Session.Lifetime > 0, "session lifetime must be positive")
+	check(c.Session.IdleTimeout >= 0 && c.Session.IdleTimeout <= c.Session.Lifetime, "session idle timeout must be between 0 and the session lifetime")
+
+	check(c.Uploads.Dir != "", "upload dir must not be empty")
+	check(c.Uploads.MaxBytes > 0, "upload max bytes must be positive")
+	check(c.Uploads.MaxJSONBytes > 0, "json max bytes must be positive")
+
+	check(c.Purge.Retention >= 0, "purge retention must not be negative")
+	check(c.Purge.Interval >= 0, "purge interval must not be negative")
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		check(origin == "*" || isAbsoluteURL(origin), "cors origin %q must be * or an absolute URL", origin)
+	}
+
+	check(c.Mail.Kind == mailer.KindLog || c.Mail.Kind == mailer.KindFile || c.Mail.Kind == mailer.KindSMTP, "unknown mailer %q", c.Mail.Kind)
+	check(c.Mail.From != "", "mail from must not be empty")
+
+	if c.Profile == Production {
+		defaults := Defaults()
+
+		check(len(c.TokenSecret) >= minSecretLength, "token secret must be set and at least %d bytes long in production", minSecretLength)
+		check(c.DSN != defaults.DSN && !strings.Contains(c.DSN, "password=postgres"), "dsn must not use the default postgres password in production")
+		check(strings.HasPrefix(c.API.BaseURL, "https://"), "api base url must use https in production")
+		check(strings.HasPrefix(c.Web.BaseURL, "https://"), "web base url must use https in production")
+		check(c.JWT.KeyDir != "", "jwt key dir must be set in production, or tokens won't survive a restart")
+		check(c.Session.SecureCookie, "session cookie must be secure in production")
+		check(c.TemplateDir == "", "template dir is for development only; production uses the embedded templates")
+		check(c.Mail.Kind != mailer.KindLog, "the log mailer writes password reset links to the log; use file or smtp in production")
+
+		for _, origin := range c.CORS.AllowedOrigins {
+			check(origin != "*" && !strings.Contains(origin, "localhost"), "cors origin %q is not allowed in production", origin)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
+
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// listValue is a flag.Value for comma separated lists. Setting it replaces the whole list.
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+func writeFile(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(file, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDefaults_Validate(t *testing.T) {
+	if err := Defaults().Validate(); err != nil {
+		t.Errorf("expected the defaults to be valid, got %s", err)
+	}
+}
+
+func TestDefaults_servers(t *testing.T) {
+	cfg := Defaults()
+	if cfg.API.Port == cfg.Web.Port {
+		t.Errorf("expected the api and the web app to listen on different ports, both use %d", cfg.API.Port)
+	}
+	if cfg.API.BaseURL == cfg.Web.BaseURL {
+		t.Errorf("expected the api and the web app to have different base urls, both use %s", cfg.API.BaseURL)
+	}
+}
+
+func TestLoad_precedence(t *testing.T) {
+	file := writeFile(t, `
+api:
+  port: 9000
+domain: file.example.com
+lifetimes:
+  access: 5m
+cors:
+  allowed_origins:
+    - https://app.example.com
+mail:
+  kind: file
+`)
+
+	cfg, err := Load("test", []string{"-config", file, "-api-port", "9002"}, env(map[string]string{
+		"WEBAPP_API_PORT":         "9001",
+		"WEBAPP_WEB_BASE_URL":     "https://www.example.com",
+		"WEBAPP_DOMAIN":           "env.example.com",
+		"WEBAPP_CORS_ORIGINS":     "https://a.example.com, https://b.example.com",
+		"WEBAPP_SESSION_LIFETIME": "2h",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"flag beats env and file", cfg.API.Port, 9002},
+		{"web port untouched", cfg.Web.Port, 8080},
+		{"web base url from env", cfg.Web.BaseURL, "https://www.example.com"},
+		{"env beats file", cfg.Domain, "env.example.com"},
+		{"file beats defaults", cfg.Lifetimes.Access, 5 * time.Minute},
+		{"env only", cfg.Session.Lifetime, 2 * time.Hour},
+		{"lists from env", strings.Join(cfg.CORS.AllowedOrigins, " "), "https://a.example.com https://b.example.com"},
+		{"nested file section", cfg.Mail.Kind, "file"},
+		{"untouched default", cfg.Lifetimes.Refresh, 24 * time.Hour},
+		{"refresh renewal window", cfg.JWT.RefreshRenewal, 30 * time.Second},
+	}
+
+	for _, e := range tests {
+		if e.got != e.expected {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expected, e.got)
+		}
+	}
+}
+
+func TestLoad_configFromEnv(t *testing.T) {
+	file := writeFile(t, "web:\n  port: 9000\n")
+
+	cfg, err := Load("test", nil, env(map[string]string{"WEBAPP_CONFIG": file}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Web.Port != 9000 {
+		t.Errorf("expected port from the config file named by WEBAPP_CONFIG, got %d", cfg.Web.Port)
+	}
+}
+
+func TestLoad_errors(t *testing.T) {
+	var tests = []struct {
+		name string
+		file string
+		args []string
+		env  map[string]string
+	}{
+		{"unknown key in file", "prot: 9000\n", nil, nil},
+		{"shared port is gone", "port: 9000\n", nil, nil},
+		{"malformed file", "api:\n  port: [\n", nil, nil},
+		{"bad env value", "", nil, map[string]string{"WEBAPP_API_PORT": "eighty"}},
+		{"web port out of range", "", []string{"-web-port", "70000"}, nil},
+		{"unknown flag", "", []string{"-colour", "blue"}, nil},
+		{"invalid value", "", []string{"-jwt-alg", "HS256"}, nil},
+		{"refresh shorter than access", "", []string{"-refresh-token-lifetime", "1m"}, nil},
+		{"negative purge retention", "", []string{"-purge-retention", "-1h"}, nil},
+		{"email verification lifetime of zero", "", []string{"-email-verification-lifetime", "0s"}, nil},
+		{"idle timeout longer than the session", "", []string{"-session-lifetime", "1h", "-session-idle-timeout", "2h"}, nil},
+	}
+
+	for _, e := range tests {
+		args := e.args
+		if e.file != "" {
+			args = append([]string{"-config", writeFile(t, e.file)}, args...)
+		}
+
+		_, err := Load("test", args, env(e.env))
+		if err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
+
+func TestValidate_production(t *testing.T) {
+	cfg := Defaults()
+	cfg.Profile = Production
+	cfg.TemplateDir = "./templates"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected the development defaults to be refused in production")
+	}
+
+	for _, expected := range []string{"token secret", "dsn", "https", "jwt key dir", "log mailer", "cors origin", "template dir"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected a complaint about %q, got %s", expected, err)
+		}
+	}
+
+	cfg.TokenSecret = strings.Repeat("s", minSecretLength)
+	cfg.DSN = "host=db user=webapp password=hunter2-but-longer dbname=users sslmode=require"
+	cfg.API.BaseURL = "https://api.example.com"
+	cfg.Web.BaseURL = "https://www.example.com"
+	cfg.JWT.KeyDir = "/var/lib/webapp/keys"
+	cfg.Mail.Kind = "smtp"
+	cfg.CORS.AllowedOrigins = []string{"https://app.example.com"}
+	cfg.TemplateDir = ""
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected a secure production configuration to be valid, got %s", err)
+	}
+}
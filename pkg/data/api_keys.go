@@ -0,0 +1,46 @@
+package data
+
+import "time"
+
+// Scopes an API key can be granted. A key acts as its owner, but only within its scopes.
+const (
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+	ScopeAuditRead  = "audit:read"
+)
+
+// Scopes lists every scope an API key can be granted.
+var Scopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeAuditRead}
+
+// APIKey is a long-lived credential for scripts and service accounts. Only the hash of the key
+// is kept; the prefix lets users tell their keys apart.
+type APIKey struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Name       string    `json:"name"`
+	Prefix     string    `json:"prefix"`
+	KeyHash    string    `json:"-"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"` // zero if the key does not expire
+	RevokedAt  time.Time `json:"revoked_at"`
+}
+
+// HasScope reports whether the key was granted scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive reports whether the key may be used at now.
+func (k *APIKey) IsActive(now time.Time) bool {
+	if !k.RevokedAt.IsZero() {
+		return false
+	}
+	return k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt)
+}
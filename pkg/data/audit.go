@@ -0,0 +1,65 @@
+package data
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// AuditEntry is one record of the append-only audit log.
+type AuditEntry struct {
+	ID        int       `json:"id"`
+	Actor     string    `json:"actor"`  // who did it, e.g. a user id; empty for the system itself
+	Action    string    `json:"action"` // what happened, e.g. login.lockout
+	Target    string    `json:"target"` // what it happened to
+	IP        string    `json:"ip"`
+	Details   string    `json:"details"`
+	Changes   Changes   `json:"changes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Change is the value of a field before and after an action; Before is nil for created records,
+// and After is nil for deleted ones.
+type Change struct {
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Changes maps the names of the fields an action changed to their change.
+type Changes map[string]Change
+
+// Redacted stands in for secrets in changes; the audit log only records that they were set.
+const Redacted = "[redacted]"
+
+// UserChanges returns the fields which differ between two versions of a user. Pass nil as before
+// for a new user, and nil as after for a deleted one. The password of after is only compared if
+// set, as the passwords of stored users are hashes; it is always redacted.
+func UserChanges(before, after *User) Changes {
+	fields := func(u *User) map[string]interface{} {
+		if u == nil {
+			return map[string]interface{}{}
+		}
+		roles := append([]string(nil), u.Roles...)
+		sort.Strings(roles)
+		return map[string]interface{}{
+			"first_name": u.FirstName,
+			"last_name":  u.LastName,
+			"email":      u.Email,
+			"roles":      strings.Join(roles, ","),
+		}
+	}
+
+	old, updated := fields(before), fields(after)
+	changes := Changes{}
+	for _, name := range []string{"first_name", "last_name", "email", "roles"} {
+		if old[name] != updated[name] {
+			changes[name] = Change{Before: old[name], After: updated[name]}
+		}
+	}
+
+	if after != nil && after.Password != "" && (before == nil || after.Password != before.Password) {
+		changes["password"] = Change{After: Redacted}
+	}
+
+	return changes
+}
@@ -0,0 +1,16 @@
+package data
+
+import "time"
+
+// LoginThrottle tracks the failed logins for one key, i.e. for an account or for an IP address.
+type LoginThrottle struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"` // zero if the key is not locked
+}
+
+// IsLocked reports whether logins for the key are blocked at now.
+func (t *LoginThrottle) IsLocked(now time.Time) bool {
+	return t.LockedUntil.After(now)
+}
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// OAuthClient is an application registered to log users in through our authorization server.
+type OAuthClient struct {
+	ID           string    `json:"client_id"`
+	SecretHash   string    `json:"-"` // empty for public clients, which can only authenticate with PKCE
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"-"`
+}
+
+// IsPublic reports whether the client has no secret, like a single page app.
+func (c *OAuthClient) IsPublic() bool {
+	return c.SecretHash == ""
+}
+
+// AllowsRedirect reports whether uri is one of the registered redirect uris. Redirect uris are
+// compared exactly, as required by OAuth 2.0 security best practice.
+func (c *OAuthClient) AllowsRedirect(uri string) bool {
+	for _, allowed := range c.RedirectURIs {
+		if allowed == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// SecretMatches compares a client supplied secret with the stored hash.
+func (c *OAuthClient) SecretMatches(secret string) bool {
+	if c.IsPublic() {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(c.SecretHash), []byte(secret)) == nil
+}
+
+// AuthorizationCode is a single use code handed to a client after the user logged in and gave consent.
+// Only a hash of the code itself is stored.
+type AuthorizationCode struct {
+	CodeHash            string
+	ClientID            string
+	UserID              int
+	RedirectURI         string
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	ExpiresAt           time.Time
+}
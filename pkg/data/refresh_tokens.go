@@ -0,0 +1,16 @@
+package data
+
+import "time"
+
+// RefreshToken is a refresh token we have issued. Every refresh rotates the token into a new
+// one of the same family, so a family describes one login on one device.
+type RefreshToken struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"user_id"`
+	FamilyID  string    `json:"family_id"`
+	Device    string    `json:"device"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UsedAt    time.Time `json:"used_at"`    // zero until the token was exchanged
+	RevokedAt time.Time `json:"revoked_at"` // zero unless the family was revoked
+}
@@ -0,0 +1,18 @@
+package data
+
+import "time"
+
+// UserTOTP is the time-based one-time password secret of a user. Enrollment is pending until the
+// user proves, with a first code, that their authenticator app holds the secret.
+type UserTOTP struct {
+	UserID       int       `json:"user_id"`
+	Secret       string    `json:"-"`
+	ConfirmedAt  time.Time `json:"confirmed_at"` // zero while the enrollment is pending
+	LastUsedStep int64     `json:"-"`            // time step of the last accepted code, so codes can't be replayed
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// IsConfirmed reports whether the user finished enrolling, i.e. whether logins need a second factor.
+func (t *UserTOTP) IsConfirmed() bool {
+	return !t.ConfirmedAt.IsZero()
+}
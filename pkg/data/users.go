@@ -7,6 +7,12 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Roles a user can have.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
 // User describes the data for the User type.
 type User struct {
 	ID         int       `json:"id"`
@@ -14,12 +20,36 @@ type User struct {
 	LastName   string    `json:"last_name"`
 	Email      string    `json:"email"`
 	Password   string    `json:"-"`
-	IsAdmin    int       `json:"is_admin"`
+	Roles      []string  `json:"roles"`
 	CreatedAt  time.Time `json:"-"`
 	UpdatedAt  time.Time `json:"-"`
+	Version    int       `json:"-"` // incremented by every update, for optimistic concurrency
+	DeletedAt  time.Time `json:"-"` // zero unless the user was deleted; deleted users are purged later
+	VerifiedAt time.Time `json:"-"` // when the email address was confirmed; zero for new signups until then
 	ProfilePic UserImage `json:"-"` // Embedded
 }
 
+// HasRole reports whether the user has the given role.
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Verified reports whether the user has confirmed the email address. Users created by admins
+// count as verified from the start.
+func (u *User) Verified() bool {
+	return !u.VerifiedAt.IsZero()
+}
+
+// IsAdmin reports whether the user has the admin role.
+func (u *User) IsAdmin() bool {
+	return u.HasRole(RoleAdmin)
+}
+
 // PasswordMatches uses Go's bcrypt package to compare a user supplied password
 // with the hash we have stored for a given user in the database. If the password
 // and hash match, we return true; otherwise, we return false.
@@ -0,0 +1,25 @@
+package data
+
+import "time"
+
+// WebSession is a login to the web app, listed so that users can see where they are logged in
+// and end sessions they don't recognise. The session data itself stays with the session store;
+// ID is a random id kept in the session, not its cookie token.
+type WebSession struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"` // when the session ends regardless of activity
+}
+
+// IsActive reports whether the session is still alive at now, given the idle timeout of the
+// session manager; an idle timeout of 0 disables it.
+func (s *WebSession) IsActive(now time.Time, idleTimeout time.Duration) bool {
+	if !now.Before(s.ExpiresAt) {
+		return false
+	}
+	return idleTimeout <= 0 || now.Before(s.LastSeenAt.Add(idleTimeout))
+}
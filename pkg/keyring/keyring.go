@@ -0,0 +1,344 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Supported signing algorithms for newly generated keys.
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+const rsaKeyBits = 2048
+
+// Key is one signing key of the key ring, together with the JWT signing method it is used with.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   crypto.Signer
+	CreatedAt time.Time
+}
+
+// Public returns the public half of the key.
+func (k *Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// KeyRing holds the key currently used for signing, plus the retired keys that are still
+// accepted when verifying tokens. Retired keys are kept for VerifyFor after they stop signing,
+// so that tokens issued just before a rotation stay valid until they expire.
+type KeyRing struct {
+	Algorithm string
+	VerifyFor time.Duration
+	Dir       string
+
+	mu   sync.RWMutex
+	keys []*Key // ordered by creation time; the last one is the current signing key
+}
+
+// New returns a key ring with a single freshly generated key, kept in memory only.
+func New(algorithm string, verifyFor time.Duration) (*KeyRing, error) {
+	kr := &KeyRing{
+		Algorithm: algorithm,
+		VerifyFor: verifyFor,
+	}
+
+	err := kr.Rotate()
+	if err != nil {
+		return nil, err
+	}
+
+	return kr, nil
+}
+
+// Load reads all PEM encoded private keys from dir. If the directory holds no keys, a new one
+// is generated and written to it. Keys generated by later rotations are saved to dir as well.
+func Load(dir, algorithm string, verifyFor time.Duration) (*KeyRing, error) {
+	kr := &KeyRing{
+		Algorithm: algorithm,
+		VerifyFor: verifyFor,
+		Dir:       dir,
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		key, err := readKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("loading key %s: %w", file, err)
+		}
+		kr.keys = append(kr.keys, key)
+	}
+
+	sort.Slice(kr.keys, func(i, j int) bool {
+		return kr.keys[i].CreatedAt.Before(kr.keys[j].CreatedAt)
+	})
+
+	if len(kr.keys) == 0 {
+		err = kr.Rotate()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		kr.prune(time.Now())
+	}
+
+	return kr, nil
+}
+
+// Current returns the key used to sign new tokens.
+func (kr *KeyRing) Current() *Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	return kr.keys[len(kr.keys)-1]
+}
+
+// Keys returns every key that is still accepted for verification, oldest first.
+func (kr *KeyRing) Keys() []*Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	keys := make([]*Key, len(kr.keys))
+	copy(keys, kr.keys)
+	return keys
+}
+
+// Rotate generates a new signing key and retires the current one. Retired keys that have
+// outlived VerifyFor are dropped from the ring (and from Dir, if set).
+func (kr *KeyRing) Rotate() error {
+	key, err := generateKey(kr.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	if kr.Dir != "" {
+		err = writeKey(kr.Dir, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	kr.keys = append(kr.keys, key)
+	kr.prune(key.CreatedAt)
+
+	return nil
+}
+
+// StartRotation rotates the signing key every interval until the returned stop function is called.
+func (kr *KeyRing) StartRotation(interval time.Duration, onError func(error)) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := kr.Rotate(); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Sign signs claims with the current key, and sets the kid header so that verifiers can pick
+// the matching public key.
+func (kr *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	key := kr.Current()
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc resolves the verification key for a token by its kid header. It is meant to be
+// passed to jwt.Parse and friends.
+func (kr *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok || kid == "" {
+		return nil, errors.New("missing kid header")
+	}
+
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, key := range kr.keys {
+		if key.ID != kid {
+			continue
+		}
+		// never let the token choose a different algorithm than the one the key belongs to
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.Public(), nil
+	}
+
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// prune drops retired keys which are no longer needed for verification. Callers must hold the lock.
+func (kr *KeyRing) prune(now time.Time) {
+	var keep []*Key
+
+	for i, key := range kr.keys {
+		if i < len(kr.keys)-1 {
+			// a key was retired when its successor was created
+			retiredAt := kr.keys[i+1].CreatedAt
+			if now.Sub(retiredAt) > kr.VerifyFor {
+				if kr.Dir != "" {
+					_ = os.Remove(filepath.Join(kr.Dir, key.ID+".pem"))
+				}
+				continue
+			}
+		}
+		keep = append(keep, key)
+	}
+
+	kr.keys = keep
+}
+
+func generateKey(algorithm string) (*Key, error) {
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	method, err := methodFor(private)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		// the kid carries the creation time, so that keys loaded from disk can be ordered
+		ID:        fmt.Sprintf("%d-%s", now.UnixNano(), hex.EncodeToString(suffix)),
+		Method:    method,
+		Private:   private,
+		CreatedAt: now,
+	}, nil
+}
+
+func methodFor(private crypto.Signer) (jwt.SigningMethod, error) {
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+}
+
+func writeKey(dir string, key *Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+
+	block := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	return os.WriteFile(filepath.Join(dir, key.ID+".pem"), block, 0600)
+}
+
+func readKey(file string) (*Key, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	method, err := methodFor(private)
+	if err != nil {
+		return nil, err
+	}
+
+	id := strings.TrimSuffix(filepath.Base(file), ".pem")
+
+	createdAt, err := createdAtFromID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{
+		ID:        id,
+		Method:    method,
+		Private:   private,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func createdAtFromID(id string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed key id %q", id)
+	}
+
+	return time.Unix(0, nanos), nil
+}
@@ -0,0 +1,143 @@
+package keyring
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestKeyRing_SignAndVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		kty       string
+	}{
+		{"rsa", RS256, "RSA"},
+		{"ed25519", EdDSA, "OKP"},
+	}
+
+	for _, e := range tests {
+		kr, err := New(e.algorithm, time.Hour)
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		signed, err := kr.Sign(jwt.MapClaims{"sub": "1"})
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+
+		token, err := jwt.Parse(signed, kr.Keyfunc)
+		if err != nil {
+			t.Errorf("%s: expected token to verify, but got %s", e.name, err)
+		}
+
+		if token.Header["kid"] != kr.Current().ID {
+			t.Errorf("%s: expected kid %s, but got %v", e.name, kr.Current().ID, token.Header["kid"])
+		}
+
+		jwks := kr.JWKS()
+		if len(jwks.Keys) != 1 || jwks.Keys[0].Kty != e.kty {
+			t.Errorf("%s: expected one %s jwk, but got %+v", e.name, e.kty, jwks.Keys)
+		}
+	}
+}
+
+func TestKeyRing_Keyfunc(t *testing.T) {
+	kr, _ := New(RS256, time.Hour)
+	other, _ := New(RS256, time.Hour)
+
+	// token signed by a key we do not know
+	foreign, _ := other.Sign(jwt.MapClaims{"sub": "1"})
+	if _, err := jwt.Parse(foreign, kr.Keyfunc); err == nil {
+		t.Error("expected token signed by an unknown key to fail")
+	}
+
+	// token without a kid header
+	hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1"}).SignedString([]byte("secret"))
+	if _, err := jwt.Parse(hmac, kr.Keyfunc); err == nil {
+		t.Error("expected token without kid to fail")
+	}
+
+	// token claiming our kid, but with a different algorithm
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "1"})
+	forged.Header["kid"] = kr.Current().ID
+	signed, _ := forged.SignedString([]byte("secret"))
+	if _, err := jwt.Parse(signed, kr.Keyfunc); err == nil {
+		t.Error("expected token with mismatched algorithm to fail")
+	}
+}
+
+func TestKeyRing_Rotate(t *testing.T) {
+	kr, _ := New(EdDSA, time.Hour)
+
+	old, _ := kr.Sign(jwt.MapClaims{"sub": "1"})
+	oldID := kr.Current().ID
+
+	err := kr.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kr.Current().ID == oldID {
+		t.Error("expected a new current key after rotation")
+	}
+
+	// tokens signed by the retired key still verify
+	if _, err := jwt.Parse(old, kr.Keyfunc); err != nil {
+		t.Errorf("expected token signed before rotation to verify, but got %s", err)
+	}
+
+	if len(kr.JWKS().Keys) != 2 {
+		t.Errorf("expected 2 keys in jwks, but got %d", len(kr.JWKS().Keys))
+	}
+
+	// once the verification window has passed, retired keys are dropped; the key retired
+	// by this very rotation is still kept
+	kr.VerifyFor = 0
+	_ = kr.Rotate()
+
+	if len(kr.Keys()) != 2 {
+		t.Errorf("expected the oldest key to be pruned, but got %d keys", len(kr.Keys()))
+	}
+
+	if _, err := jwt.Parse(old, kr.Keyfunc); err == nil {
+		t.Error("expected token signed by a pruned key to fail")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := os.MkdirTemp("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kr, err := Load(dir, RS256, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = kr.Rotate()
+
+	signed, _ := kr.Sign(jwt.MapClaims{"sub": "1"})
+
+	// a second process loading the same directory can verify, and signs with the same key
+	reloaded, err := Load(dir, EdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(reloaded.Keys()) != 2 {
+		t.Errorf("expected 2 keys, but got %d", len(reloaded.Keys()))
+	}
+
+	if reloaded.Current().ID != kr.Current().ID {
+		t.Errorf("expected current key %s, but got %s", kr.Current().ID, reloaded.Current().ID)
+	}
+
+	if _, err := jwt.Parse(signed, reloaded.Keyfunc); err != nil {
+		t.Errorf("expected token to verify with reloaded keys, but got %s", err)
+	}
+}
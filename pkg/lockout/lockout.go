@@ -0,0 +1,172 @@
+package lockout
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// Audit log actions written by the guard.
+const (
+	ActionLockout = "login.lockout"
+	ActionUnlock  = "login.unlock"
+)
+
+// Policy decides how hard failed logins are punished. The first FreeAttempts failures of a key
+// cost nothing; every further failure blocks the key for BaseDelay, doubled per failure and capped
+// at MaxDelay. Once a key reaches LockoutAfter failures it is locked for LockoutDuration.
+type Policy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	ResetAfter      time.Duration // failures older than this are forgotten
+}
+
+// DefaultPolicy returns the policy used by the api and the web app.
+func DefaultPolicy() Policy {
+	return Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+		ResetAfter:      time.Hour,
+	}
+}
+
+// Delay returns how long a key is blocked after its nth failed login, and whether that is a lockout
+// rather than a backoff delay.
+func (p Policy) Delay(failures int) (time.Duration, bool) {
+	if p.LockoutAfter > 0 && failures >= p.LockoutAfter {
+		return p.LockoutDuration, true
+	}
+	if failures <= p.FreeAttempts {
+		return 0, false
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	return delay, false
+}
+
+// Guard applies a Policy to login attempts. Its state lives in the repository, so that every
+// instance of the api and the web app shares it.
+type Guard struct {
+	Repo   repository.DatabaseRepo
+	Policy Policy
+	Now    func() time.Time // defaults to time.Now
+}
+
+// AccountKey returns the throttle key for the account with the given email.
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPKey returns the throttle key for a client IP address.
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns how long the caller has to wait before it may try to log in with any of keys.
+// Zero means the attempt may go ahead.
+func (g *Guard) Check(keys ...string) (time.Duration, error) {
+	now := g.now()
+	var wait time.Duration
+
+	for _, key := range keys {
+		t, err := g.Repo.GetLoginThrottle(key)
+		if err != nil {
+			return 0, err
+		}
+		if t.IsLocked(now) && t.LockedUntil.Sub(now) > wait {
+			wait = t.LockedUntil.Sub(now)
+		}
+	}
+
+	return wait, nil
+}
+
+// Fail records a failed login for each of keys, blocking the ones that are over the policy's limits.
+// Lockouts are written to the audit log along with the client IP.
+func (g *Guard) Fail(ip string, keys ...string) error {
+	now := g.now()
+
+	for _, key := range keys {
+		t, err := g.Repo.RecordLoginFailure(key, now, now.Add(-g.Policy.ResetAfter))
+		if err != nil {
+			return err
+		}
+
+		delay, lockout := g.Policy.Delay(t.Failures)
+		if delay == 0 {
+			continue
+		}
+
+		err = g.Repo.LockLogin(key, now.Add(delay))
+		if err != nil {
+			return err
+		}
+
+		// only audit the failure that starts a lockout, not every failure while it lasts
+		if lockout && t.Failures == g.Policy.LockoutAfter {
+			err = g.Repo.InsertAuditEntry(data.AuditEntry{
+				Action:    ActionLockout,
+				Target:    key,
+				IP:        ip,
+				Details:   fmt.Sprintf("%d failed logins, locked until %s", t.Failures, now.Add(delay).Format(time.RFC3339)),
+				CreatedAt: now,
+			})
+			if err != nil {
+				log.Println("writing audit log:", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Succeed forgets the failed logins of keys after a successful login.
+func (g *Guard) Succeed(keys ...string) error {
+	for _, key := range keys {
+		err := g.Repo.ResetLoginThrottle(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Unlock lifts the lock on key before it runs out, on behalf of actor, and records that in the audit log.
+func (g *Guard) Unlock(key, actor, ip string) error {
+	err := g.Repo.ResetLoginThrottle(key)
+	if err != nil {
+		return err
+	}
+
+	return g.Repo.InsertAuditEntry(data.AuditEntry{
+		Actor:     actor,
+		Action:    ActionUnlock,
+		Target:    key,
+		IP:        ip,
+		CreatedAt: g.now(),
+	})
+}
+
+func (g *Guard) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,109 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func TestPolicy_Delay(t *testing.T) {
+	p := Policy{
+		FreeAttempts:    2,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Second,
+		LockoutAfter:    8,
+		LockoutDuration: time.Hour,
+	}
+
+	var tests = []struct {
+		failures      int
+		expectedDelay time.Duration
+		expectedLock  bool
+	}{
+		{1, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{5, 4 * time.Second, false},
+		{6, 5 * time.Second, false},
+		{7, 5 * time.Second, false},
+		{8, time.Hour, true},
+		{20, time.Hour, true},
+	}
+
+	for _, e := range tests {
+		delay, lock := p.Delay(e.failures)
+		if delay != e.expectedDelay || lock != e.expectedLock {
+			t.Errorf("%d failures: expected %s/%t but got %s/%t", e.failures, e.expectedDelay, e.expectedLock, delay, lock)
+		}
+	}
+}
+
+func TestGuard(t *testing.T) {
+	repo := &dbrepo.TestDBRepo{}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	g := &Guard{
+		Repo: repo,
+		Policy: Policy{
+			FreeAttempts:    1,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Second,
+			LockoutAfter:    3,
+			LockoutDuration: time.Minute,
+			ResetAfter:      time.Hour,
+		},
+		Now: func() time.Time { return now },
+	}
+
+	account := AccountKey("Admin@Example.com")
+	ip := IPKey("127.0.0.1")
+
+	_ = g.Fail("127.0.0.1", account, ip)
+	if wait, _ := g.Check(account, ip); wait != 0 {
+		t.Errorf("expected no wait after a free attempt, got %s", wait)
+	}
+
+	_ = g.Fail("127.0.0.1", account, ip)
+	if wait, _ := g.Check(account, ip); wait != time.Second {
+		t.Errorf("expected to wait 1s, got %s", wait)
+	}
+
+	now = now.Add(2 * time.Second)
+	if wait, _ := g.Check(account); wait != 0 {
+		t.Errorf("expected the delay to run out, got %s", wait)
+	}
+
+	_ = g.Fail("127.0.0.1", account, ip)
+	if wait, _ := g.Check(account); wait != time.Minute {
+		t.Errorf("expected a lockout of 1m, got %s", wait)
+	}
+
+	entries := repo.AuditLog()
+	if len(entries) != 2 || entries[0].Action != ActionLockout {
+		t.Fatalf("expected the lockout of both keys to be audited, got %v", entries)
+	}
+
+	err := g.Unlock(account, "1", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait, _ := g.Check(account); wait != 0 {
+		t.Errorf("expected the account to be unlocked, got %s", wait)
+	}
+	if wait, _ := g.Check(ip); wait == 0 {
+		t.Error("expected the ip to stay locked")
+	}
+
+	entries = repo.AuditLog()
+	if entries[len(entries)-1].Action != ActionUnlock || entries[len(entries)-1].Actor != "1" {
+		t.Errorf("expected the unlock to be audited, got %v", entries[len(entries)-1])
+	}
+
+	// failures older than ResetAfter are forgotten
+	now = now.Add(2 * time.Hour)
+	_ = g.Fail("127.0.0.1", ip)
+	if wait, _ := g.Check(ip); wait != 0 {
+		t.Errorf("expected old failures to be forgotten, got %s", wait)
+	}
+}
@@ -0,0 +1,164 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported mailers, as chosen on the command line.
+const (
+	KindLog  = "log"
+	KindFile = "file"
+	KindSMTP = "smtp"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Config selects and configures a Mailer.
+type Config struct {
+	Kind         string `yaml:"kind"` // log, file or smtp
+	From         string `yaml:"from"`
+	Dir          string `yaml:"dir"`       // where the file mailer writes to
+	SMTPAddr     string `yaml:"smtp_addr"` // host:port of the smtp server
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+}
+
+// New returns the mailer described by c.
+func New(c Config) (Mailer, error) {
+	switch c.Kind {
+	case KindLog:
+		return &LogMailer{From: c.From}, nil
+	case KindFile:
+		return &FileMailer{Dir: c.Dir, From: c.From}, nil
+	case KindSMTP:
+		return &SMTPMailer{Addr: c.SMTPAddr, From: c.From, Username: c.SMTPUsername, Password: c.SMTPPassword}, nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", c.Kind)
+	}
+}
+
+// LogMailer writes emails to the log instead of sending them. It is meant for local development.
+type LogMailer struct {
+	From   string
+	Logger *log.Logger // defaults to the standard logger
+}
+
+// Send logs msg
+func (m *LogMailer) Send(msg Message) error {
+	logger := m.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	logger.Printf("mail from %s to %s: %s\n%s", m.From, msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes every email to its own .eml file in Dir, where it can be opened with a mail
+// client. It is meant for local development.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Send writes msg to a new file
+func (m *FileMailer) Send(msg Message) error {
+	err := os.MkdirAll(m.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%d-%s.eml", now.UnixNano(), sanitize(msg.To))
+
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg, now), 0600)
+}
+
+// SMTPMailer sends emails through an SMTP server. Without a username, it sends without authentication.
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+// Send sends msg
+func (m *SMTPMailer) Send(msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		host := m.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, format(m.From, msg, time.Now()))
+}
+
+// MemoryMailer keeps emails in memory instead of sending them; it lets tests read what was sent.
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []Message
+}
+
+// Send records msg
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Sent returns every email sent so far, oldest first.
+func (m *MemoryMailer) Sent() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sent := make([]Message, len(m.sent))
+	copy(sent, m.sent)
+	return sent
+}
+
+// format renders msg in internet message format
+func format(from string, msg Message, date time.Time) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, s)
+}
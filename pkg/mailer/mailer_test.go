@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileMailer_Send(t *testing.T) {
+	dir := t.TempDir()
+	m := &FileMailer{Dir: dir, From: "no-reply@example.com"}
+
+	err := m.Send(Message{To: "admin@example.com", Subject: "Hello", Body: "line 1\nline 2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one mail file, got %d", len(files))
+	}
+
+	content, _ := os.ReadFile(files[0])
+	for _, expected := range []string{"From: no-reply@example.com\r\n", "To: admin@example.com\r\n", "Subject: Hello\r\n", "\r\n\r\nline 1\r\nline 2"} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("expected mail to contain %q, got %q", expected, content)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, kind := range []string{KindLog, KindFile, KindSMTP} {
+		if _, err := New(Config{Kind: kind}); err != nil {
+			t.Errorf("%s: unexpected error %s", kind, err)
+		}
+	}
+
+	if _, err := New(Config{Kind: "pigeon"}); err == nil {
+		t.Error("expected an error for an unknown mailer")
+	}
+}
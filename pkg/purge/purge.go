@@ -0,0 +1,63 @@
+// Package purge removes deleted users for good once their retention period is over, along with
+// the profile pictures nobody else uses.
+package purge
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+	"webapp/pkg/repository"
+)
+
+// Purger removes the users deleted more than Retention ago, and their image files in UploadDir.
+type Purger struct {
+	Repo      repository.DatabaseRepo
+	UploadDir string
+	Retention time.Duration
+}
+
+// Purge removes the users deleted before now minus the retention period, and returns how many
+// image files it removed. Files which are already gone are not an error.
+func (p *Purger) Purge(now time.Time) (int, error) {
+	files, err := p.Repo.PurgeDeletedUsers(now.Add(-p.Retention))
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, file := range files {
+		// file names come from uploads; never let one point outside the upload directory
+		err = os.Remove(filepath.Join(p.UploadDir, filepath.Base(file)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// Start purges every interval until the returned stop function is called.
+func (p *Purger) Start(interval time.Duration, onError func(error)) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case now := <-ticker.C:
+				if _, err := p.Purge(now); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
@@ -0,0 +1,64 @@
+package purge
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+	"webapp/pkg/repository/dbrepo"
+)
+
+func TestPurger_Purge(t *testing.T) {
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	for _, name := range []string{"old.png", "shared.png", "recent.png", "kept.png"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("img"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo := &dbrepo.TestDBRepo{}
+	repo.AddUsers(
+		data.User{ID: 2, Email: "old@example.com", DeletedAt: now.Add(-40 * 24 * time.Hour), ProfilePic: data.UserImage{FileName: "old.png"}},
+		data.User{ID: 3, Email: "shared@example.com", DeletedAt: now.Add(-40 * 24 * time.Hour), ProfilePic: data.UserImage{FileName: "shared.png"}},
+		data.User{ID: 4, Email: "gone@example.com", DeletedAt: now.Add(-31 * 24 * time.Hour), ProfilePic: data.UserImage{FileName: "missing.png"}},
+		data.User{ID: 5, Email: "recent@example.com", DeletedAt: now.Add(-24 * time.Hour), ProfilePic: data.UserImage{FileName: "recent.png"}},
+		data.User{ID: 6, Email: "kept@example.com", ProfilePic: data.UserImage{FileName: "kept.png"}},
+		data.User{ID: 7, Email: "shares@example.com", ProfilePic: data.UserImage{FileName: "shared.png"}},
+	)
+
+	p := &Purger{Repo: repo, UploadDir: dir, Retention: 30 * 24 * time.Hour}
+
+	removed, err := p.Purge(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 file to be removed but got %d", removed)
+	}
+
+	for name, exists := range map[string]bool{"old.png": false, "shared.png": true, "recent.png": true, "kept.png": true} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists != (err == nil) {
+			t.Errorf("%s: expected exists to be %t but got error %v", name, exists, err)
+		}
+	}
+
+	page, err := repo.ListUsers(repository.UserQuery{Deleted: true, Sort: "id", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Users) != 1 || page.Users[0].ID != 5 {
+		t.Errorf("expected only user 5 to be left deleted but got %d users", len(page.Users))
+	}
+
+	// a second run has nothing left to do
+	removed, err = p.Purge(now)
+	if err != nil || removed != 0 {
+		t.Errorf("expected nothing to be removed on the second run but got %d, %v", removed, err)
+	}
+}
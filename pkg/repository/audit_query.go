@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+)
+
+// Page sizes of ListAuditEntries.
+const (
+	DefaultAuditLimit = 50
+	MaxAuditLimit     = 500
+)
+
+// AuditQuery filters and pages the entries returned by ListAuditEntries, newest first. Zero
+// values don't filter.
+type AuditQuery struct {
+	Actor         string
+	Action        string // an action, or a prefix ending in a dot like "user." for a group of them
+	Target        string
+	CreatedAfter  time.Time // inclusive
+	CreatedBefore time.Time // exclusive
+
+	Limit    int
+	BeforeID int // keyset pagination: only return entries older than this one
+}
+
+// Validate checks the limit and cursor of q.
+func (q AuditQuery) Validate() error {
+	if q.Limit < 1 || q.Limit > MaxAuditLimit {
+		return errors.New("limit must be between 1 and " + strconv.Itoa(MaxAuditLimit))
+	}
+	if q.BeforeID < 0 {
+		return errors.New("before must not be negative")
+	}
+	return nil
+}
+
+// MatchesAction reports whether action is the action of q, or one of its group.
+func (q AuditQuery) MatchesAction(action string) bool {
+	if strings.HasSuffix(q.Action, ".") {
+		return strings.HasPrefix(action, q.Action)
+	}
+	return q.Action == "" || q.Action == action
+}
+
+// AuditPage is one page of audit entries.
+type AuditPage struct {
+	Entries []data.AuditEntry
+	Next    int // the BeforeID of the next page; 0 on the last page
+}
@@ -0,0 +1,148 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+)
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at, expires_at, revoked_at`
+
+// InsertAPIKey records a new API key, and returns its id
+func (m *PostgresDBRepo) InsertAPIKey(k data.APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var expiresAt sql.NullTime
+	if !k.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: k.ExpiresAt, Valid: true}
+	}
+
+	var newID int
+	stmt := `insert into api_keys (user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		k.UserID,
+		k.Name,
+		k.Prefix,
+		k.KeyHash,
+		strings.Join(k.Scopes, ","),
+		time.Now(),
+		expiresAt,
+	).Scan(&newID)
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	return newID, nil
+}
+
+// GetAPIKey returns an API key by id
+func (m *PostgresDBRepo) GetAPIKey(id int) (*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys where id = $1`
+
+	return scanAPIKey(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetAPIKeyByHash returns the API key with the given hash
+func (m *PostgresDBRepo) GetAPIKeyByHash(keyHash string) (*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys where key_hash = $1`
+
+	return scanAPIKey(m.DB.QueryRowContext(ctx, query, keyHash))
+}
+
+// ListAPIKeys returns all API keys of a user, newest first
+func (m *PostgresDBRepo) ListAPIKeys(userID int) ([]*data.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys where user_id = $1 order by created_at desc, id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var keys []*data.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, translateError(rows.Err())
+}
+
+// RevokeAPIKey revokes an API key for good
+func (m *PostgresDBRepo) RevokeAPIKey(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = $1 where id = $2 and revoked_at is null`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// TouchAPIKey records when an API key was last used
+func (m *PostgresDBRepo) TouchAPIKey(id int, usedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update api_keys set last_used_at = $1 where id = $2`, usedAt, id)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row scanner) (*data.APIKey, error) {
+	var k data.APIKey
+	var scopes string
+	var lastUsedAt, expiresAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&k.ID,
+		&k.UserID,
+		&k.Name,
+		&k.Prefix,
+		&k.KeyHash,
+		&scopes,
+		&k.CreatedAt,
+		&lastUsedAt,
+		&expiresAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	k.Scopes = splitList(scopes)
+	k.LastUsedAt = lastUsedAt.Time
+	k.ExpiresAt = expiresAt.Time
+	k.RevokedAt = revokedAt.Time
+
+	return &k, nil
+}
@@ -0,0 +1,95 @@
+package dbrepo
+
+import (
+	"sort"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertAPIKey records a new API key, and returns its id
+func (m *TestDBRepo) InsertAPIKey(k data.APIKey) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.apiKeys == nil {
+		m.apiKeys = make(map[int]*data.APIKey)
+	}
+
+	k.ID = len(m.apiKeys) + 1
+	k.CreatedAt = time.Now()
+	m.apiKeys[k.ID] = &k
+
+	return k.ID, nil
+}
+
+// GetAPIKey returns an API key by id
+func (m *TestDBRepo) GetAPIKey(id int) (*data.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.apiKeys[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	key := *k
+	return &key, nil
+}
+
+// GetAPIKeyByHash returns the API key with the given hash
+func (m *TestDBRepo) GetAPIKeyByHash(keyHash string) (*data.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.apiKeys {
+		if k.KeyHash == keyHash {
+			key := *k
+			return &key, nil
+		}
+	}
+
+	return nil, repository.ErrNotFound
+}
+
+// ListAPIKeys returns all API keys of a user, newest first
+func (m *TestDBRepo) ListAPIKeys(userID int) ([]*data.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []*data.APIKey
+	for _, k := range m.apiKeys {
+		if k.UserID == userID {
+			key := *k
+			keys = append(keys, &key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes an API key for good
+func (m *TestDBRepo) RevokeAPIKey(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if k, ok := m.apiKeys[id]; ok && k.RevokedAt.IsZero() {
+		k.RevokedAt = time.Now()
+	}
+
+	return nil
+}
+
+// TouchAPIKey records when an API key was last used
+func (m *TestDBRepo) TouchAPIKey(id int, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if k, ok := m.apiKeys[id]; ok {
+		k.LastUsedAt = usedAt
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertAuditEntry appends an entry to the audit log
+func (m *PostgresDBRepo) InsertAuditEntry(e data.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	var changes []byte
+	if len(e.Changes) > 0 {
+		var err error
+		changes, err = json.Marshal(e.Changes)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt := `insert into audit_log (actor, action, target, ip, details, changes, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		e.Actor,
+		e.Action,
+		e.Target,
+		e.IP,
+		e.Details,
+		changes,
+		e.CreatedAt,
+	)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// ListAuditEntries returns one page of the audit entries matching q, newest first
+func (m *PostgresDBRepo) ListAuditEntries(q repository.AuditQuery) (*repository.AuditPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := q.Validate()
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := []string{"true"}
+	if q.Actor != "" {
+		where = append(where, "actor = "+arg(q.Actor))
+	}
+	if strings.HasSuffix(q.Action, ".") {
+		where = append(where, `action like `+arg(likeEscaper.Replace(q.Action)+"%")+` escape '\'`)
+	} else if q.Action != "" {
+		where = append(where, "action = "+arg(q.Action))
+	}
+	if q.Target != "" {
+		where = append(where, "target = "+arg(q.Target))
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "created_at >= "+arg(q.CreatedAfter))
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "created_at < "+arg(q.CreatedBefore))
+	}
+	if q.BeforeID > 0 {
+		where = append(where, "id < "+arg(q.BeforeID))
+	}
+
+	// fetch one more entry than asked for, to know whether there is a next page
+	query := `select id, coalesce(actor, ''), action, coalesce(target, ''), coalesce(ip, ''), coalesce(details, ''), changes, created_at
+		from audit_log where ` + strings.Join(where, " and ") + `
+		order by id desc limit ` + arg(q.Limit+1)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	page := repository.AuditPage{Entries: []data.AuditEntry{}}
+
+	for rows.Next() {
+		var e data.AuditEntry
+		var changes sql.NullString
+		err := rows.Scan(
+			&e.ID,
+			&e.Actor,
+			&e.Action,
+			&e.Target,
+			&e.IP,
+			&e.Details,
+			&changes,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return nil, translateError(err)
+		}
+
+		if changes.Valid {
+			err = json.Unmarshal([]byte(changes.String), &e.Changes)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		page.Entries = append(page.Entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	if len(page.Entries) > q.Limit {
+		page.Entries = page.Entries[:q.Limit]
+		page.Next = page.Entries[q.Limit-1].ID
+	}
+
+	return &page, nil
+}
@@ -0,0 +1,63 @@
+package dbrepo
+
+import (
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertAuditEntry appends an entry to the audit log
+func (m *TestDBRepo) InsertAuditEntry(e data.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	e.ID = len(m.auditLog) + 1
+	m.auditLog = append(m.auditLog, e)
+
+	return nil
+}
+
+// AuditLog returns everything written to the audit log; it lets tests check what was recorded.
+func (m *TestDBRepo) AuditLog() []data.AuditEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries := make([]data.AuditEntry, len(m.auditLog))
+	copy(entries, m.auditLog)
+	return entries
+}
+
+// ListAuditEntries returns one page of the audit entries matching q, newest first
+func (m *TestDBRepo) ListAuditEntries(q repository.AuditQuery) (*repository.AuditPage, error) {
+	err := q.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := m.AuditLog()
+	page := repository.AuditPage{Entries: []data.AuditEntry{}}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		switch {
+		case q.BeforeID > 0 && e.ID >= q.BeforeID,
+			q.Actor != "" && e.Actor != q.Actor,
+			!q.MatchesAction(e.Action),
+			q.Target != "" && e.Target != q.Target,
+			!q.CreatedAfter.IsZero() && e.CreatedAt.Before(q.CreatedAfter),
+			!q.CreatedBefore.IsZero() && !e.CreatedAt.Before(q.CreatedBefore):
+			continue
+		}
+
+		if len(page.Entries) == q.Limit {
+			page.Next = page.Entries[q.Limit-1].ID
+			break
+		}
+		page.Entries = append(page.Entries, e)
+	}
+
+	return &page, nil
+}
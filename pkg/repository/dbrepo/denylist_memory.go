@@ -0,0 +1,64 @@
+package dbrepo
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryDenylist is a repository.TokenDenylist kept in process memory. It is meant for tests and
+// single instance deployments; revocations are lost on restart.
+type MemoryDenylist struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	users  map[int]time.Time
+}
+
+// DenyToken revokes the access token jti until expiresAt
+func (m *MemoryDenylist) DenyToken(jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.tokens == nil {
+		m.tokens = make(map[string]time.Time)
+	}
+
+	// entries for tokens that have expired by now are of no use anymore
+	now := time.Now()
+	for id, expiry := range m.tokens {
+		if expiry.Before(now) {
+			delete(m.tokens, id)
+		}
+	}
+
+	m.tokens[jti] = expiresAt
+	return nil
+}
+
+// DenyUser revokes all access tokens issued to the user before issuedBefore
+func (m *MemoryDenylist) DenyUser(userID int, issuedBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.users == nil {
+		m.users = make(map[int]time.Time)
+	}
+
+	m.users[userID] = issuedBefore
+	return nil
+}
+
+// IsDenied reports whether an access token was revoked, either by itself or along with all tokens of its user
+func (m *MemoryDenylist) IsDenied(jti string, userID int, issuedAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tokens[jti]; ok {
+		return true, nil
+	}
+
+	if revokedBefore, ok := m.users[userID]; ok && issuedAt.Before(revokedBefore) {
+		return true, nil
+	}
+
+	return false, nil
+}
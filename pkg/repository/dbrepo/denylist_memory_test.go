@@ -0,0 +1,51 @@
+package dbrepo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryDenylist(t *testing.T) {
+	var denylist MemoryDenylist
+	now := time.Now()
+
+	denied, _ := denylist.IsDenied("a", 1, now)
+	if denied {
+		t.Error("empty denylist denied a token")
+	}
+
+	_ = denylist.DenyToken("a", now.Add(time.Minute))
+
+	denied, _ = denylist.IsDenied("a", 1, now)
+	if !denied {
+		t.Error("expected token a to be denied")
+	}
+
+	denied, _ = denylist.IsDenied("b", 1, now)
+	if denied {
+		t.Error("expected token b not to be denied")
+	}
+
+	_ = denylist.DenyUser(2, now)
+
+	denied, _ = denylist.IsDenied("c", 2, now.Add(-time.Minute))
+	if !denied {
+		t.Error("expected token issued before the user revocation to be denied")
+	}
+
+	denied, _ = denylist.IsDenied("d", 2, now.Add(time.Minute))
+	if denied {
+		t.Error("expected token issued after the user revocation not to be denied")
+	}
+
+	// tokens issued just before the revocation are denied, even within the same second
+	denied, _ = denylist.IsDenied("e", 2, now.Add(-time.Microsecond))
+	if !denied {
+		t.Error("expected token issued right before the user revocation to be denied")
+	}
+
+	denied, _ = denylist.IsDenied("f", 2, now.Add(time.Microsecond))
+	if denied {
+		t.Error("expected token issued right after the user revocation not to be denied")
+	}
+}
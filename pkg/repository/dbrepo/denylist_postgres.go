@@ -0,0 +1,75 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresDenylist is a repository.TokenDenylist shared by every api instance using the same database.
+type PostgresDenylist struct {
+	DB *sql.DB
+}
+
+// DenyToken revokes the access token jti until expiresAt
+func (m *PostgresDenylist) DenyToken(jti string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// entries for tokens that have expired by now are of no use anymore
+	stmt := `delete from revoked_tokens where expires_at < $1`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now())
+	if err != nil {
+		return err
+	}
+
+	stmt = `insert into revoked_tokens (jti, expires_at) values ($1, $2)
+		on conflict (jti) do nothing`
+	_, err = m.DB.ExecContext(ctx, stmt, jti, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DenyUser revokes all access tokens issued to the user before issuedBefore
+func (m *PostgresDenylist) DenyUser(userID int, issuedBefore time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into revoked_users (user_id, revoked_before) values ($1, $2)
+		on conflict (user_id) do update set revoked_before = excluded.revoked_before`
+
+	// the database keeps microseconds; rounding up still revokes every token issued before
+	cutoff := issuedBefore.Truncate(time.Microsecond)
+	if cutoff.Before(issuedBefore) {
+		cutoff = cutoff.Add(time.Microsecond)
+	}
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, cutoff)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// IsDenied reports whether an access token was revoked, either by itself or along with all tokens of its user
+func (m *PostgresDenylist) IsDenied(jti string, userID int, issuedAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		select
+			exists (select 1 from revoked_tokens where jti = $1)
+			or exists (select 1 from revoked_users where user_id = $2 and revoked_before > $3)`
+
+	var denied bool
+	err := m.DB.QueryRowContext(ctx, query, jti, userID, issuedAt.Truncate(time.Microsecond)).Scan(&denied)
+	if err != nil {
+		return false, err
+	}
+
+	return denied, nil
+}
@@ -0,0 +1,58 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"webapp/pkg/repository"
+
+	"github.com/jackc/pgconn"
+)
+
+// Postgres error codes translated by translateError, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pgUniqueViolation      = "23505"
+	pgForeignKeyViolation  = "23503"
+	pgNotNullViolation     = "23502"
+	pgCheckViolation       = "23514"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+// emailConstraint is the unique index on the email addresses of the users not deleted.
+const emailConstraint = "users_email_key"
+
+// translateError turns sql.ErrNoRows and postgres constraint errors into the errors of the
+// repository package. Other errors are returned as they are.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return repository.ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case pgUniqueViolation:
+		if pgErr.ConstraintName == emailConstraint {
+			return &repository.ConstraintError{Kind: repository.ErrDuplicateEmail, Constraint: pgErr.ConstraintName}
+		}
+		return &repository.ConstraintError{Kind: repository.ErrConflict, Constraint: pgErr.ConstraintName}
+	case pgForeignKeyViolation, pgNotNullViolation, pgCheckViolation:
+		constraint := pgErr.ConstraintName
+		if constraint == "" {
+			constraint = pgErr.ColumnName
+		}
+		return &repository.ConstraintError{Kind: repository.ErrConstraint, Constraint: constraint}
+	case pgSerializationFailure, pgDeadlockDetected:
+		return repository.ErrConflict
+	}
+
+	return err
+}
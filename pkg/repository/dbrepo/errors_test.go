@@ -0,0 +1,56 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"webapp/pkg/repository"
+
+	"github.com/jackc/pgconn"
+)
+
+func Test_translateError(t *testing.T) {
+	plain := errors.New("connection refused")
+
+	var tests = []struct {
+		name       string
+		err        error
+		expected   error
+		constraint string
+	}{
+		{"nil", nil, nil, ""},
+		{"no rows", sql.ErrNoRows, repository.ErrNotFound, ""},
+		{"wrapped no rows", fmt.Errorf("scanning: %w", sql.ErrNoRows), repository.ErrNotFound, ""},
+		{"duplicate email", &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: emailConstraint}, repository.ErrDuplicateEmail, emailConstraint},
+		{"other unique violation", &pgconn.PgError{Code: pgUniqueViolation, ConstraintName: "roles_name_key"}, repository.ErrConflict, "roles_name_key"},
+		{"foreign key", &pgconn.PgError{Code: pgForeignKeyViolation, ConstraintName: "api_keys_user_id_fkey"}, repository.ErrConstraint, "api_keys_user_id_fkey"},
+		{"not null", &pgconn.PgError{Code: pgNotNullViolation, ColumnName: "email"}, repository.ErrConstraint, "email"},
+		{"serialization failure", &pgconn.PgError{Code: pgSerializationFailure}, repository.ErrConflict, ""},
+		{"other postgres error", &pgconn.PgError{Code: "42P01"}, nil, ""},
+		{"other error", plain, plain, ""},
+	}
+
+	for _, e := range tests {
+		err := translateError(e.err)
+
+		if e.expected == nil {
+			if e.err == nil && err != nil {
+				t.Errorf("%s: expected nil, but got %v", e.name, err)
+			}
+			if e.err != nil && err != e.err {
+				t.Errorf("%s: expected the error to pass through, but got %v", e.name, err)
+			}
+			continue
+		}
+
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expected, err)
+		}
+
+		var constraintErr *repository.ConstraintError
+		if errors.As(err, &constraintErr) && constraintErr.Constraint != e.constraint {
+			t.Errorf("%s: expected constraint %q, but got %q", e.name, e.constraint, constraintErr.Constraint)
+		}
+	}
+}
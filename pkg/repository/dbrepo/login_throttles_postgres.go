@@ -0,0 +1,93 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"webapp/pkg/data"
+)
+
+// GetLoginThrottle returns the failed login state of a key. Keys without failed logins get an empty throttle.
+func (m *PostgresDBRepo) GetLoginThrottle(key string) (*data.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select key, failures, last_failure, locked_until from login_throttles where key = $1`
+
+	t, err := scanLoginThrottle(m.DB.QueryRowContext(ctx, query, key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return &data.LoginThrottle{Key: key}, nil
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return t, nil
+}
+
+// RecordLoginFailure counts one more failed login for key, and returns the updated state. Failures
+// from before forgetBefore are forgotten, so the count starts over.
+func (m *PostgresDBRepo) RecordLoginFailure(key string, at, forgetBefore time.Time) (*data.LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into login_throttles (key, failures, last_failure)
+		values ($1, 1, $2)
+		on conflict (key) do update set
+			failures = case when login_throttles.last_failure < $3 then 1 else login_throttles.failures + 1 end,
+			last_failure = excluded.last_failure
+		returning key, failures, last_failure, locked_until`
+
+	return scanLoginThrottle(m.DB.QueryRowContext(ctx, stmt, key, at, forgetBefore))
+}
+
+// LockLogin blocks logins for key until the given time
+func (m *PostgresDBRepo) LockLogin(key string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into login_throttles (key, failures, locked_until)
+		values ($1, 0, $2)
+		on conflict (key) do update set locked_until = excluded.locked_until`
+
+	_, err := m.DB.ExecContext(ctx, stmt, key, until)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// ResetLoginThrottle forgets all failed logins of key, and lifts any lock
+func (m *PostgresDBRepo) ResetLoginThrottle(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from login_throttles where key = $1`, key)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+func scanLoginThrottle(row *sql.Row) (*data.LoginThrottle, error) {
+	var t data.LoginThrottle
+	var lastFailure, lockedUntil sql.NullTime
+
+	err := row.Scan(
+		&t.Key,
+		&t.Failures,
+		&lastFailure,
+		&lockedUntil,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	t.LastFailure = lastFailure.Time
+	t.LockedUntil = lockedUntil.Time
+
+	return &t, nil
+}
@@ -0,0 +1,73 @@
+package dbrepo
+
+import (
+	"time"
+	"webapp/pkg/data"
+)
+
+// GetLoginThrottle returns the failed login state of a key
+func (m *TestDBRepo) GetLoginThrottle(key string) (*data.LoginThrottle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.loginThrottles[key]
+	if !ok {
+		return &data.LoginThrottle{Key: key}, nil
+	}
+
+	throttle := *t
+	return &throttle, nil
+}
+
+// RecordLoginFailure counts one more failed login for key
+func (m *TestDBRepo) RecordLoginFailure(key string, at, forgetBefore time.Time) (*data.LoginThrottle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.loginThrottles == nil {
+		m.loginThrottles = make(map[string]*data.LoginThrottle)
+	}
+
+	t, ok := m.loginThrottles[key]
+	if !ok {
+		t = &data.LoginThrottle{Key: key}
+		m.loginThrottles[key] = t
+	}
+
+	if t.LastFailure.Before(forgetBefore) {
+		t.Failures = 0
+	}
+	t.Failures++
+	t.LastFailure = at
+
+	throttle := *t
+	return &throttle, nil
+}
+
+// LockLogin blocks logins for key until the given time
+func (m *TestDBRepo) LockLogin(key string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.loginThrottles == nil {
+		m.loginThrottles = make(map[string]*data.LoginThrottle)
+	}
+
+	t, ok := m.loginThrottles[key]
+	if !ok {
+		t = &data.LoginThrottle{Key: key}
+		m.loginThrottles[key] = t
+	}
+	t.LockedUntil = until
+
+	return nil
+}
+
+// ResetLoginThrottle forgets all failed logins of key
+func (m *TestDBRepo) ResetLoginThrottle(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.loginThrottles, key)
+	return nil
+}
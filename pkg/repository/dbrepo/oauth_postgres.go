@@ -0,0 +1,120 @@
+package dbrepo
+
+import (
+	"context"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+)
+
+// InsertOAuthClient registers a new OAuth client
+func (m *PostgresDBRepo) InsertOAuthClient(c data.OAuthClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into oauth_clients (id, secret_hash, name, redirect_uris, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		c.ID,
+		c.SecretHash,
+		c.Name,
+		strings.Join(c.RedirectURIs, "\n"),
+		time.Now(),
+	)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// GetOAuthClient returns one OAuth client by its client id
+func (m *PostgresDBRepo) GetOAuthClient(id string) (*data.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		select
+			id, coalesce(secret_hash, ''), coalesce(name, ''), coalesce(redirect_uris, ''), created_at
+		from
+			oauth_clients
+		where
+			id = $1`
+
+	var c data.OAuthClient
+	var redirectURIs string
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&c.ID,
+		&c.SecretHash,
+		&c.Name,
+		&redirectURIs,
+		&c.CreatedAt,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if redirectURIs != "" {
+		c.RedirectURIs = strings.Split(redirectURIs, "\n")
+	}
+
+	return &c, nil
+}
+
+// InsertAuthorizationCode stores a newly issued authorization code
+func (m *PostgresDBRepo) InsertAuthorizationCode(c data.AuthorizationCode) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into oauth_authorization_codes
+		(code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		c.CodeHash,
+		c.ClientID,
+		c.UserID,
+		c.RedirectURI,
+		c.Scope,
+		c.Nonce,
+		c.CodeChallenge,
+		c.CodeChallengeMethod,
+		c.ExpiresAt,
+	)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// ConsumeAuthorizationCode deletes an authorization code and returns it, so that every code can be
+// exchanged at most once
+func (m *PostgresDBRepo) ConsumeAuthorizationCode(codeHash string) (*data.AuthorizationCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from oauth_authorization_codes where code_hash = $1
+		returning code_hash, client_id, user_id, coalesce(redirect_uri, ''), coalesce(scope, ''),
+			coalesce(nonce, ''), coalesce(code_challenge, ''), coalesce(code_challenge_method, ''), expires_at`
+
+	var c data.AuthorizationCode
+	err := m.DB.QueryRowContext(ctx, stmt, codeHash).Scan(
+		&c.CodeHash,
+		&c.ClientID,
+		&c.UserID,
+		&c.RedirectURI,
+		&c.Scope,
+		&c.Nonce,
+		&c.CodeChallenge,
+		&c.CodeChallengeMethod,
+		&c.ExpiresAt,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return &c, nil
+}
@@ -0,0 +1,60 @@
+package dbrepo
+
+import (
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertOAuthClient registers a new OAuth client
+func (m *TestDBRepo) InsertOAuthClient(c data.OAuthClient) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.oauthClients == nil {
+		m.oauthClients = make(map[string]*data.OAuthClient)
+	}
+	m.oauthClients[c.ID] = &c
+
+	return nil
+}
+
+// GetOAuthClient returns one OAuth client by its client id
+func (m *TestDBRepo) GetOAuthClient(id string) (*data.OAuthClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.oauthClients[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	client := *c
+	return &client, nil
+}
+
+// InsertAuthorizationCode stores a newly issued authorization code
+func (m *TestDBRepo) InsertAuthorizationCode(c data.AuthorizationCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.authorizationCodes == nil {
+		m.authorizationCodes = make(map[string]*data.AuthorizationCode)
+	}
+	m.authorizationCodes[c.CodeHash] = &c
+
+	return nil
+}
+
+// ConsumeAuthorizationCode deletes an authorization code and returns it
+func (m *TestDBRepo) ConsumeAuthorizationCode(codeHash string) (*data.AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.authorizationCodes[codeHash]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	delete(m.authorizationCodes, codeHash)
+
+	return c, nil
+}
@@ -0,0 +1,145 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertRefreshToken records a newly issued refresh token
+func (m *PostgresDBRepo) InsertRefreshToken(t data.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into refresh_tokens (id, user_id, family_id, device, issued_at, expires_at)
+		values ($1, $2, $3, $4, $5, $6)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		t.ID,
+		t.UserID,
+		t.FamilyID,
+		t.Device,
+		t.IssuedAt,
+		t.ExpiresAt,
+	)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// GetRefreshToken returns one refresh token by id
+func (m *PostgresDBRepo) GetRefreshToken(id string) (*data.RefreshToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		select
+			id, user_id, family_id, coalesce(device, ''), issued_at, expires_at, used_at, revoked_at
+		from
+			refresh_tokens
+		where
+			id = $1`
+
+	var t data.RefreshToken
+	var usedAt, revokedAt sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.FamilyID,
+		&t.Device,
+		&t.IssuedAt,
+		&t.ExpiresAt,
+		&usedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	t.UsedAt = usedAt.Time
+	t.RevokedAt = revokedAt.Time
+
+	return &t, nil
+}
+
+// RotateRefreshToken marks the refresh token oldID as used and records next, its successor, in
+// one transaction. It returns repository.ErrRefreshTokenUsed if oldID was already used or revoked,
+// which means the token has been replayed.
+func (m *PostgresDBRepo) RotateRefreshToken(oldID string, next data.RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	stmt := `update refresh_tokens set used_at = $1
+		where id = $2 and used_at is null and revoked_at is null`
+
+	result, err := tx.ExecContext(ctx, stmt, time.Now(), oldID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return translateError(err)
+	}
+	if rows == 0 {
+		return repository.ErrRefreshTokenUsed
+	}
+
+	stmt = `insert into refresh_tokens (id, user_id, family_id, device, issued_at, expires_at)
+		values ($1, $2, $3, $4, $5, $6)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		next.ID,
+		next.UserID,
+		next.FamilyID,
+		next.Device,
+		next.IssuedAt,
+		next.ExpiresAt,
+	)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token of a family
+func (m *PostgresDBRepo) RevokeRefreshTokenFamily(familyID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where family_id = $2 and revoked_at is null`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user
+func (m *PostgresDBRepo) RevokeUserRefreshTokens(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package dbrepo
+
+import (
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertRefreshToken records a newly issued refresh token
+func (m *TestDBRepo) InsertRefreshToken(t data.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.refreshTokens == nil {
+		m.refreshTokens = make(map[string]*data.RefreshToken)
+	}
+	m.refreshTokens[t.ID] = &t
+
+	return nil
+}
+
+// GetRefreshToken returns one refresh token by id
+func (m *TestDBRepo) GetRefreshToken(id string) (*data.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.refreshTokens[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	token := *t
+	return &token, nil
+}
+
+// RotateRefreshToken marks the refresh token oldID as used and records next, its successor.
+func (m *TestDBRepo) RotateRefreshToken(oldID string, next data.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.refreshTokens[oldID]
+	if !ok || !old.UsedAt.IsZero() || !old.RevokedAt.IsZero() {
+		return repository.ErrRefreshTokenUsed
+	}
+	old.UsedAt = time.Now()
+	m.refreshTokens[next.ID] = &next
+
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token of a family
+func (m *TestDBRepo) RevokeRefreshTokenFamily(familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.refreshTokens {
+		if t.FamilyID == familyID && t.RevokedAt.IsZero() {
+			t.RevokedAt = time.Now()
+		}
+	}
+
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of a user
+func (m *TestDBRepo) RevokeUserRefreshTokens(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.refreshTokens {
+		if t.UserID == userID && t.RevokedAt.IsZero() {
+			t.RevokedAt = time.Now()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// GetUserTOTP returns the TOTP enrollment of a user, or repository.ErrNotFound if there is none
+func (m *PostgresDBRepo) GetUserTOTP(userID int) (*data.UserTOTP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select user_id, secret, confirmed_at, coalesce(last_used_step, 0), created_at
+		from user_totp where user_id = $1`
+
+	var t data.UserTOTP
+	var confirmedAt sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&t.UserID,
+		&t.Secret,
+		&confirmedAt,
+		&t.LastUsedStep,
+		&t.CreatedAt,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	t.ConfirmedAt = confirmedAt.Time
+
+	return &t, nil
+}
+
+// SaveUserTOTP starts a new, pending enrollment of a user, replacing any former one
+func (m *PostgresDBRepo) SaveUserTOTP(t data.UserTOTP) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into user_totp (user_id, secret, created_at)
+		values ($1, $2, $3)
+		on conflict (user_id) do update set
+			secret = excluded.secret, confirmed_at = null, last_used_step = null, created_at = excluded.created_at`
+
+	_, err := m.DB.ExecContext(ctx, stmt, t.UserID, t.Secret, time.Now())
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// ConfirmUserTOTP finishes the enrollment of a user, and replaces their recovery codes, in one transaction
+func (m *PostgresDBRepo) ConfirmUserTOTP(userID int, recoveryCodeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	result, err := tx.ExecContext(ctx, `update user_totp set confirmed_at = $1 where user_id = $2`, now, userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return translateError(err)
+	}
+	if rows == 0 {
+		return repository.ErrNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from user_recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	for _, hash := range recoveryCodeHashes {
+		stmt := `insert into user_recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+
+		_, err = tx.ExecContext(ctx, stmt, userID, hash, now)
+		if err != nil {
+			return translateError(err)
+		}
+	}
+
+	return translateError(tx.Commit())
+}
+
+// UseTOTPStep records that a code of the given time step was accepted. It returns false if a code
+// of this or a later step was accepted before, i.e. if the code is being replayed.
+func (m *PostgresDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update user_totp set last_used_step = $1
+		where user_id = $2 and (last_used_step is null or last_used_step < $1)`
+
+	result, err := m.DB.ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return false, translateError(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, translateError(err)
+	}
+
+	return rows == 1, nil
+}
+
+// UseRecoveryCode uses up a recovery code of a user. It returns false if there is no such unused code.
+func (m *PostgresDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update user_recovery_codes set used_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, translateError(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, translateError(err)
+	}
+
+	return rows == 1, nil
+}
@@ -0,0 +1,88 @@
+package dbrepo
+
+import (
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// GetUserTOTP returns the TOTP enrollment of a user
+func (m *TestDBRepo) GetUserTOTP(userID int) (*data.UserTOTP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.userTOTP[userID]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+
+	enrollment := *t
+	return &enrollment, nil
+}
+
+// SaveUserTOTP starts a new, pending enrollment of a user
+func (m *TestDBRepo) SaveUserTOTP(t data.UserTOTP) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userTOTP == nil {
+		m.userTOTP = make(map[int]*data.UserTOTP)
+	}
+
+	t.ConfirmedAt = time.Time{}
+	t.LastUsedStep = 0
+	t.CreatedAt = time.Now()
+	m.userTOTP[t.UserID] = &t
+
+	return nil
+}
+
+// ConfirmUserTOTP finishes the enrollment of a user, and replaces their recovery codes
+func (m *TestDBRepo) ConfirmUserTOTP(userID int, recoveryCodeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.userTOTP[userID]
+	if !ok {
+		return repository.ErrNotFound
+	}
+	t.ConfirmedAt = time.Now()
+
+	if m.recoveryCodes == nil {
+		m.recoveryCodes = make(map[int]map[string]bool)
+	}
+	m.recoveryCodes[userID] = make(map[string]bool)
+	for _, hash := range recoveryCodeHashes {
+		m.recoveryCodes[userID][hash] = false
+	}
+
+	return nil
+}
+
+// UseTOTPStep records that a code of the given time step was accepted
+func (m *TestDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.userTOTP[userID]
+	if !ok || t.LastUsedStep >= step {
+		return false, nil
+	}
+	t.LastUsedStep = step
+
+	return true, nil
+}
+
+// UseRecoveryCode uses up a recovery code of a user
+func (m *TestDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used, ok := m.recoveryCodes[userID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	m.recoveryCodes[userID][codeHash] = true
+
+	return true, nil
+}
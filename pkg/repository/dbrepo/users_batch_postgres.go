@@ -0,0 +1,135 @@
+package dbrepo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"runtime"
+	"sync"
+	"time"
+	"webapp/pkg/data"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// batchTimeout bounds the transaction of InsertUsers, which is much longer than a single insert
+const batchTimeout = time.Minute
+
+// InsertUsers inserts users, with their roles, in one transaction: either all of them are
+// inserted, or none is. Users without a password get a random one nobody knows. It returns the
+// new ids in the order of users.
+func (m *PostgresDBRepo) InsertUsers(users []data.User) ([]int, error) {
+	// hash before the transaction starts, so that it isn't kept open for the slow part
+	hashes, err := hashPasswords(users)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `insert into users (email, first_name, last_name, password, created_at, updated_at, verified_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	ids := make([]int, len(users))
+
+	for i, user := range users {
+		err = stmt.QueryRowContext(ctx, user.Email, user.FirstName, user.LastName, hashes[i], now, now, nullTime(user.VerifiedAt)).Scan(&ids[i])
+		if err != nil {
+			return nil, translateError(err)
+		}
+
+		err = setUserRoles(ctx, tx, ids[i], user.Roles)
+		if err != nil {
+			return nil, translateError(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return ids, nil
+}
+
+// ExistingEmails returns those of emails which users already have.
+func (m *PostgresDBRepo) ExistingEmails(emails []string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `select email from users where email = any($1) and deleted_at is null`, emails)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var existing []string
+	for rows.Next() {
+		var email string
+		err = rows.Scan(&email)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		existing = append(existing, email)
+	}
+
+	return existing, translateError(rows.Err())
+}
+
+// hashPasswords bcrypt hashes the passwords of users on all CPUs. Users without a password get
+// a random one, hashed with the lowest cost: it can't be guessed anyway.
+func hashPasswords(users []data.User) ([][]byte, error) {
+	hashes := make([][]byte, len(users))
+	errs := make([]error, len(users))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if users[i].Password == "" {
+					hashes[i], errs[i] = randomPasswordHash()
+					continue
+				}
+				hashes[i], errs[i] = bcrypt.GenerateFromPassword([]byte(users[i].Password), 12)
+			}
+		}()
+	}
+
+	for i := range users {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+// randomPasswordHash returns the hash of a random password.
+func randomPasswordHash() ([]byte, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	return bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(b)), bcrypt.MinCost)
+}
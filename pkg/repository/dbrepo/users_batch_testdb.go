@@ -0,0 +1,59 @@
+package dbrepo
+
+import (
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertUsers adds users to the ones added with AddUsers, with ids after the highest one so far.
+// Like the postgres version it inserts all of them or, if an email address is taken, none, and
+// gives users without a password a random one.
+func (m *TestDBRepo) InsertUsers(users []data.User) ([]int, error) {
+	existing := m.allUsers()
+
+	taken := map[string]bool{}
+	nextID := 1
+	for _, u := range existing {
+		taken[u.Email] = true
+		if u.ID >= nextID {
+			nextID = u.ID + 1
+		}
+	}
+
+	ids := make([]int, len(users))
+	added := make([]data.User, len(users))
+	for i, u := range users {
+		if taken[u.Email] {
+			return nil, &repository.ConstraintError{Kind: repository.ErrDuplicateEmail, Constraint: "users_email_key"}
+		}
+		taken[u.Email] = true
+
+		if u.Password == "" {
+			hash, err := randomPasswordHash()
+			if err != nil {
+				return nil, err
+			}
+			u.Password = string(hash)
+		}
+
+		u.ID = nextID + i
+		u.Version = 1
+		ids[i] = u.ID
+		added[i] = u
+	}
+
+	m.AddUsers(added...)
+
+	return ids, nil
+}
+
+// ExistingEmails returns those of emails which users already have.
+func (m *TestDBRepo) ExistingEmails(emails []string) ([]string, error) {
+	var existing []string
+	for _, email := range emails {
+		if _, err := m.GetUserByEmail(email); err == nil {
+			existing = append(existing, email)
+		}
+	}
+	return existing, nil
+}
@@ -0,0 +1,80 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/repository"
+)
+
+// RestoreUser undoes the deletion of a user which wasn't purged yet. It returns ErrNotFound if
+// there is no such deleted user, and ErrDuplicateEmail if another user took its email address.
+func (m *PostgresDBRepo) RestoreUser(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set deleted_at = null, updated_at = $2, version = version + 1
+		where id = $1 and deleted_at is not null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, time.Now())
+	if err != nil {
+		return translateError(err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
+
+// PurgeDeletedUsers removes the users deleted before before for good, along with everything of
+// theirs. It returns the file names of their profile pictures which no other user refers to,
+// so that the caller can remove the files.
+func (m *PostgresDBRepo) PurgeDeletedUsers(before time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer tx.Rollback()
+
+	// the images go with the users, by cascade; files uploaded before they got names of their own
+	// may be shared by several users
+	query := `select distinct ui.file_name from user_images ui join users u on (u.id = ui.user_id)
+		where u.deleted_at < $1 and not exists (
+			select 1 from user_images other join users ou on (ou.id = other.user_id)
+			where other.file_name = ui.file_name and (ou.deleted_at is null or ou.deleted_at >= $1))`
+
+	rows, err := tx.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var files []string
+	for rows.Next() {
+		var file string
+		err = rows.Scan(&file)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		files = append(files, file)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from users where deleted_at < $1`, before)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,60 @@
+package dbrepo
+
+import (
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// RestoreUser undoes the deletion of a user which wasn't purged yet
+func (m *TestDBRepo) RestoreUser(id int) error {
+	var deleted *data.User
+	users := m.deletedUsers()
+	for i := range users {
+		if users[i].ID == id {
+			deleted = &users[i]
+		}
+	}
+	if deleted == nil {
+		return repository.ErrNotFound
+	}
+
+	if _, err := m.GetUserByEmail(deleted.Email); err == nil {
+		return &repository.ConstraintError{Kind: repository.ErrDuplicateEmail, Constraint: "users_email_key"}
+	}
+
+	m.markDeleted(id, time.Time{})
+	return nil
+}
+
+// PurgeDeletedUsers removes the users deleted before before, and returns the file names of
+// their profile pictures which no other user refers to
+func (m *TestDBRepo) PurgeDeletedUsers(before time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept, purged []data.User
+	for _, u := range m.users {
+		if !u.DeletedAt.IsZero() && u.DeletedAt.Before(before) {
+			purged = append(purged, u)
+		} else {
+			kept = append(kept, u)
+		}
+	}
+	m.users = kept
+
+	inUse := map[string]bool{}
+	for _, u := range kept {
+		inUse[u.ProfilePic.FileName] = true
+	}
+
+	var files []string
+	for _, u := range purged {
+		if name := u.ProfilePic.FileName; name != "" && !inUse[name] {
+			files = append(files, name)
+			inUse[name] = true
+		}
+	}
+
+	return files, nil
+}
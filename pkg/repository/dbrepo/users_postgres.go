@@ -3,9 +3,12 @@ package dbrepo
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/repository"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -25,12 +28,13 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, is_admin, created_at, updated_at
-	from users order by last_name`
+	query := `select id, email, first_name, last_name, password, created_at, updated_at, version,
+	coalesce((select string_agg(r.name, ',' order by r.name) from user_roles ur join roles r on (r.id = ur.role_id) where ur.user_id = users.id), '')
+	from users where deleted_at is null order by last_name`
 
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	defer rows.Close()
 
@@ -38,21 +42,24 @@ func (m *PostgresDBRepo) AllUsers() ([]*data.User, error) {
 
 	for rows.Next() {
 		var user data.User
+		var roles string
 		err := rows.Scan(
 			&user.ID,
 			&user.Email,
 			&user.FirstName,
 			&user.LastName,
 			&user.Password,
-			&user.IsAdmin,
 			&user.CreatedAt,
 			&user.UpdatedAt,
+			&user.Version,
+			&roles,
 		)
 		if err != nil {
 			log.Println("Error scanning", err)
-			return nil, err
+			return nil, translateError(err)
 		}
 
+		user.Roles = splitList(roles)
 		users = append(users, &user)
 	}
 
@@ -66,15 +73,18 @@ func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
 
 	query := `
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at,
-			coalesce(ui.file_name, '')
+			u.id, u.email, u.first_name, u.last_name, u.password, u.created_at, u.updated_at, u.version, u.verified_at,
+			coalesce(ui.file_name, ''),
+			coalesce((select string_agg(r.name, ',' order by r.name) from user_roles ur join roles r on (r.id = ur.role_id) where ur.user_id = u.id), '')
 		from 
 			users u
 			left join user_images ui on (ui.user_id = u.id)
 		where 
-		    u.id = $1`
+		    u.id = $1 and u.deleted_at is null`
 
 	var user data.User
+	var roles string
+	var verifiedAt sql.NullTime
 	row := m.DB.QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
@@ -83,16 +93,21 @@ func (m *PostgresDBRepo) GetUser(id int) (*data.User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
-		&user.IsAdmin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Version,
+		&verifiedAt,
 		&user.ProfilePic.FileName,
+		&roles,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
+	user.Roles = splitList(roles)
+	user.VerifiedAt = verifiedAt.Time
+
 	return &user, nil
 }
 
@@ -103,15 +118,18 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
 
 	query := `
 		select 
-			u.id, u.email, u.first_name, u.last_name, u.password, u.is_admin, u.created_at, u.updated_at, 
-			coalesce(ui.file_name, '')
+			u.id, u.email, u.first_name, u.last_name, u.password, u.created_at, u.updated_at, u.version, u.verified_at,
+			coalesce(ui.file_name, ''),
+			coalesce((select string_agg(r.name, ',' order by r.name) from user_roles ur join roles r on (r.id = ur.role_id) where ur.user_id = u.id), '')
 		from 
 			users u 
 			left join user_images ui on (ui.user_id = u.id)
 		where 
-		    u.email = $1`
+		    u.email = $1 and u.deleted_at is null`
 
 	var user data.User
+	var roles string
+	var verifiedAt sql.NullTime
 	row := m.DB.QueryRowContext(ctx, query, email)
 
 	err := row.Scan(
@@ -120,109 +138,225 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*data.User, error) {
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
-		&user.IsAdmin,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.Version,
+		&verifiedAt,
 		&user.ProfilePic.FileName,
+		&roles,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
+	user.Roles = splitList(roles)
+	user.VerifiedAt = verifiedAt.Time
+
 	return &user, nil
 }
 
-// UpdateUser updates one user in the database
+// UpdateUser updates one user in the database, including the user's roles unless u.Roles is nil.
+// Unless u.Version is zero, the user is only updated if its version still matches, and ErrStale is returned otherwise.
 func (m *PostgresDBRepo) UpdateUser(u data.User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
 	stmt := `update users set
 		email = $1,
 		first_name = $2,
 		last_name = $3,
-		is_admin = $4,
-		updated_at = $5
-		where id = $6
+		updated_at = $4,
+		version = version + 1
+		where id = $5 and deleted_at is null and ($6 = 0 or version = $6)
 	`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
+	result, err := tx.ExecContext(ctx, stmt,
 		u.Email,
 		u.FirstName,
 		u.LastName,
-		u.IsAdmin,
 		time.Now(),
 		u.ID,
+		u.Version,
 	)
 
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
-	return nil
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return missingOrStale(ctx, tx, u.ID)
+	}
+
+	if u.Roles != nil {
+		err = setUserRoles(ctx, tx, u.ID, u.Roles)
+		if err != nil {
+			return translateError(err)
+		}
+	}
+
+	return translateError(tx.Commit())
 }
 
-// DeleteUser deletes one user from the database, by id
+// DeleteUser deletes one user, by id. The user is only marked as deleted, and can be restored
+// until PurgeDeletedUsers removes it for good.
 func (m *PostgresDBRepo) DeleteUser(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `delete from users where id = $1`
+	stmt := `update users set deleted_at = $2, version = version + 1 where id = $1 and deleted_at is null`
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	result, err := m.DB.ExecContext(ctx, stmt, id, time.Now())
 	if err != nil {
-		return err
+		return translateError(err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return repository.ErrNotFound
 	}
 
 	return nil
 }
 
+// DeleteUserVersion deletes one user like DeleteUser, if its version still matches. It returns
+// ErrStale if the user was changed in the meantime.
+func (m *PostgresDBRepo) DeleteUserVersion(id, version int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set deleted_at = $3, version = version + 1 where id = $1 and deleted_at is null and version = $2`
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, version, time.Now())
+	if err != nil {
+		return translateError(err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return missingOrStale(ctx, m.DB, id)
+	}
+
+	return nil
+}
+
+// nullTime stores zero times as null
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// rowQueryer is implemented by both *sql.DB and *sql.Tx
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// missingOrStale tells why a conditional write of a user changed nothing: ErrNotFound if there is
+// no such user, and ErrStale if it has another version.
+func missingOrStale(ctx context.Context, q rowQueryer, id int) error {
+	var exists bool
+	err := q.QueryRowContext(ctx, `select exists (select 1 from users where id = $1 and deleted_at is null)`, id).Scan(&exists)
+	if err != nil {
+		return translateError(err)
+	}
+
+	if !exists {
+		return repository.ErrNotFound
+	}
+	return repository.ErrStale
+}
+
 // InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
+// Users without VerifiedAt have to confirm their email address before they can log in.
 func (m *PostgresDBRepo) InsertUser(user data.User) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
 	if err != nil {
-		return 0, err
+		return 0, translateError(err)
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, translateError(err)
 	}
+	defer tx.Rollback()
 
 	var newID int
-	stmt := `insert into users (email, first_name, last_name, password, is_admin, created_at, updated_at)
+	stmt := `insert into users (email, first_name, last_name, password, created_at, updated_at, verified_at)
 		values ($1, $2, $3, $4, $5, $6, $7) returning id`
 
-	err = m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		user.Email,
 		user.FirstName,
 		user.LastName,
 		hashedPassword,
-		user.IsAdmin,
 		time.Now(),
 		time.Now(),
+		nullTime(user.VerifiedAt),
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, translateError(err)
+	}
+
+	err = setUserRoles(ctx, tx, newID, user.Roles)
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, translateError(err)
 	}
 
 	return newID, nil
 }
 
-// ResetPassword is the method we will use to change a user's password.
+// VerifyEmail marks the email address of a user as confirmed. Confirming it again keeps the time
+// of the first confirmation.
+func (m *PostgresDBRepo) VerifyEmail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set verified_at = coalesce(verified_at, $2) where id = $1 and deleted_at is null`
+
+	result, err := m.DB.ExecContext(ctx, stmt, id, time.Now())
+	if err != nil {
+		return translateError(err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
+
+// ResetPassword is the method we will use to change a user's password. It returns ErrNotFound
+// for deleted users.
 func (m *PostgresDBRepo) ResetPassword(id int, password string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
-	stmt := `update users set password = $1 where id = $2`
-	_, err = m.DB.ExecContext(ctx, stmt, hashedPassword, id)
+	stmt := `update users set password = $1, updated_at = $3, version = version + 1
+		where id = $2 and deleted_at is null`
+	result, err := m.DB.ExecContext(ctx, stmt, hashedPassword, id, time.Now())
 	if err != nil {
-		return err
+		return translateError(err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return repository.ErrNotFound
 	}
 
 	return nil
@@ -237,7 +371,7 @@ func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
 	stmt := `delete from user_images where user_id = $1`
 	_, err := m.DB.ExecContext(ctx, stmt, i.UserID)
 	if err != nil {
-		return 0, err
+		return 0, translateError(err)
 	}
 
 	var newID int
@@ -252,8 +386,59 @@ func (m *PostgresDBRepo) InsertUserImage(i data.UserImage) (int, error) {
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, translateError(err)
 	}
 
 	return newID, nil
 }
+
+// UserImageInUse reports whether any user, deleted ones included, has the image file fileName as
+// profile picture.
+func (m *PostgresDBRepo) UserImageInUse(fileName string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var inUse bool
+	err := m.DB.QueryRowContext(ctx, `select exists (select 1 from user_images where file_name = $1)`, fileName).Scan(&inUse)
+	if err != nil {
+		return false, translateError(err)
+	}
+
+	return inUse, nil
+}
+
+// setUserRoles replaces the roles of a user, as part of the transaction tx
+func setUserRoles(ctx context.Context, tx *sql.Tx, userID int, roles []string) error {
+	_, err := tx.ExecContext(ctx, `delete from user_roles where user_id = $1`, userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	stmt := `insert into user_roles (user_id, role_id)
+		select $1, id from roles where name = $2`
+
+	for _, role := range roles {
+		result, err := tx.ExecContext(ctx, stmt, userID, role)
+		if err != nil {
+			return translateError(err)
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return translateError(err)
+		}
+		if rows == 0 {
+			return &repository.ConstraintError{Kind: repository.ErrConstraint, Constraint: fmt.Sprintf("unknown role %q", role)}
+		}
+	}
+
+	return nil
+}
+
+// splitList turns a comma separated list, like the role names selected with string_agg, into a slice
+func splitList(list string) []string {
+	if list == "" {
+		return []string{}
+	}
+	return strings.Split(list, ",")
+}
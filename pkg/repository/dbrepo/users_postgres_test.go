@@ -4,6 +4,7 @@ package dbrepo
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -131,7 +132,7 @@ func TestPostgresDBRepo_InsertUser(t *testing.T) {
 		LastName:  "User",
 		Email:     "admin@example.com",
 		Password:  "secret",
-		IsAdmin:   1,
+		Roles:     []string{data.RoleAdmin},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -162,7 +163,7 @@ func TestPostgresDBRepo_AllUsers(t *testing.T) {
 		LastName:  "Song",
 		Email:     "sirzzang@example.com",
 		Password:  "secret",
-		IsAdmin:   1,
+		Roles:     []string{data.RoleAdmin},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
@@ -189,10 +190,14 @@ func TestPostgresDBRepo_GetUser(t *testing.T) {
 		t.Errorf("wrong email returned by GetUser; expected admin@example.com but got %s", user.Email)
 	}
 
+	if !user.IsAdmin() {
+		t.Errorf("expected user 1 to have the admin role, but got roles %v", user.Roles)
+	}
+
 	// non existing user
 	_, err = testRepo.GetUser(11)
-	if err == nil {
-		t.Errorf("GetUser returned no error when getting non existent user")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected repository.ErrNotFound when getting non existent user, but got %v", err)
 	}
 
 }
@@ -212,6 +217,7 @@ func TestPostgresDBRepo_UpdateUser(t *testing.T) {
 	user, _ := testRepo.GetUser(2)
 	user.FirstName = "Eraser"
 	user.Email = "eraser@example.com"
+	user.Roles = []string{data.RoleUser}
 
 	err := testRepo.UpdateUser(*user)
 	if err != nil {
@@ -222,6 +228,22 @@ func TestPostgresDBRepo_UpdateUser(t *testing.T) {
 	if user.FirstName != "Eraser" || user.Email != "eraser@example.com" {
 		t.Errorf("expected updated record to have first name Eraser and email eraser@example.com, but got %s, %s", user.FirstName, user.Email)
 	}
+
+	if user.IsAdmin() || !user.HasRole(data.RoleUser) {
+		t.Errorf("expected updated record to only have the user role, but got %v", user.Roles)
+	}
+
+	// without roles, the roles are kept
+	user.Roles = nil
+	err = testRepo.UpdateUser(*user)
+	if err != nil {
+		t.Errorf("error updating user %d: %s", 2, err)
+	}
+
+	user, _ = testRepo.GetUser(2)
+	if !user.HasRole(data.RoleUser) {
+		t.Errorf("expected the user role to be kept, but got %v", user.Roles)
+	}
 }
 
 func TestPostgresDBRepo_DeleteUser(t *testing.T) {
@@ -237,6 +259,7 @@ func TestPostgresDBRepo_DeleteUser(t *testing.T) {
 }
 
 func TestPostgresDBRepo_ResetPassword(t *testing.T) {
+	before, _ := testRepo.GetUser(1)
 
 	err := testRepo.ResetPassword(1, "password")
 	if err != nil {
@@ -253,6 +276,20 @@ func TestPostgresDBRepo_ResetPassword(t *testing.T) {
 		t.Errorf("password should match 'password' but not")
 	}
 
+	if user.Version != before.Version+1 {
+		t.Errorf("expected version %d after resetting the password, but got %d", before.Version+1, user.Version)
+	}
+
+	// user 2 was deleted by TestPostgresDBRepo_DeleteUser
+	err = testRepo.ResetPassword(2, "password")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected repository.ErrNotFound resetting the password of a deleted user, but got %v", err)
+	}
+
+	err = testRepo.ResetPassword(999, "password")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected repository.ErrNotFound resetting the password of a non existent user, but got %v", err)
+	}
 }
 
 func TestPostgresDBRepo_InsertUserImage(t *testing.T) {
@@ -280,4 +317,630 @@ func TestPostgresDBRepo_InsertUserImage(t *testing.T) {
 		t.Error("inserted an image with non existent user id")
 	}
 
+	inUse, err := testRepo.UserImageInUse("test.jpg")
+	if err != nil || !inUse {
+		t.Errorf("expected test.jpg to be in use, but got %v, %v", inUse, err)
+	}
+
+	inUse, _ = testRepo.UserImageInUse("other.jpg")
+	if inUse {
+		t.Error("expected other.jpg not to be in use")
+	}
+}
+
+func TestPostgresDBRepo_RefreshTokens(t *testing.T) {
+	first := data.RefreshToken{
+		ID:        "token-1",
+		UserID:    1,
+		FamilyID:  "family-1",
+		Device:    "test",
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	err := testRepo.InsertRefreshToken(first)
+	if err != nil {
+		t.Fatalf("inserting refresh token failed: %s", err)
+	}
+
+	stored, err := testRepo.GetRefreshToken("token-1")
+	if err != nil {
+		t.Fatalf("getting refresh token failed: %s", err)
+	}
+	if stored.FamilyID != "family-1" || !stored.UsedAt.IsZero() {
+		t.Errorf("unexpected refresh token returned: %+v", stored)
+	}
+
+	second := first
+	second.ID = "token-2"
+
+	err = testRepo.RotateRefreshToken("token-1", second)
+	if err != nil {
+		t.Errorf("rotating refresh token failed: %s", err)
+	}
+
+	stored, _ = testRepo.GetRefreshToken("token-1")
+	if stored.UsedAt.IsZero() {
+		t.Error("expected rotated refresh token to be marked as used")
+	}
+
+	// rotating the same token again must fail
+	third := first
+	third.ID = "token-3"
+
+	err = testRepo.RotateRefreshToken("token-1", third)
+	if !errors.Is(err, repository.ErrRefreshTokenUsed) {
+		t.Errorf("expected ErrRefreshTokenUsed when rotating a used token, but got %v", err)
+	}
+
+	err = testRepo.RevokeRefreshTokenFamily("family-1")
+	if err != nil {
+		t.Errorf("revoking refresh token family failed: %s", err)
+	}
+
+	stored, _ = testRepo.GetRefreshToken("token-2")
+	if stored.RevokedAt.IsZero() {
+		t.Error("expected refresh token to be revoked with its family")
+	}
+}
+
+func TestPostgresDenylist(t *testing.T) {
+	denylist := &PostgresDenylist{DB: testDB}
+	now := time.Now()
+
+	err := denylist.DenyToken("jti-1", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("denying token failed: %s", err)
+	}
+
+	denied, err := denylist.IsDenied("jti-1", 1, now)
+	if err != nil {
+		t.Fatalf("checking denylist failed: %s", err)
+	}
+	if !denied {
+		t.Error("expected jti-1 to be denied")
+	}
+
+	denied, _ = denylist.IsDenied("jti-2", 1, now)
+	if denied {
+		t.Error("expected jti-2 not to be denied")
+	}
+
+	err = denylist.DenyUser(1, now)
+	if err != nil {
+		t.Fatalf("denying user failed: %s", err)
+	}
+
+	denied, _ = denylist.IsDenied("jti-3", 1, now.Add(-time.Minute))
+	if !denied {
+		t.Error("expected token issued before the user revocation to be denied")
+	}
+
+	denied, _ = denylist.IsDenied("jti-4", 1, now.Add(time.Minute))
+	if denied {
+		t.Error("expected token issued after the user revocation not to be denied")
+	}
+
+	denied, _ = denylist.IsDenied("jti-5", 1, now.Add(-time.Microsecond))
+	if !denied {
+		t.Error("expected token issued right before the user revocation to be denied")
+	}
+
+	denied, _ = denylist.IsDenied("jti-6", 1, now.Add(time.Microsecond))
+	if denied {
+		t.Error("expected token issued right after the user revocation not to be denied")
+	}
+}
+
+func TestPostgresDBRepo_OAuth(t *testing.T) {
+	client := data.OAuthClient{
+		ID:           "client-1",
+		Name:         "Test Client",
+		RedirectURIs: []string{"http://localhost:3000/callback", "http://localhost:3000/other"},
+	}
+
+	err := testRepo.InsertOAuthClient(client)
+	if err != nil {
+		t.Fatalf("inserting oauth client failed: %s", err)
+	}
+
+	stored, err := testRepo.GetOAuthClient("client-1")
+	if err != nil {
+		t.Fatalf("getting oauth client failed: %s", err)
+	}
+	if !stored.IsPublic() || len(stored.RedirectURIs) != 2 {
+		t.Errorf("unexpected oauth client returned: %+v", stored)
+	}
+
+	code := data.AuthorizationCode{
+		CodeHash:            "hash-1",
+		ClientID:            "client-1",
+		UserID:              1,
+		RedirectURI:         "http://localhost:3000/callback",
+		Scope:               "openid",
+		CodeChallenge:       "challenge",
+		CodeChallengeMethod: "S256",
+		ExpiresAt:           time.Now().Add(time.Minute),
+	}
+
+	err = testRepo.InsertAuthorizationCode(code)
+	if err != nil {
+		t.Fatalf("inserting authorization code failed: %s", err)
+	}
+
+	consumed, err := testRepo.ConsumeAuthorizationCode("hash-1")
+	if err != nil {
+		t.Fatalf("consuming authorization code failed: %s", err)
+	}
+	if consumed.UserID != 1 || consumed.CodeChallenge != "challenge" {
+		t.Errorf("unexpected authorization code returned: %+v", consumed)
+	}
+
+	// codes can only be consumed once
+	_, err = testRepo.ConsumeAuthorizationCode("hash-1")
+	if err == nil {
+		t.Error("consumed the same authorization code twice")
+	}
+}
+
+func TestPostgresDBRepo_LoginThrottles(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	throttle, err := testRepo.GetLoginThrottle("ip:192.0.2.1")
+	if err != nil {
+		t.Fatalf("getting unknown login throttle failed: %s", err)
+	}
+	if throttle.Failures != 0 || throttle.IsLocked(now) {
+		t.Errorf("expected an empty throttle but got %+v", throttle)
+	}
+
+	for i := 1; i <= 3; i++ {
+		throttle, err = testRepo.RecordLoginFailure("ip:192.0.2.1", now, now.Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("recording login failure failed: %s", err)
+		}
+		if throttle.Failures != i {
+			t.Errorf("expected %d failures but got %d", i, throttle.Failures)
+		}
+	}
+
+	// failures from before forgetBefore do not count anymore
+	throttle, _ = testRepo.RecordLoginFailure("ip:192.0.2.1", now.Add(2*time.Hour), now.Add(time.Hour))
+	if throttle.Failures != 1 {
+		t.Errorf("expected old failures to be forgotten, got %d", throttle.Failures)
+	}
+
+	err = testRepo.LockLogin("ip:192.0.2.1", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("locking login failed: %s", err)
+	}
+
+	throttle, _ = testRepo.GetLoginThrottle("ip:192.0.2.1")
+	if !throttle.IsLocked(now) {
+		t.Errorf("expected the key to be locked, got %+v", throttle)
+	}
+
+	err = testRepo.ResetLoginThrottle("ip:192.0.2.1")
+	if err != nil {
+		t.Fatalf("resetting login throttle failed: %s", err)
+	}
+
+	throttle, _ = testRepo.GetLoginThrottle("ip:192.0.2.1")
+	if throttle.Failures != 0 || throttle.IsLocked(now) {
+		t.Errorf("expected the throttle to be reset, got %+v", throttle)
+	}
+}
+
+func TestPostgresDBRepo_InsertAuditEntry(t *testing.T) {
+	err := testRepo.InsertAuditEntry(data.AuditEntry{
+		Actor:  "1",
+		Action: "login.unlock",
+		Target: "account:admin@example.com",
+		IP:     "192.0.2.1",
+	})
+	if err != nil {
+		t.Errorf("inserting audit entry failed: %s", err)
+	}
+
+	err = testRepo.InsertAuditEntry(data.AuditEntry{
+		Actor:   "1",
+		Action:  "user.update",
+		Target:  "user:2",
+		Changes: data.Changes{"first_name": {Before: "Jane", After: "Janet"}},
+	})
+	if err != nil {
+		t.Fatalf("inserting audit entry with changes failed: %s", err)
+	}
+
+	page, err := testRepo.ListAuditEntries(repository.AuditQuery{Action: "user.", Target: "user:2", Limit: 10})
+	if err != nil {
+		t.Fatalf("ListAuditEntries returned an error: %s", err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Changes["first_name"].After != "Janet" {
+		t.Errorf("expected the user.update entry with its changes, but got %+v", page.Entries)
+	}
+
+	page, err = testRepo.ListAuditEntries(repository.AuditQuery{Actor: "1", Limit: 1})
+	if err != nil || len(page.Entries) != 1 || page.Entries[0].Action != "user.update" || page.Next != page.Entries[0].ID {
+		t.Errorf("expected the newest entry and a next page, but got %+v (%v)", page, err)
+	}
+
+	// the log is append-only
+	_, err = testDB.Exec("update audit_log set actor = '2'")
+	if err == nil {
+		t.Error("expected updating the audit log to fail")
+	}
+	_, err = testDB.Exec("delete from audit_log")
+	if err == nil {
+		t.Error("expected deleting from the audit log to fail")
+	}
+}
+
+func TestPostgresDBRepo_TOTP(t *testing.T) {
+	_, err := testRepo.GetUserTOTP(1)
+	if err != repository.ErrNotFound {
+		t.Errorf("expected no enrollment but got %v", err)
+	}
+
+	err = testRepo.SaveUserTOTP(data.UserTOTP{UserID: 1, Secret: "JBSWY3DPEHPK3PXP"})
+	if err != nil {
+		t.Fatalf("saving totp enrollment failed: %s", err)
+	}
+
+	enrollment, err := testRepo.GetUserTOTP(1)
+	if err != nil {
+		t.Fatalf("getting totp enrollment failed: %s", err)
+	}
+	if enrollment.IsConfirmed() || enrollment.Secret != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("unexpected enrollment %+v", enrollment)
+	}
+
+	err = testRepo.ConfirmUserTOTP(1, []string{"hash-1", "hash-2"})
+	if err != nil {
+		t.Fatalf("confirming totp enrollment failed: %s", err)
+	}
+
+	enrollment, _ = testRepo.GetUserTOTP(1)
+	if !enrollment.IsConfirmed() {
+		t.Error("expected the enrollment to be confirmed")
+	}
+
+	// each time step can only be used once
+	for i, expected := range []bool{true, false} {
+		ok, err := testRepo.UseTOTPStep(1, 100)
+		if err != nil || ok != expected {
+			t.Errorf("use of step, attempt %d: expected %t but got %t (%v)", i, expected, ok, err)
+		}
+	}
+
+	// and so can each recovery code
+	for i, expected := range []bool{true, false} {
+		ok, err := testRepo.UseRecoveryCode(1, "hash-1")
+		if err != nil || ok != expected {
+			t.Errorf("use of recovery code, attempt %d: expected %t but got %t (%v)", i, expected, ok, err)
+		}
+	}
+}
+
+func TestPostgresDBRepo_APIKeys(t *testing.T) {
+	id, err := testRepo.InsertAPIKey(data.APIKey{
+		UserID:    1,
+		Name:      "ci",
+		Prefix:    "wak_0123abcd",
+		KeyHash:   "key-hash",
+		Scopes:    []string{data.ScopeUsersRead},
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("inserting api key failed: %s", err)
+	}
+
+	key, err := testRepo.GetAPIKeyByHash("key-hash")
+	if err != nil {
+		t.Fatalf("getting api key by hash failed: %s", err)
+	}
+	if key.ID != id || !key.HasScope(data.ScopeUsersRead) || key.HasScope(data.ScopeUsersWrite) {
+		t.Errorf("unexpected api key %+v", key)
+	}
+
+	err = testRepo.TouchAPIKey(id, time.Now())
+	if err != nil {
+		t.Errorf("touching api key failed: %s", err)
+	}
+
+	keys, err := testRepo.ListAPIKeys(1)
+	if err != nil || len(keys) != 1 || keys[0].LastUsedAt.IsZero() {
+		t.Errorf("unexpected list of api keys %+v (%v)", keys, err)
+	}
+
+	err = testRepo.RevokeAPIKey(id)
+	if err != nil {
+		t.Fatalf("revoking api key failed: %s", err)
+	}
+
+	key, _ = testRepo.GetAPIKey(id)
+	if key.IsActive(time.Now()) {
+		t.Error("expected revoked api key to be inactive")
+	}
+}
+
+func TestPostgresDBRepo_ListUsers(t *testing.T) {
+	for i := 1; i <= 3; i++ {
+		_, err := testRepo.InsertUser(data.User{
+			FirstName: "Page",
+			LastName:  fmt.Sprintf("User%d", i),
+			Email:     fmt.Sprintf("page_%d@example.com", i),
+			Password:  "secret",
+			Roles:     []string{data.RoleUser},
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("inserting user failed: %s", err)
+		}
+	}
+
+	q := repository.UserQuery{EmailPrefix: "PAGE_", Sort: "-last_name", Limit: 2}
+
+	page, err := testRepo.ListUsers(q)
+	if err != nil {
+		t.Fatalf("ListUsers returned an error: %s", err)
+	}
+	if page.Total != 3 || len(page.Users) != 2 || page.Users[0].LastName != "User3" || page.Next == nil {
+		t.Fatalf("unexpected first page: total %d, %d users, next %v", page.Total, len(page.Users), page.Next)
+	}
+
+	q.After = page.Next
+	page, err = testRepo.ListUsers(q)
+	if err != nil {
+		t.Fatalf("ListUsers returned an error: %s", err)
+	}
+	if len(page.Users) != 1 || page.Users[0].LastName != "User1" || page.Next != nil {
+		t.Errorf("unexpected second page: %d users, next %v", len(page.Users), page.Next)
+	}
+
+	// wildcards in the prefix are matched literally
+	page, _ = testRepo.ListUsers(repository.UserQuery{EmailPrefix: "page%", Limit: 10})
+	if page.Total != 0 {
+		t.Errorf("expected no users for a wildcard prefix, got %d", page.Total)
+	}
+
+	isAdmin := true
+	page, err = testRepo.ListUsers(repository.UserQuery{IsAdmin: &isAdmin, Sort: "created_at", Limit: 10})
+	if err != nil {
+		t.Fatalf("ListUsers returned an error: %s", err)
+	}
+	for _, u := range page.Users {
+		if !u.IsAdmin() {
+			t.Errorf("expected only admins, got %s with roles %v", u.Email, u.Roles)
+		}
+	}
+}
+
+func TestPostgresDBRepo_UserVersions(t *testing.T) {
+	id, err := testRepo.InsertUser(data.User{
+		FirstName: "Version",
+		LastName:  "User",
+		Email:     "version@example.com",
+		Password:  "secret",
+		Roles:     []string{data.RoleUser},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("inserting user failed: %s", err)
+	}
+
+	user, _ := testRepo.GetUser(id)
+	if user.Version != 1 {
+		t.Fatalf("expected version 1 for a new user, but got %d", user.Version)
+	}
+
+	user.FirstName = "Versioned"
+	err = testRepo.UpdateUser(*user)
+	if err != nil {
+		t.Fatalf("updating the current version failed: %s", err)
+	}
+
+	// the same version again is stale now
+	err = testRepo.UpdateUser(*user)
+	if !errors.Is(err, repository.ErrStale) {
+		t.Errorf("expected repository.ErrStale, but got %v", err)
+	}
+
+	updated, _ := testRepo.GetUser(id)
+	if updated.Version != 2 || updated.FirstName != "Versioned" {
+		t.Errorf("expected version 2 named Versioned, but got version %d named %s", updated.Version, updated.FirstName)
+	}
+
+	err = testRepo.DeleteUserVersion(id, 1)
+	if !errors.Is(err, repository.ErrStale) {
+		t.Errorf("expected repository.ErrStale deleting an old version, but got %v", err)
+	}
+
+	err = testRepo.DeleteUserVersion(id, 2)
+	if err != nil {
+		t.Errorf("deleting the current version failed: %s", err)
+	}
+
+	err = testRepo.DeleteUserVersion(id, 2)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected repository.ErrNotFound deleting a deleted user, but got %v", err)
+	}
+}
+
+func TestPostgresDBRepo_InsertUsers(t *testing.T) {
+	users := []data.User{
+		{FirstName: "Batch", LastName: "One", Email: "batch_1@example.com", Password: "secret", Roles: []string{data.RoleUser}},
+		{FirstName: "Batch", LastName: "Two", Email: "batch_2@example.com", Password: "secret", Roles: []string{data.RoleAdmin, data.RoleUser}},
+	}
+
+	ids, err := testRepo.InsertUsers(users)
+	if err != nil {
+		t.Fatalf("InsertUsers returned an error: %s", err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("expected 2 ids, but got %v", ids)
+	}
+
+	user, err := testRepo.GetUser(ids[1])
+	if err != nil || user.Email != "batch_2@example.com" || !user.IsAdmin() {
+		t.Errorf("unexpected second user %+v (%v)", user, err)
+	}
+	if ok, _ := user.PasswordMatches("secret"); !ok {
+		t.Error("expected the password to be hashed")
+	}
+
+	ids, err = testRepo.InsertUsers([]data.User{{FirstName: "Batch", LastName: "Random", Email: "batch_random@example.com"}})
+	if err != nil {
+		t.Fatalf("InsertUsers returned an error for a user without a password: %s", err)
+	}
+	user, _ = testRepo.GetUser(ids[0])
+	if ok, err := user.PasswordMatches(""); ok || err != nil {
+		t.Errorf("expected an empty password not to match the random one, but got %v, %v", ok, err)
+	}
+
+	existing, err := testRepo.ExistingEmails([]string{"batch_1@example.com", "nobody@example.com", "batch_2@example.com"})
+	if err != nil {
+		t.Fatalf("ExistingEmails returned an error: %s", err)
+	}
+	if len(existing) != 2 {
+		t.Errorf("expected the 2 batch users to exist, but got %v", existing)
+	}
+
+	// one taken email address keeps the whole batch out
+	_, err = testRepo.InsertUsers([]data.User{
+		{FirstName: "Batch", LastName: "Three", Email: "batch_3@example.com", Password: "secret"},
+		{FirstName: "Batch", LastName: "Again", Email: "batch_1@example.com", Password: "secret"},
+	})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected repository.ErrDuplicateEmail, but got %v", err)
+	}
+
+	_, err = testRepo.GetUserByEmail("batch_3@example.com")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the batch to be rolled back, but got %v", err)
+	}
+
+	for _, id := range ids {
+		_ = testRepo.DeleteUser(id)
+	}
+}
+
+func TestPostgresDBRepo_SoftDelete(t *testing.T) {
+	newUser := func(email string) int {
+		t.Helper()
+		id, err := testRepo.InsertUser(data.User{
+			FirstName: "Soft",
+			LastName:  "Delete",
+			Email:     email,
+			Password:  "secret",
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("InsertUser returned an error: %s", err)
+		}
+		return id
+	}
+
+	id := newUser("soft@example.com")
+	_, err := testRepo.InsertUserImage(data.UserImage{UserID: id, FileName: "soft.png", CreatedAt: time.Now(), UpdatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("InsertUserImage returned an error: %s", err)
+	}
+
+	err = testRepo.DeleteUser(id)
+	if err != nil {
+		t.Fatalf("DeleteUser returned an error: %s", err)
+	}
+
+	_, err = testRepo.GetUser(id)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the deleted user to be hidden, but got %v", err)
+	}
+	_, err = testRepo.GetUserByEmail("soft@example.com")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected the deleted user to be hidden by email, but got %v", err)
+	}
+
+	page, err := testRepo.ListUsers(repository.UserQuery{Deleted: true, EmailPrefix: "soft@", Limit: 10})
+	if err != nil || len(page.Users) != 1 || page.Users[0].ID != id {
+		t.Errorf("expected to list the deleted user, but got %+v (%v)", page, err)
+	}
+
+	// the email address is free again while the user is deleted
+	other := newUser("soft@example.com")
+
+	err = testRepo.RestoreUser(id)
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected repository.ErrDuplicateEmail restoring a taken email address, but got %v", err)
+	}
+
+	_ = testRepo.DeleteUser(other)
+
+	err = testRepo.RestoreUser(id)
+	if err != nil {
+		t.Fatalf("RestoreUser returned an error: %s", err)
+	}
+	_, err = testRepo.GetUser(id)
+	if err != nil {
+		t.Errorf("expected the restored user to be found, but got %v", err)
+	}
+
+	err = testRepo.RestoreUser(id)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected repository.ErrNotFound restoring a user which isn't deleted, but got %v", err)
+	}
+
+	_ = testRepo.DeleteUser(id)
+
+	files, err := testRepo.PurgeDeletedUsers(time.Now().Add(-time.Hour))
+	if err != nil || len(files) != 0 {
+		t.Errorf("expected nothing to be purged within the retention period, but got %v (%v)", files, err)
+	}
+
+	files, err = testRepo.PurgeDeletedUsers(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("PurgeDeletedUsers returned an error: %s", err)
+	}
+	if len(files) != 1 || files[0] != "soft.png" {
+		t.Errorf("expected the image of the purged user to be returned, but got %v", files)
+	}
+
+	err = testRepo.RestoreUser(id)
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("expected a purged user to be gone for good, but got %v", err)
+	}
+}
+
+// runs last, as the failed inserts use up ids
+func TestPostgresDBRepo_Errors(t *testing.T) {
+	_, err := testRepo.InsertUser(data.User{
+		FirstName: "Admin",
+		LastName:  "Again",
+		Email:     "admin@example.com",
+		Password:  "secret",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	if !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("expected repository.ErrDuplicateEmail, but got %v", err)
+	}
+
+	_, err = testRepo.InsertUser(data.User{
+		Email:     "roleless@example.com",
+		Password:  "secret",
+		Roles:     []string{"superuser"},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	if !errors.Is(err, repository.ErrConstraint) {
+		t.Errorf("expected repository.ErrConstraint for an unknown role, but got %v", err)
+	}
+
+	_, err = testRepo.InsertAPIKey(data.APIKey{UserID: 999, Name: "orphan", Prefix: "wak_orphan", KeyHash: "orphan", Scopes: []string{data.ScopeUsersRead}})
+	if !errors.Is(err, repository.ErrConstraint) {
+		t.Errorf("expected repository.ErrConstraint for an unknown user, but got %v", err)
+	}
 }
@@ -0,0 +1,146 @@
+package dbrepo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// userSortColumns maps the keys of repository.UserSorts to the columns users are ordered by.
+var userSortColumns = map[string]string{
+	"id":         "u.id",
+	"email":      "u.email",
+	"first_name": "u.first_name",
+	"last_name":  "u.last_name",
+	"created_at": "u.created_at",
+}
+
+// cursorValue converts the sort value of a cursor to the type of the sort column.
+func cursorValue(key, value string) interface{} {
+	switch key {
+	case "id":
+		id, _ := strconv.Atoi(value)
+		return id
+	case "created_at":
+		t, _ := time.Parse(time.RFC3339Nano, value)
+		return t
+	default:
+		return value
+	}
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ListUsers returns one page of the users matching q, along with the total number of matches
+func (m *PostgresDBRepo) ListUsers(q repository.UserQuery) (*repository.UserPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	err := q.Validate()
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	key, desc := q.SortKey()
+	column := userSortColumns[key]
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := []string{"u.deleted_at is null"}
+	if q.Deleted {
+		where = []string{"u.deleted_at is not null"}
+	}
+
+	if q.EmailPrefix != "" {
+		where = append(where, `lower(u.email) like `+arg(likeEscaper.Replace(strings.ToLower(q.EmailPrefix))+"%")+` escape '\'`)
+	}
+	if q.IsAdmin != nil {
+		where = append(where, `exists (select 1 from user_roles ur join roles r on (r.id = ur.role_id)
+			where ur.user_id = u.id and r.name = 'admin') = `+arg(*q.IsAdmin))
+	}
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "u.created_at >= "+arg(q.CreatedAfter))
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "u.created_at < "+arg(q.CreatedBefore))
+	}
+
+	filter := "where " + strings.Join(where, " and ")
+
+	var page repository.UserPage
+
+	err = m.DB.QueryRowContext(ctx, "select count(*) from users u "+filter, args...).Scan(&page.Total)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	direction, compare := "asc", ">"
+	if desc {
+		direction, compare = "desc", "<"
+	}
+
+	if q.After != nil {
+		where = append(where, "("+column+", u.id) "+compare+" ("+arg(cursorValue(key, q.After.Value))+", "+arg(q.After.ID)+")")
+		filter = "where " + strings.Join(where, " and ")
+	}
+
+	// fetch one more row than asked for, to know whether there is a next page
+	query := `select u.id, u.email, u.first_name, u.last_name, u.password, u.created_at, u.updated_at, u.version,
+	coalesce((select string_agg(r.name, ',' order by r.name) from user_roles ur join roles r on (r.id = ur.role_id) where ur.user_id = u.id), '')
+	from users u ` + filter + `
+	order by ` + column + ` ` + direction + `, u.id ` + direction + `
+	limit ` + arg(q.Limit+1)
+
+	if q.After == nil && q.Offset > 0 {
+		query += " offset " + arg(q.Offset)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	page.Users = []*data.User{}
+
+	for rows.Next() {
+		var user data.User
+		var roles string
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Password,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.Version,
+			&roles,
+		)
+		if err != nil {
+			return nil, translateError(err)
+		}
+
+		user.Roles = splitList(roles)
+		page.Users = append(page.Users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	if len(page.Users) > q.Limit {
+		page.Users = page.Users[:q.Limit]
+		page.Next = repository.CursorAfter(page.Users[q.Limit-1], q.Sort)
+	}
+
+	return &page, nil
+}
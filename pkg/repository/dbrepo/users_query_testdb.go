@@ -0,0 +1,111 @@
+package dbrepo
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// AddUsers adds users to the ones returned by ListUsers, next to the admin user. Users without a
+// version get version 1, like new rows.
+func (m *TestDBRepo) AddUsers(users ...data.User) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range users {
+		if u.Version == 0 {
+			u.Version = 1
+		}
+		m.users = append(m.users, u)
+	}
+}
+
+// ListUsers returns one page of the users matching q, along with the total number of matches
+func (m *TestDBRepo) ListUsers(q repository.UserQuery) (*repository.UserPage, error) {
+	err := q.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	users := m.allUsers()
+	if q.Deleted {
+		users = m.deletedUsers()
+	}
+
+	var matches []*data.User
+	for i := range users {
+		u := &users[i]
+		if !strings.HasPrefix(strings.ToLower(u.Email), strings.ToLower(q.EmailPrefix)) {
+			continue
+		}
+		if q.IsAdmin != nil && u.IsAdmin() != *q.IsAdmin {
+			continue
+		}
+		if !q.CreatedAfter.IsZero() && u.CreatedAt.Before(q.CreatedAfter) {
+			continue
+		}
+		if !q.CreatedBefore.IsZero() && !u.CreatedAt.Before(q.CreatedBefore) {
+			continue
+		}
+		matches = append(matches, u)
+	}
+
+	key, desc := q.SortKey()
+	less := func(a, b *data.User) bool {
+		switch key {
+		case "id":
+			return a.ID < b.ID
+		case "created_at":
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+		default:
+			if va, vb := repository.UserSortValue(a, key), repository.UserSortValue(b, key); va != vb {
+				return va < vb
+			}
+		}
+		return a.ID < b.ID
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if desc {
+			return less(matches[j], matches[i])
+		}
+		return less(matches[i], matches[j])
+	})
+
+	page := repository.UserPage{Total: len(matches), Users: []*data.User{}}
+
+	start := q.Offset
+	if q.After != nil {
+		after := &data.User{ID: q.After.ID}
+		switch key {
+		case "created_at":
+			after.CreatedAt, _ = time.Parse(time.RFC3339Nano, q.After.Value)
+		case "email":
+			after.Email = q.After.Value
+		case "first_name":
+			after.FirstName = q.After.Value
+		case "last_name":
+			after.LastName = q.After.Value
+		}
+
+		start = sort.Search(len(matches), func(i int) bool {
+			if desc {
+				return less(matches[i], after)
+			}
+			return less(after, matches[i])
+		})
+	}
+
+	for i := start; i < len(matches) && len(page.Users) < q.Limit; i++ {
+		page.Users = append(page.Users, matches[i])
+	}
+
+	if len(page.Users) == q.Limit && start+q.Limit < len(matches) {
+		page.Next = repository.CursorAfter(page.Users[q.Limit-1], q.Sort)
+	}
+
+	return &page, nil
+}
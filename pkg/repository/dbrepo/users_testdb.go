@@ -2,12 +2,50 @@ package dbrepo
 
 import (
 	"database/sql"
-	"errors"
+	"sync"
 	"time"
 	"webapp/pkg/data"
+	"webapp/pkg/repository"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-type TestDBRepo struct{}
+// TestDBRepo is an in-memory stand-in for PostgresDBRepo, used by the handler tests.
+type TestDBRepo struct {
+	mu            sync.Mutex
+	users         []data.User // returned by ListUsers, next to the admin user
+	refreshTokens map[string]*data.RefreshToken
+
+	oauthClients       map[string]*data.OAuthClient
+	authorizationCodes map[string]*data.AuthorizationCode
+
+	loginThrottles map[string]*data.LoginThrottle
+	auditLog       []data.AuditEntry
+
+	userTOTP      map[int]*data.UserTOTP
+	recoveryCodes map[int]map[string]bool // user id -> code hash -> used
+
+	passwords map[int]string         // password hashes set by ResetPassword
+	images    map[int]data.UserImage // profile pictures set by InsertUserImage, by user id
+
+	apiKeys map[int]*data.APIKey
+
+	webSessions map[string]*data.WebSession
+}
+
+// adminPassword is the hash of "secret", the password of the admin user
+const adminPassword = "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK"
+
+// password returns the current password hash of a user
+func (m *TestDBRepo) password(id int) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if hash, ok := m.passwords[id]; ok {
+		return hash
+	}
+	return adminPassword
+}
 
 func (m *TestDBRepo) Connection() *sql.DB {
 	return nil
@@ -20,62 +58,250 @@ func (m *TestDBRepo) AllUsers() ([]*data.User, error) {
 	return users, nil
 }
 
-// GetUser returns one user by id
-func (m *TestDBRepo) GetUser(id int) (*data.User, error) {
-	var user = data.User{}
-
-	if id == 1 {
-		user = data.User{
-			ID:        1,
-			FirstName: "Admin",
-			LastName:  "User",
-			Email:     "admin@example.com",
+// admin returns the admin user, which is always there
+func (m *TestDBRepo) admin() data.User {
+	return data.User{
+		ID:         1,
+		FirstName:  "Admin",
+		LastName:   "User",
+		Email:      "admin@example.com",
+		Password:   m.password(1),
+		Roles:      []string{data.RoleAdmin},
+		CreatedAt:  time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		UpdatedAt:  time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		Version:    1,
+		VerifiedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// allUsers returns the admin user followed by the users added with AddUsers, leaving out the
+// deleted ones
+func (m *TestDBRepo) allUsers() []data.User {
+	admin := m.admin()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := []data.User{admin}
+	for _, u := range m.users {
+		if u.DeletedAt.IsZero() {
+			users = append(users, u)
+		}
+	}
+	for i := range users {
+		if image, ok := m.images[users[i].ID]; ok {
+			users[i].ProfilePic = image
 		}
-		return &user, nil
 	}
+	return users
+}
+
+// deletedUsers returns the users added with AddUsers which were deleted
+func (m *TestDBRepo) deletedUsers() []data.User {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	return nil, errors.New("user not found")
+	var users []data.User
+	for _, u := range m.users {
+		if !u.DeletedAt.IsZero() {
+			users = append(users, u)
+		}
+	}
+	return users
 }
 
-// GetUserByEmail returns one user by email address
-func (m *TestDBRepo) GetUserByEmail(email string) (*data.User, error) {
-	if email == "admin@example.com" {
-		user := data.User{
-			ID:        1,
-			FirstName: "Admin",
-			LastName:  "User",
-			Email:     "admin@example.com",
-			Password:  "$2a$14$ajq8Q7fbtFRQvXpdCq7Jcuy.Rx1h/L4J60Otx.gyNLbAYctGMJ9tK",
-			IsAdmin:   1,
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+// markDeleted sets when a user added with AddUsers was deleted; the admin user is never deleted
+func (m *TestDBRepo) markDeleted(id int, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].ID == id {
+			m.users[i].DeletedAt = at
+			m.users[i].Version++
+		}
+	}
+}
+
+// findUser returns the first user matching match
+func (m *TestDBRepo) findUser(match func(u *data.User) bool) (*data.User, error) {
+	users := m.allUsers()
+	for i := range users {
+		if match(&users[i]) {
+			return &users[i], nil
 		}
-		return &user, nil
 	}
-	return nil, errors.New("user not found")
+	return nil, repository.ErrNotFound
 }
 
-// UpdateUser updates one user in the database
+// GetUser returns one user by id
+func (m *TestDBRepo) GetUser(id int) (*data.User, error) {
+	return m.findUser(func(u *data.User) bool { return u.ID == id })
+}
+
+// GetUserByEmail returns one user by email address
+func (m *TestDBRepo) GetUserByEmail(email string) (*data.User, error) {
+	return m.findUser(func(u *data.User) bool { return u.Email == email })
+}
+
+// UpdateUser updates one user in the database, keeping the roles if u.Roles is nil. Unless
+// u.Version is zero, it must match the version of the user. Only the users added with AddUsers keep the update; the admin user never changes.
 func (m *TestDBRepo) UpdateUser(u data.User) error {
+	existing, err := m.GetUser(u.ID)
+	if err != nil {
+		return err
+	}
+
+	if u.Version != 0 && u.Version != existing.Version {
+		return repository.ErrStale
+	}
+
+	_, err = m.findUser(func(other *data.User) bool { return other.Email == u.Email && other.ID != u.ID })
+	if err == nil {
+		return &repository.ConstraintError{Kind: repository.ErrDuplicateEmail, Constraint: "users_email_key"}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].ID == u.ID {
+			m.users[i].FirstName = u.FirstName
+			m.users[i].LastName = u.LastName
+			m.users[i].Email = u.Email
+			if u.Roles != nil {
+				m.users[i].Roles = u.Roles
+			}
+			m.users[i].Version++
+		}
+	}
+
 	return nil
 }
 
-// DeleteUser deletes one user from the database, by id
+// DeleteUser marks one user as deleted, by id
 func (m *TestDBRepo) DeleteUser(id int) error {
+	_, err := m.GetUser(id)
+	if err != nil {
+		return err
+	}
+
+	m.markDeleted(id, time.Now())
+	return nil
+}
+
+// DeleteUserVersion marks one user as deleted, by id, if its version still matches
+func (m *TestDBRepo) DeleteUserVersion(id, version int) error {
+	existing, err := m.GetUser(id)
+	if err != nil {
+		return err
+	}
+
+	if existing.Version != version {
+		return repository.ErrStale
+	}
+
+	m.markDeleted(id, time.Now())
 	return nil
 }
 
 // InsertUser inserts a new user into the database, and returns the ID of the newly inserted row
 func (m *TestDBRepo) InsertUser(user data.User) (int, error) {
+	_, err := m.GetUserByEmail(user.Email)
+	if err == nil {
+		return 0, &repository.ConstraintError{Kind: repository.ErrDuplicateEmail, Constraint: "users_email_key"}
+	}
 	return 2, nil
 }
 
-// ResetPassword is the method we will use to change a user's password.
+// VerifyEmail marks the email address of a user as confirmed; the admin user is always verified.
+func (m *TestDBRepo) VerifyEmail(id int) error {
+	_, err := m.GetUser(id)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].ID == id && m.users[i].VerifiedAt.IsZero() {
+			m.users[i].VerifiedAt = time.Now()
+		}
+	}
+
+	return nil
+}
+
+// ResetPassword is the method we will use to change a user's password. It returns ErrNotFound
+// for deleted users; like the postgres version it counts up the version, but the admin user's
+// never changes.
 func (m *TestDBRepo) ResetPassword(id int, password string) error {
+	_, err := m.GetUser(id)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.passwords == nil {
+		m.passwords = make(map[int]string)
+	}
+	m.passwords[id] = string(hashedPassword)
+
+	for i := range m.users {
+		if m.users[i].ID == id {
+			m.users[i].Password = string(hashedPassword)
+			m.users[i].Version++
+		}
+	}
+
 	return nil
 }
 
-// InsertUserImage inserts a user profile image into the database.
+// InsertUserImage replaces the profile image of a user.
 func (m *TestDBRepo) InsertUserImage(i data.UserImage) (int, error) {
-	return 1, nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.images == nil {
+		m.images = make(map[int]data.UserImage)
+	}
+	for _, image := range m.images {
+		if image.ID >= i.ID {
+			i.ID = image.ID + 1
+		}
+	}
+	if i.ID == 0 {
+		i.ID = 1
+	}
+	m.images[i.UserID] = i
+
+	return i.ID, nil
+}
+
+// UserImageInUse reports whether any user, deleted ones included, has the image file fileName as
+// profile picture
+func (m *TestDBRepo) UserImageInUse(fileName string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, image := range m.images {
+		if image.FileName == fileName {
+			return true, nil
+		}
+	}
+	for _, u := range m.users {
+		if _, ok := m.images[u.ID]; !ok && u.ProfilePic.FileName == fileName {
+			return true, nil
+		}
+	}
+
+	return false, nil
 }
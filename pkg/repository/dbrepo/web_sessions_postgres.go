@@ -0,0 +1,110 @@
+package dbrepo
+
+import (
+	"context"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+const webSessionColumns = `id, user_id, user_agent, ip, created_at, last_seen_at, expires_at`
+
+// InsertWebSession records a new web session. The sessions of the user which are over by now are
+// removed on the way, so that the table only grows with the sessions which are still alive.
+func (m *PostgresDBRepo) InsertWebSession(s data.WebSession) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from web_sessions where user_id = $1 and expires_at <= $2`, s.UserID, s.CreatedAt)
+	if err != nil {
+		return translateError(err)
+	}
+
+	stmt := `insert into web_sessions (` + webSessionColumns + `) values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = m.DB.ExecContext(ctx, stmt,
+		s.ID,
+		s.UserID,
+		s.UserAgent,
+		s.IP,
+		s.CreatedAt,
+		s.LastSeenAt,
+		s.ExpiresAt,
+	)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// ListWebSessions returns the web sessions of a user which haven't expired yet, most recently
+// active first. Sessions which timed out for being idle are left for the caller to tell apart.
+func (m *PostgresDBRepo) ListWebSessions(userID int) ([]*data.WebSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select ` + webSessionColumns + ` from web_sessions
+		where user_id = $1 and expires_at > $2 order by last_seen_at desc, created_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	var sessions []*data.WebSession
+	for rows.Next() {
+		var s data.WebSession
+		err = rows.Scan(
+			&s.ID,
+			&s.UserID,
+			&s.UserAgent,
+			&s.IP,
+			&s.CreatedAt,
+			&s.LastSeenAt,
+			&s.ExpiresAt,
+		)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		sessions = append(sessions, &s)
+	}
+
+	return sessions, translateError(rows.Err())
+}
+
+// TouchWebSession records when a web session was last used
+func (m *PostgresDBRepo) TouchWebSession(id string, seenAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update web_sessions set last_seen_at = $1 where id = $2`, seenAt, id)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// DeleteWebSession removes a web session of a user. It returns repository.ErrNotFound if the user
+// has no such session, so that users can't remove the sessions of others.
+func (m *PostgresDBRepo) DeleteWebSession(userID int, id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from web_sessions where id = $1 and user_id = $2`, id, userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return translateError(err)
+	}
+	if n == 0 {
+		return repository.ErrNotFound
+	}
+
+	return nil
+}
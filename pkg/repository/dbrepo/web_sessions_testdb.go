@@ -0,0 +1,76 @@
+package dbrepo
+
+import (
+	"sort"
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// InsertWebSession records a new web session, removing the sessions of the user which are over
+func (m *TestDBRepo) InsertWebSession(s data.WebSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.webSessions == nil {
+		m.webSessions = make(map[string]*data.WebSession)
+	}
+
+	for id, existing := range m.webSessions {
+		if existing.UserID == s.UserID && !s.CreatedAt.Before(existing.ExpiresAt) {
+			delete(m.webSessions, id)
+		}
+	}
+
+	m.webSessions[s.ID] = &s
+
+	return nil
+}
+
+// ListWebSessions returns the web sessions of a user which haven't expired yet, most recently
+// active first
+func (m *TestDBRepo) ListWebSessions(userID int) ([]*data.WebSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	var sessions []*data.WebSession
+	for _, s := range m.webSessions {
+		if s.UserID == userID && now.Before(s.ExpiresAt) {
+			session := *s
+			sessions = append(sessions, &session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+
+	return sessions, nil
+}
+
+// TouchWebSession records when a web session was last used
+func (m *TestDBRepo) TouchWebSession(id string, seenAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.webSessions[id]; ok {
+		s.LastSeenAt = seenAt
+	}
+
+	return nil
+}
+
+// DeleteWebSession removes a web session of a user
+func (m *TestDBRepo) DeleteWebSession(userID int, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.webSessions[id]
+	if !ok || s.UserID != userID {
+		return repository.ErrNotFound
+	}
+
+	delete(m.webSessions, id)
+
+	return nil
+}
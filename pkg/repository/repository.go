@@ -2,17 +2,98 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"time"
 	"webapp/pkg/data"
 )
 
+// ErrRefreshTokenUsed is returned when rotating a refresh token which was already exchanged or revoked.
+var ErrRefreshTokenUsed = errors.New("refresh token already used")
+
+// Errors returned by the repositories in place of database specific ones. Match them with errors.Is.
+var (
+	ErrNotFound       = errors.New("record not found")
+	ErrDuplicateEmail = errors.New("email address already in use")
+	ErrConflict       = errors.New("record conflicts with an existing one")
+	ErrConstraint     = errors.New("record violates a constraint")
+	ErrStale          = errors.New("record was changed since it was read")
+)
+
+// ConstraintError names the database constraint a write violated. It matches its Kind, one of
+// ErrDuplicateEmail, ErrConflict or ErrConstraint, with errors.Is.
+type ConstraintError struct {
+	Kind       error
+	Constraint string
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Constraint == "" {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Kind, e.Constraint)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Kind
+}
+
 type DatabaseRepo interface {
 	Connection() *sql.DB
 	AllUsers() ([]*data.User, error)
+	ListUsers(q UserQuery) (*UserPage, error)
 	GetUser(id int) (*data.User, error)
 	GetUserByEmail(email string) (*data.User, error)
 	UpdateUser(u data.User) error
 	DeleteUser(id int) error
+	DeleteUserVersion(id, version int) error
+	RestoreUser(id int) error
+	PurgeDeletedUsers(before time.Time) ([]string, error)
 	InsertUser(user data.User) (int, error)
+	InsertUsers(users []data.User) ([]int, error)
+	ExistingEmails(emails []string) ([]string, error)
 	ResetPassword(id int, password string) error
+	VerifyEmail(id int) error
 	InsertUserImage(i data.UserImage) (int, error)
+	UserImageInUse(fileName string) (bool, error)
+	InsertRefreshToken(t data.RefreshToken) error
+	GetRefreshToken(id string) (*data.RefreshToken, error)
+	RotateRefreshToken(oldID string, next data.RefreshToken) error
+	RevokeRefreshTokenFamily(familyID string) error
+	RevokeUserRefreshTokens(userID int) error
+	InsertOAuthClient(c data.OAuthClient) error
+	GetOAuthClient(id string) (*data.OAuthClient, error)
+	InsertAuthorizationCode(c data.AuthorizationCode) error
+	ConsumeAuthorizationCode(codeHash string) (*data.AuthorizationCode, error)
+	GetLoginThrottle(key string) (*data.LoginThrottle, error)
+	RecordLoginFailure(key string, at, forgetBefore time.Time) (*data.LoginThrottle, error)
+	LockLogin(key string, until time.Time) error
+	ResetLoginThrottle(key string) error
+	InsertAuditEntry(e data.AuditEntry) error
+	ListAuditEntries(q AuditQuery) (*AuditPage, error)
+	GetUserTOTP(userID int) (*data.UserTOTP, error)
+	SaveUserTOTP(t data.UserTOTP) error
+	ConfirmUserTOTP(userID int, recoveryCodeHashes []string) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	InsertAPIKey(k data.APIKey) (int, error)
+	GetAPIKey(id int) (*data.APIKey, error)
+	GetAPIKeyByHash(keyHash string) (*data.APIKey, error)
+	ListAPIKeys(userID int) ([]*data.APIKey, error)
+	RevokeAPIKey(id int) error
+	TouchAPIKey(id int, usedAt time.Time) error
+	InsertWebSession(s data.WebSession) error
+	ListWebSessions(userID int) ([]*data.WebSession, error)
+	TouchWebSession(id string, seenAt time.Time) error
+	DeleteWebSession(userID int, id string) error
+}
+
+// TokenDenylist keeps track of access tokens which were revoked before they expired.
+type TokenDenylist interface {
+	// DenyToken revokes the access token with the given jti, until it would have expired anyway.
+	DenyToken(jti string, expiresAt time.Time) error
+	// DenyUser revokes every access token issued to the user before issuedBefore.
+	DenyUser(userID int, issuedBefore time.Time) error
+	// IsDenied reports whether the access token jti, issued to userID at issuedAt, was revoked.
+	IsDenied(jti string, userID int, issuedAt time.Time) (bool, error)
 }
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"webapp/pkg/data"
+)
+
+// Page sizes of ListUsers.
+const (
+	DefaultUserLimit = 50
+	MaxUserLimit     = 200
+)
+
+// UserSorts are the keys users can be sorted by. Prefix a key with "-" to sort descending.
+var UserSorts = []string{"id", "email", "first_name", "last_name", "created_at"}
+
+// ErrInvalidCursor is returned for cursors which are malformed, or were issued for another sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// UserQuery filters, sorts and pages the users returned by ListUsers. Zero values don't filter.
+type UserQuery struct {
+	EmailPrefix   string
+	IsAdmin       *bool
+	CreatedAfter  time.Time // inclusive
+	CreatedBefore time.Time // exclusive
+	Deleted       bool      // list the deleted users, instead of the others
+
+	Sort string // one of UserSorts, optionally prefixed with "-"; last_name if empty
+
+	Limit  int
+	Offset int     // ignored if After is set
+	After  *Cursor // keyset pagination: only return users after this one
+}
+
+// SortKey returns the key q sorts by, and whether it sorts descending. Ties are always broken by id.
+func (q UserQuery) SortKey() (key string, desc bool) {
+	key = q.Sort
+	if strings.HasPrefix(key, "-") {
+		key, desc = key[1:], true
+	}
+	if key == "" {
+		key = "last_name"
+	}
+	return key, desc
+}
+
+// Validate checks the sort key, limit, offset and cursor of q.
+func (q UserQuery) Validate() error {
+	key, _ := q.SortKey()
+	if !validUserSort(key) {
+		return errors.New("sort must be one of " + strings.Join(UserSorts, ", "))
+	}
+	if q.Limit < 1 || q.Limit > MaxUserLimit {
+		return errors.New("limit must be between 1 and " + strconv.Itoa(MaxUserLimit))
+	}
+	if q.Offset < 0 {
+		return errors.New("offset must not be negative")
+	}
+	if q.After != nil && q.Offset > 0 {
+		return errors.New("cursor and offset can't be combined")
+	}
+	if q.After != nil && (q.After.Sort != q.Sort || !validSortValue(key, q.After.Value)) {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+func validUserSort(key string) bool {
+	for _, s := range UserSorts {
+		if s == key {
+			return true
+		}
+	}
+	return false
+}
+
+func validSortValue(key, value string) bool {
+	switch key {
+	case "created_at":
+		_, err := time.Parse(time.RFC3339Nano, value)
+		return err == nil
+	case "id":
+		_, err := strconv.Atoi(value)
+		return err == nil
+	default:
+		return true
+	}
+}
+
+// Cursor points at the last user of a page. It is only valid for the sort order it was issued for.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"` // the sort key of the user
+	ID    int    `json:"id"`
+}
+
+// CursorAfter returns the cursor pointing at user u, for sort order sort.
+func CursorAfter(u *data.User, sort string) *Cursor {
+	q := UserQuery{Sort: sort}
+	key, _ := q.SortKey()
+	return &Cursor{Sort: sort, Value: UserSortValue(u, key), ID: u.ID}
+}
+
+// UserSortValue returns the value users are compared by when sorting by key.
+func UserSortValue(u *data.User, key string) string {
+	switch key {
+	case "email":
+		return u.Email
+	case "first_name":
+		return u.FirstName
+	case "last_name":
+		return u.LastName
+	case "created_at":
+		return u.CreatedAt.UTC().Format(time.RFC3339Nano)
+	default:
+		return strconv.Itoa(u.ID)
+	}
+}
+
+// Encode returns the cursor as an opaque string for clients.
+func (c *Cursor) Encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// ParseCursor decodes a cursor returned by Encode.
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	err = json.Unmarshal(raw, &c)
+	if err != nil || c.ID < 1 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// UserPage is one page of users.
+type UserPage struct {
+	Users []*data.User
+	Total int     // number of users matching the filters, across all pages
+	Next  *Cursor // nil on the last page
+}
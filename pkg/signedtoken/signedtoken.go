@@ -0,0 +1,118 @@
+package signedtoken
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Purposes of the tokens we hand out. A token is only accepted for the purpose it was signed for.
+const (
+	PasswordReset     = "password-reset"
+	EmailVerification = "email-verification"
+)
+
+var (
+	ErrInvalid = errors.New("invalid token")
+	ErrExpired = errors.New("token expired")
+)
+
+// Signer creates and verifies expiring tokens for links sent by email. A token names a user and
+// its expiry, and is signed with an HMAC over its purpose and a binding: a value the caller knows
+// about the user, like their password hash. Once the binding changes, the token stops working,
+// which is what makes tokens single use without storing them.
+type Signer struct {
+	Key []byte
+}
+
+// NewKey returns a random signing key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Sign returns a token for userID which expires at expiresAt.
+func (s *Signer) Sign(purpose string, userID int, expiresAt time.Time, binding string) string {
+	payload := fmt.Sprintf("%d.%d", userID, expiresAt.Unix())
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.mac(purpose, payload, binding))
+}
+
+// Subject returns the user id of a token, without verifying it. Callers need it to look up the
+// binding before calling Verify.
+func Subject(token string) (int, error) {
+	payload, _, err := split(token)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.Atoi(strings.SplitN(payload, ".", 2)[0])
+	if err != nil {
+		return 0, ErrInvalid
+	}
+
+	return id, nil
+}
+
+// Verify checks that token was signed by s for purpose and binding, and has not expired at now.
+func (s *Signer) Verify(token, purpose, binding string, now time.Time) error {
+	payload, signature, err := split(token)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(signature, s.mac(purpose, payload, binding)) {
+		return ErrInvalid
+	}
+
+	parts := strings.SplitN(payload, ".", 2)
+	if len(parts) != 2 {
+		return ErrInvalid
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	if now.Unix() > expiresAt {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+func (s *Signer) mac(purpose, payload, binding string) []byte {
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write([]byte(purpose + "\x00" + payload + "\x00" + binding))
+	return mac.Sum(nil)
+}
+
+func split(token string) (string, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", nil, ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", nil, ErrInvalid
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, ErrInvalid
+	}
+
+	return string(payload), signature, nil
+}
@@ -0,0 +1,48 @@
+package signedtoken
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	s := &Signer{Key: []byte("secret")}
+	now := time.Now()
+
+	token := s.Sign(PasswordReset, 7, now.Add(time.Hour), "hash-1")
+
+	// the payload of a token for another user, with the signature of ours
+	other := s.Sign(PasswordReset, 8, now.Add(time.Hour), "hash-1")
+	tampered := other[:strings.Index(other, ".")] + token[strings.Index(token, "."):]
+
+	id, err := Subject(token)
+	if err != nil || id != 7 {
+		t.Errorf("expected subject 7, got %d (%v)", id, err)
+	}
+
+	var tests = []struct {
+		name     string
+		signer   *Signer
+		token    string
+		purpose  string
+		binding  string
+		now      time.Time
+		expected error
+	}{
+		{"valid", s, token, PasswordReset, "hash-1", now, nil},
+		{"expired", s, token, PasswordReset, "hash-1", now.Add(2 * time.Hour), ErrExpired},
+		{"binding changed", s, token, PasswordReset, "hash-2", now, ErrInvalid},
+		{"other purpose", s, token, "verify-email", "hash-1", now, ErrInvalid},
+		{"other key", &Signer{Key: []byte("other")}, token, PasswordReset, "hash-1", now, ErrInvalid},
+		{"garbage", s, "not-a-token", PasswordReset, "hash-1", now, ErrInvalid},
+		{"tampered subject", s, tampered, PasswordReset, "hash-1", now, ErrInvalid},
+	}
+
+	for _, e := range tests {
+		err := e.signer.Verify(e.token, e.purpose, e.binding, e.now)
+		if err != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, err)
+		}
+	}
+}
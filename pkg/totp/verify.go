@@ -0,0 +1,18 @@
+package totp
+
+import (
+	"time"
+	"webapp/pkg/data"
+	"webapp/pkg/repository"
+)
+
+// Verify checks code as the second factor of the user enrolled with t. The code may be a TOTP code
+// or one of the user's recovery codes. Accepted codes are used up: a TOTP code can't be replayed,
+// and a recovery code works only once.
+func Verify(repo repository.DatabaseRepo, t *data.UserTOTP, code string, now time.Time) (bool, error) {
+	if step, ok := Validate(t.Secret, code, now); ok {
+		return repo.UseTOTPStep(t.UserID, step)
+	}
+
+	return repo.UseRecoveryCode(t.UserID, HashRecoveryCode(code))
+}
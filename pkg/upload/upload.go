@@ -0,0 +1,181 @@
+// Package upload stores the files of multipart requests, like the profile pictures uploaded to the
+// web app and the api.
+package upload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ImageTypes are the content types of the images users may upload.
+var ImageTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// Errors of Save, for the caller to pick a status code.
+var (
+	ErrTooBig          = errors.New("the upload is too big")
+	ErrNoFile          = errors.New("the upload contains no file")
+	ErrTooManyFiles    = errors.New("the upload contains more than one file")
+	ErrUnsupportedType = errors.New("unsupported file type")
+)
+
+// extensions are the file name extensions of the files stored, by content type
+var extensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// File is one stored file. It is stored under a random name of its own, so that uploads never
+// replace each other; the name the client gave it is kept for reference only.
+type File struct {
+	FileName         string
+	OriginalFileName string
+	FileSize         int64
+	ContentType      string // sniffed from the content, not taken from the request
+}
+
+// Uploader stores the files of requests in Dir. Requests may be at most MaxBytes large, and the
+// files must be of one of Types, if any are given.
+type Uploader struct {
+	Dir      string
+	MaxBytes int64
+	Types    []string
+}
+
+// Save stores every file of a multipart request. It stops at the first file which fails; the
+// files stored until then are kept.
+func (u *Uploader) Save(r *http.Request) ([]*File, error) {
+	headers, err := u.parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*File
+	for _, header := range headers {
+		file, err := u.save(header)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// SaveOne stores the file of a multipart request which must hold exactly one, and returns
+// ErrTooManyFiles without storing any if there are more.
+func (u *Uploader) SaveOne(r *http.Request) (*File, error) {
+	headers, err := u.parse(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) > 1 {
+		return nil, ErrTooManyFiles
+	}
+
+	return u.save(headers[0])
+}
+
+// parse reads a multipart request, and returns the headers of its files; at least one
+func (u *Uploader) parse(r *http.Request) ([]*multipart.FileHeader, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, u.MaxBytes)
+	err := r.ParseMultipartForm(u.MaxBytes)
+	if errors.Is(err, http.ErrNotMultipart) {
+		return nil, ErrNoFile
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w, and must be less than %d bytes", ErrTooBig, u.MaxBytes)
+	}
+
+	var headers []*multipart.FileHeader
+	for _, fieldHeaders := range r.MultipartForm.File {
+		headers = append(headers, fieldHeaders...)
+	}
+
+	if len(headers) == 0 {
+		return nil, ErrNoFile
+	}
+
+	return headers, nil
+}
+
+// save stores one file, after checking its type
+func (u *Uploader) save(header *multipart.FileHeader) (*File, error) {
+	in, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	// DetectContentType looks at no more than the first 512 bytes
+	head := make([]byte, 512)
+	n, err := io.ReadFull(in, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	if len(u.Types) > 0 && !allowed(contentType, u.Types) {
+		return nil, fmt.Errorf("%w %s, expected one of %v", ErrUnsupportedType, contentType, u.Types)
+	}
+
+	// file names come from clients and may clash, so the file gets a name of its own
+	name, err := newFileName(contentType)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(u.Dir, name)
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	size, err := io.Copy(out, io.MultiReader(bytes.NewReader(head), in))
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+
+	return &File{FileName: name, OriginalFileName: filepath.Base(header.Filename), FileSize: size, ContentType: contentType}, nil
+}
+
+// newFileName returns a random file name, with the extension of the content type
+func newFileName(contentType string) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b) + extensions[contentType], nil
+}
+
+// Remove removes a stored file. Files which are already gone are not an error.
+func (u *Uploader) Remove(name string) error {
+	// names come from the database; never let one point outside the upload directory
+	err := os.Remove(filepath.Join(u.Dir, filepath.Base(name)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func allowed(contentType string, types []string) bool {
+	for _, t := range types {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// multipartRequest returns a request uploading content as a file named name
+func multipartRequest(t *testing.T, name string, content []byte) *http.Request {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	part, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = part.Write(content)
+	_ = mw.Close()
+
+	req := httptest.NewRequest("POST", "/", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestUploader_Save(t *testing.T) {
+	img := new(bytes.Buffer)
+	_ = png.Encode(img, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+
+	tests := []struct {
+		name        string
+		fileName    string
+		content     []byte
+		maxBytes    int64
+		expectedErr error
+		stored      string
+	}{
+		{"png", "avatar.png", img.Bytes(), 1 << 20, nil, "avatar.png"},
+		{"path in file name", "../../avatar2.png", img.Bytes(), 1 << 20, nil, "avatar2.png"},
+		{"not an image", "avatar.png", []byte("<html><script>alert(1)</script></html>"), 1 << 20, ErrUnsupportedType, ""},
+		{"too big", "avatar.png", img.Bytes(), 64, ErrTooBig, ""},
+	}
+
+	for _, e := range tests {
+		dir := t.TempDir()
+		u := &Uploader{Dir: dir, MaxBytes: e.maxBytes, Types: ImageTypes}
+
+		files, err := u.Save(multipartRequest(t, e.fileName, e.content))
+		if !errors.Is(err, e.expectedErr) {
+			t.Errorf("%s: expected error %v, but got %v", e.name, e.expectedErr, err)
+			continue
+		}
+
+		entries, _ := os.ReadDir(dir)
+		if e.stored == "" {
+			if len(entries) != 0 {
+				t.Errorf("%s: expected no file to be stored, but got %d", e.name, len(entries))
+			}
+			continue
+		}
+
+		if len(files) != 1 || files[0].OriginalFileName != e.stored || files[0].ContentType != "image/png" || files[0].FileSize != int64(len(e.content)) {
+			t.Errorf("%s: unexpected files %+v", e.name, files)
+			continue
+		}
+		if files[0].FileName == e.stored || filepath.Ext(files[0].FileName) != ".png" {
+			t.Errorf("%s: expected a new name with the extension of the type, but got %s", e.name, files[0].FileName)
+		}
+		stored, err := os.ReadFile(filepath.Join(dir, files[0].FileName))
+		if err != nil || !bytes.Equal(stored, e.content) {
+			t.Errorf("%s: expected %s to hold the upload, but got error %v", e.name, files[0].FileName, err)
+		}
+	}
+}
+
+func TestUploader_SaveOne(t *testing.T) {
+	img := new(bytes.Buffer)
+	_ = png.Encode(img, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+
+	dir := t.TempDir()
+	u := &Uploader{Dir: dir, MaxBytes: 1 << 20, Types: ImageTypes}
+
+	// uploads of the same name don't replace each other
+	first, err := u.SaveOne(multipartRequest(t, "avatar.png", img.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := u.SaveOne(multipartRequest(t, "avatar.png", img.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.FileName == second.FileName {
+		t.Errorf("expected uploads of the same name to be stored apart, but both are %s", first.FileName)
+	}
+
+	// more than one file is refused as a whole
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for _, field := range []string{"file", "other"} {
+		part, _ := mw.CreateFormFile(field, "avatar.png")
+		_, _ = part.Write(img.Bytes())
+	}
+	_ = mw.Close()
+	req := httptest.NewRequest("POST", "/", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	_, err = u.SaveOne(req)
+	if !errors.Is(err, ErrTooManyFiles) {
+		t.Errorf("expected %v, but got %v", ErrTooManyFiles, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("expected no more files to be stored, but got %d", len(entries))
+	}
+}
+
+func TestUploader_SaveWithoutFile(t *testing.T) {
+	u := &Uploader{Dir: t.TempDir(), MaxBytes: 1 << 20}
+
+	req := httptest.NewRequest("POST", "/", bytes.NewReader([]byte(`{}`)))
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err := u.Save(req)
+	if !errors.Is(err, ErrNoFile) {
+		t.Errorf("expected %v, but got %v", ErrNoFile, err)
+	}
+}
@@ -0,0 +1,71 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// PasswordPolicy describes the passwords users may choose.
+type PasswordPolicy struct {
+	MinLength  int // in characters
+	MaxBytes   int // bcrypt ignores everything after 72 bytes
+	MinClasses int // of lower case letters, upper case letters, digits and other characters
+}
+
+// DefaultPasswordPolicy is the policy of the api and the web app.
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 8, MaxBytes: 72, MinClasses: 2}
+
+// commonPasswords are refused whatever the policy; they are the first ones guessed.
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "12345678": true, "123456789": true, "1234567890": true,
+	"qwertyuiop": true, "iloveyou": true, "sunshine": true, "football": true, "baseball": true,
+	"letmein1": true, "welcome1": true, "admin123": true, "passw0rd": true, "p@ssw0rd": true,
+}
+
+// Check returns a message for each rule password breaks, or nothing if it is acceptable.
+// The password must not contain any of the personal values of at least 4 characters, compared
+// case insensitively.
+func (p PasswordPolicy) Check(password string, personal ...string) []string {
+	var problems []string
+
+	if !MinLength(password, p.MinLength) {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		problems = append(problems, fmt.Sprintf("must be at most %d bytes long", p.MaxBytes))
+	}
+	if classes := characterClasses(password); classes < p.MinClasses {
+		problems = append(problems, fmt.Sprintf("must mix at least %d of lower case letters, upper case letters, digits and other characters", p.MinClasses))
+	}
+
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		problems = append(problems, "is too common")
+	}
+	for _, value := range personal {
+		if len(value) >= 4 && strings.Contains(lower, strings.ToLower(value)) {
+			problems = append(problems, "must not contain your name or email address")
+			break
+		}
+	}
+
+	return problems
+}
+
+func characterClasses(s string) int {
+	var lower, upper, digit, other int
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
@@ -0,0 +1,117 @@
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Struct validates the exported string and []string fields of the struct s points to, following
+// their validate tags. Rules are separated by commas:
+//
+//	required    the field must not be blank (a slice must not be empty)
+//	email       the field must be an email address
+//	min=N       the field must have at least N characters
+//	max=N       the field must have at most N characters
+//	oneof=a b   the field must be one of the listed values
+//	password    the field must satisfy DefaultPasswordPolicy
+//
+// All rules but required accept blank values, and apply to each element of a slice. Errors are
+// reported under the json name of a field, or its form tag, or else its Go name.
+func Struct(s interface{}) Errors {
+	v := New()
+	v.Struct(s)
+
+	if v.Valid() {
+		return nil
+	}
+	return v.Errors
+}
+
+// Struct validates s like the package level Struct, adding to the errors of v.
+func (v *Validator) Struct(s interface{}) {
+	value := reflect.Indirect(reflect.ValueOf(s))
+	typ := value.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+
+		name := fieldName(field)
+
+		var values []string
+		switch f := value.Field(i); f.Kind() {
+		case reflect.String:
+			values = []string{f.String()}
+		case reflect.Slice:
+			if f.Type().Elem().Kind() != reflect.String {
+				panic(fmt.Sprintf("validation: unsupported field type %s of %s", f.Type(), field.Name))
+			}
+			for j := 0; j < f.Len(); j++ {
+				values = append(values, f.Index(j).String())
+			}
+		default:
+			panic(fmt.Sprintf("validation: unsupported field type %s of %s", f.Type(), field.Name))
+		}
+
+		for _, rule := range strings.Split(tag, ",") {
+			applyRule(v, name, rule, field.Type.Kind() == reflect.Slice, values)
+		}
+	}
+}
+
+func applyRule(v *Validator, name, rule string, isSlice bool, values []string) {
+	rule, arg, _ := strings.Cut(rule, "=")
+
+	if rule == "required" {
+		if isSlice {
+			v.Check(len(values) > 0, name, "must not be empty")
+		} else {
+			v.Required(name, values[0])
+		}
+		return
+	}
+
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+
+		switch rule {
+		case "email":
+			v.Email(name, value)
+		case "min":
+			v.Length(name, value, mustAtoi(rule, arg), 0)
+		case "max":
+			v.Length(name, value, 0, mustAtoi(rule, arg))
+		case "oneof":
+			options := strings.Fields(arg)
+			v.Check(In(value, options...), name, "must be one of "+strings.Join(options, ", "))
+		case "password":
+			v.Password(name, value, DefaultPasswordPolicy)
+		default:
+			panic(fmt.Sprintf("validation: unknown rule %q", rule))
+		}
+	}
+}
+
+func mustAtoi(rule, arg string) int {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		panic(fmt.Sprintf("validation: rule %s needs a number, got %q", rule, arg))
+	}
+	return n
+}
+
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "form"} {
+		if name, _, _ := strings.Cut(field.Tag.Get(key), ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
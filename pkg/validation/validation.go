@@ -0,0 +1,131 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Errors maps field names to the problems found with them.
+type Errors map[string][]string
+
+// Add adds an error message for a given field.
+func (e Errors) Add(field, message string) {
+	e[field] = append(e[field], message)
+}
+
+// Get returns the first error message of a field, or the empty string.
+func (e Errors) Get(field string) string {
+	if len(e[field]) == 0 {
+		return ""
+	}
+	return e[field][0]
+}
+
+// Error lists every problem, field by field, so that Errors can be returned as an error.
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var problems []string
+	for _, field := range fields {
+		for _, message := range e[field] {
+			problems = append(problems, field+": "+message)
+		}
+	}
+	return strings.Join(problems, "; ")
+}
+
+// Validator collects the errors of one payload or form.
+type Validator struct {
+	Errors Errors
+}
+
+// New returns a validator without errors.
+func New() *Validator {
+	return &Validator{Errors: Errors{}}
+}
+
+// Check adds message to field unless ok.
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.Errors.Add(field, message)
+	}
+}
+
+// Valid reports whether no check failed.
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// Err returns the errors found, or nil if there are none.
+func (v *Validator) Err() error {
+	if v.Valid() {
+		return nil
+	}
+	return v.Errors
+}
+
+// NotBlank reports whether s holds anything but white space.
+func NotBlank(s string) bool {
+	return strings.TrimSpace(s) != ""
+}
+
+// MinLength reports whether s has at least n characters.
+func MinLength(s string, n int) bool {
+	return utf8.RuneCountInString(s) >= n
+}
+
+// MaxLength reports whether s has at most n characters.
+func MaxLength(s string, n int) bool {
+	return utf8.RuneCountInString(s) <= n
+}
+
+// IsEmail reports whether s is a bare email address, like jane@example.com.
+func IsEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s && strings.Contains(s[strings.LastIndex(s, "@"):], ".")
+}
+
+// In reports whether s is one of options.
+func In(s string, options ...string) bool {
+	for _, option := range options {
+		if s == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Password checks password against policy, and adds a message to field for each rule it breaks.
+// Personal values of the user, like the email address, must not be used as password.
+func (v *Validator) Password(field, password string, policy PasswordPolicy, personal ...string) {
+	for _, problem := range policy.Check(password, personal...) {
+		v.Errors.Add(field, problem)
+	}
+}
+
+// Email checks that field holds a valid email address.
+func (v *Validator) Email(field, value string) {
+	v.Check(IsEmail(value), field, "must be a valid email address")
+}
+
+// Required checks that value is not blank.
+func (v *Validator) Required(field, value string) {
+	v.Check(NotBlank(value), field, "must not be blank")
+}
+
+// Length checks that value has between min and max characters; max 0 means no upper limit.
+func (v *Validator) Length(field, value string, min, max int) {
+	if min > 0 {
+		v.Check(MinLength(value, min), field, fmt.Sprintf("must be at least %d characters long", min))
+	}
+	if max > 0 {
+		v.Check(MaxLength(value, max), field, fmt.Sprintf("must be at most %d characters long", max))
+	}
+}
@@ -0,0 +1,114 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsEmail(t *testing.T) {
+	var tests = []struct {
+		email    string
+		expected bool
+	}{
+		{"jane@example.com", true},
+		{"jane.doe+tag@mail.example.com", true},
+		{"jane", false},
+		{"jane@localhost", false},
+		{"Jane <jane@example.com>", false},
+		{"", false},
+	}
+
+	for _, e := range tests {
+		if IsEmail(e.email) != e.expected {
+			t.Errorf("%q: expected %v", e.email, e.expected)
+		}
+	}
+}
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	var tests = []struct {
+		name     string
+		password string
+		personal []string
+		problems []string
+	}{
+		{"valid", "correct horse 1", nil, nil},
+		{"too short", "ab1", nil, []string{"at least 8 characters"}},
+		{"too long", strings.Repeat("a1", 40), nil, []string{"at most 72 bytes"}},
+		{"one class", "abcdefghij", nil, []string{"must mix"}},
+		{"common", "Password1", nil, []string{"too common"}},
+		{"personal", "Jane.Doe99", []string{"jane", "doe"}, []string{"your name"}},
+		{"short personal values are ignored", "Jim.Doe1999", []string{"jim", "doe"}, nil},
+	}
+
+	for _, e := range tests {
+		problems := DefaultPasswordPolicy.Check(e.password, e.personal...)
+		if len(problems) != len(e.problems) {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.problems, problems)
+			continue
+		}
+		for i := range problems {
+			if !strings.Contains(problems[i], e.problems[i]) {
+				t.Errorf("%s: expected %q in %q", e.name, e.problems[i], problems[i])
+			}
+		}
+	}
+}
+
+func TestStruct(t *testing.T) {
+	type payload struct {
+		Name     string   `json:"name,omitempty" validate:"required,max=5"`
+		Email    string   `form:"mail" validate:"email"`
+		Code     string   `validate:"min=3"`
+		Roles    []string `json:"roles" validate:"required,oneof=admin user"`
+		Password string   `json:"-" validate:"password"`
+		Ignored  string
+	}
+
+	var tests = []struct {
+		name     string
+		payload  payload
+		expected map[string]string
+	}{
+		{"valid", payload{Name: "Jane", Email: "jane@example.com", Roles: []string{"user"}}, nil},
+		{"blank name", payload{Name: "  ", Roles: []string{"user"}}, map[string]string{"name": "must not be blank"}},
+		{"long name", payload{Name: "Johnny", Roles: []string{"user"}}, map[string]string{"name": "must be at most 5 characters long"}},
+		{"bad email", payload{Name: "Jane", Email: "jane", Roles: []string{"user"}}, map[string]string{"mail": "must be a valid email address"}},
+		{"short code", payload{Name: "Jane", Code: "ab", Roles: []string{"user"}}, map[string]string{"Code": "must be at least 3 characters long"}},
+		{"no roles", payload{Name: "Jane"}, map[string]string{"roles": "must not be empty"}},
+		{"unknown role", payload{Name: "Jane", Roles: []string{"user", "root"}}, map[string]string{"roles": "must be one of admin, user"}},
+		{"weak password", payload{Name: "Jane", Roles: []string{"user"}, Password: "abc"}, map[string]string{"Password": "must be at least 8 characters long"}},
+	}
+
+	for _, e := range tests {
+		errs := Struct(&e.payload)
+		if len(errs) != len(e.expected) {
+			t.Errorf("%s: expected %v, but got %v", e.name, e.expected, errs)
+			continue
+		}
+		for field, message := range e.expected {
+			if errs.Get(field) != message {
+				t.Errorf("%s: expected %q for %s, but got %q", e.name, message, field, errs.Get(field))
+			}
+		}
+	}
+}
+
+func TestErrors_Error(t *testing.T) {
+	v := New()
+	v.Required("name", "")
+	v.Email("email", "jane")
+	v.Check(false, "email", "is taken")
+
+	err := v.Err()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if err.Error() != "email: must be a valid email address; email: is taken; name: must not be blank" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+
+	if New().Err() != nil {
+		t.Error("expected no error without problems")
+	}
+}
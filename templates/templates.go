@@ -0,0 +1,10 @@
+// Package templates holds the page templates of the web app, so that they are built into the
+// binary and found whatever its working directory.
+package templates
+
+import "embed"
+
+// FS holds the layouts (*.layout.gohtml), partials (*.partial.gohtml) and pages (*.page.gohtml).
+//
+//go:embed *.gohtml
+var FS embed.FS